@@ -6,9 +6,11 @@ CREATE TABLE IF NOT EXISTS "learnt" (
 );
 CREATE TABLE IF NOT EXISTS "proposal" (
 	"turn_id"	INTEGER,
-	"pid"	INTEGER,
-	"seq"	INTEGER,
-	"value"	TEXT,
+	"promised_pid"	INTEGER,
+	"promised_seq"	INTEGER,
+	"accepted_pid"	INTEGER,
+	"accepted_seq"	INTEGER,
+	"accepted_value"	TEXT,
 	PRIMARY KEY("turn_id")
 );
 COMMIT;
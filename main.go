@@ -2,18 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-paxos/paxos"
 	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -127,14 +130,47 @@ func resetAllProposalsHandler(w http.ResponseWriter, _ *http.Request) {
 
 }
 
+// getConflictsHandler handles GET requests on /learner/get_conflicts.
+// This route provides a way to retrieve the refused attempts to learn a value different from the one already learnt.
+func getConflictsHandler(w http.ResponseWriter, _ *http.Request) {
+	m := paxos.GetConflicts()
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(m))
+}
+
+// pendingLearnsHandler handles GET requests on /learner/pending.
+// This route provides a way to retrieve the learn requests sent by this node which are still waiting for an acknowledgement, grouped by peer.
+func pendingLearnsHandler(w http.ResponseWriter, _ *http.Request) {
+	pending := paxos.GetPendingLearns()
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(pending))
+}
+
 // getLearntValueHandler handles GET requests on /node/get_learnt_value and /learner/get_learnt_value
 // This route provides a way to retrieve any learnt value.
+// When index is given, the single value at position (turn_id, index) is retrieved instead, see 'batcher.go'.
 func getLearntValueHandler(w http.ResponseWriter, r *http.Request) {
 
 	_ = r.ParseForm()
 	turnID, _ := strconv.Atoi(r.Form.Get("turn_id"))
 
-	getLearntRequest := paxos.GetLearntValue(turnID)
+	var getLearntRequest messages.GenericMessage
+	if r.Form.Get("index") != "" {
+		index, _ := strconv.Atoi(r.Form.Get("index"))
+		getLearntRequest = paxos.GetLearntEntry(turnID, index)
+	} else {
+		getLearntRequest = paxos.GetLearntValue(turnID)
+	}
 
 	// adding response headers
 	paxos.EnableCors(&w)
@@ -146,20 +182,26 @@ func getLearntValueHandler(w http.ResponseWriter, r *http.Request) {
 
 // getAllLearntValuesHandler handles GET requests on /node/get_all_learnt_values and /learner/get_all_learnt_values.
 // This route provides a way to retrieve the list of the stored learnt values.
-func getAllLearntValuesHandler(w http.ResponseWriter, _ *http.Request) {
-	m := queries.GetAllLearntValues()
+// With entries=true the batches are expanded, each value is listed with its position (turn_id, index).
+func getAllLearntValuesHandler(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
 
 	// adding response headers
 	paxos.EnableCors(&w)
 	paxos.AddContentTypeJson(&w)
 
 	// json encoding
-	_, _ = fmt.Fprint(w, paxos.ToJson(m))
+	if r.Form.Get("entries") == "true" {
+		_, _ = fmt.Fprint(w, paxos.ToJson(paxos.GetAllLearntEntries()))
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(queries.GetAllLearntValues()))
+	}
 
 }
 
 // setLearntValueHandler handles GET requests on /node/set_learnt_value.
-// This route provides a way to insert/update any learnt value.
+// This route provides a way to insert any learnt value. Learnt values are written once, a different value is refused with a 409.
+// Passing override=true together with a reason replaces the learnt value anyway, the override is logged for auditing.
 func setLearntValueHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
@@ -171,13 +213,19 @@ func setLearntValueHandler(w http.ResponseWriter, r *http.Request) {
 	turnID, _ := strconv.Atoi(r.Form.Get("turn_id"))
 	v := r.Form.Get("v")
 
-	err = queries.SetLearntValue(turnID, v) // value set forcefully
+	if r.Form.Get("override") == "true" {
+		err = queries.OverrideLearntValue(turnID, v, r.Form.Get("reason")) // value set forcefully
+	} else {
+		err = queries.SetLearntValue(turnID, v)
+	}
 
 	// adding response headers
 	paxos.EnableCors(&w)
 	paxos.AddContentTypeJson(&w)
 
-	if err != nil {
+	if _, isConflict := err.(*queries.LearntConflictError); isConflict {
+		http.Error(w, err.Error(), 409)
+	} else if err != nil {
 		http.Error(w, err.Error(), 500)
 		_, _ = fmt.Fprintf(w, "{ \"message\": \"%s\"}", err.Error())
 	} else {
@@ -284,6 +332,126 @@ func sendLearnHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// pipelineHandler handles GET requests on /proposer/pipeline.
+// This route provides a way to retrieve the turn ids client submits have in flight, and the phase each of them is in.
+func pipelineHandler(w http.ResponseWriter, _ *http.Request) {
+	pipeline := paxos.GetPipeline()
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(pipeline))
+}
+
+// sendRangePrepareHandler handles GET requests on /proposer/send_range_prepare.
+// This route provides a way to trigger a range prepare request, i.e. to try to become the leader for every turn id >= turn_id.
+func sendRangePrepareHandler(w http.ResponseWriter, r *http.Request) {
+
+	_ = r.ParseForm()
+
+	turnID, _ := strconv.Atoi(r.Form.Get("turn_id"))
+	seq, _ := strconv.Atoi(r.Form.Get("seq"))
+
+	messageToUser := paxos.SendRangePrepare(turnID, seq)
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	_, _ = fmt.Fprintf(w, "{ \"message\": \"%s\" }", messageToUser)
+}
+
+/*
+# ========================================================= #
+#                      CLIENT HANDLERS                      #
+# ========================================================= #
+*/
+
+// submitHandler handles GET requests on /client/submit.
+// This route provides a way to append a value to the replicated log, the response holds the turn id the value has been learnt for.
+// The request blocks until the value is learnt or the timeout (in seconds, submit_timeout by default) expires.
+// When a leader has been elected (see 'election' in the '.yaml' file) the value is forwarded to it.
+func submitHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	v := r.Form.Get("v")
+	timeout := config.CONF.SUBMIT_TIMEOUT
+	if r.Form.Get("timeout") != "" {
+		seconds, err := strconv.Atoi(r.Form.Get("timeout"))
+		if err != nil || seconds <= 0 {
+			http.Error(w, "timeout must be a positive number of seconds", 400)
+			return
+		}
+		timeout = time.Duration(seconds)
+	}
+
+	// nodes that are not the leader forward the value to it, the leader never forwards it again
+	var submitResponse messages.SubmitResponse
+	deadline := time.Now().Add(timeout * time.Second)
+	if leaderURL, isRemote := paxos.LeaderURL(); isRemote && r.Form.Get("forwarded") != "true" {
+		submitResponse, err = paxos.SubmitToLeader(leaderURL, v, deadline)
+	} else {
+		submitResponse, err = paxos.Submit(v, deadline)
+	}
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if err == paxos.ErrSubmitDeadline {
+		http.Error(w, err.Error(), 504)
+	} else if errors.Is(err, paxos.ErrSubmitOutcomeUnknown) {
+		http.Error(w, err.Error(), 502)
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(submitResponse))
+	}
+}
+
+// readHandler handles GET requests on /client/read.
+// This route provides a way to read the value learnt for turn_id, or the last learnt value when turn_id is not given.
+// The consistency parameter trades latency for freshness: "local" reads the local database, "quorum" (the default) reads from a majority of learners,
+// "lease" is served by the leader alone while it holds its lease and falls back to "quorum" otherwise.
+// A "quorum" read sees every completed /client/submit; a value chosen through the /proposer routes might be missed until it reaches a majority of the learners.
+// A "lease" read assumes the clocks of the nodes run at about the same rate: the leader trusts its lease for half of leader_timeout.
+func readHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	turnID := 0
+	if r.Form.Get("turn_id") != "" {
+		turnID, err = strconv.Atoi(r.Form.Get("turn_id"))
+		if err != nil || turnID <= 0 {
+			http.Error(w, "turn_id must be a positive integer", 400)
+			return
+		}
+	}
+
+	readResponse, err := paxos.Read(turnID, r.Form.Get("consistency"))
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if err == paxos.ErrUnknownConsistency {
+		http.Error(w, err.Error(), 400)
+	} else if err != nil {
+		http.Error(w, err.Error(), 503)
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(readResponse))
+	}
+}
+
 /*
 # ========================================================= #
 #                     ACCEPTOR HANDLERS                     #
@@ -350,6 +518,66 @@ func receiveAcceptHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprint(w, paxos.ToJson(acceptResponse))
 }
 
+// receiveRangePrepareHandler handles POST requests on /acceptor/receive_range_prepare.
+// This route provides a way to handle the range prepare requests of a would-be leader.
+func receiveRangePrepareHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal POST body
+	rangePrepareRequest := messages.RangePrepareRequest{}
+	err = json.Unmarshal(b, &rangePrepareRequest)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	rangePrepareResponse := paxos.ReceiveRangePrepare(rangePrepareRequest)
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(rangePrepareResponse))
+}
+
+// confirmAcceptedHandler handles POST requests on /acceptor/confirm_accepted.
+// This route provides a way for the learners to check the accepted messages sent on behalf of this node, see learn_from_accepted.
+func confirmAcceptedHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal POST body
+	acceptedMessage := messages.AcceptedMessage{}
+	err = json.Unmarshal(b, &acceptedMessage)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	confirmResponse := paxos.ConfirmAccepted(acceptedMessage)
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(confirmResponse))
+}
+
 /*
 # ========================================================= #
 #                     LEARNER HANDLERS                      #
@@ -386,6 +614,96 @@ func receiveLearnHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprint(w, paxos.ToJson(learnResponse))
 }
 
+// receiveGossipHandler handles POST requests on /learner/receive_gossip.
+// This route provides a way to receive the values gossiped by the other learners, see dissemination.
+func receiveGossipHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal body
+	gossipMessage := messages.GossipMessage{}
+	err = json.Unmarshal(b, &gossipMessage)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	gossipResponse := paxos.ReceiveGossip(gossipMessage)
+
+	// adding headers, CORS may be removed
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(gossipResponse))
+}
+
+// receiveAcceptedHandler handles POST requests on /learner/receive_accepted.
+// This route provides a way to count the accepted messages of the acceptors, see learn_from_accepted.
+func receiveAcceptedHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal body
+	acceptedMessage := messages.AcceptedMessage{}
+	err = json.Unmarshal(b, &acceptedMessage)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	acceptedResponse := paxos.ReceiveAccepted(acceptedMessage)
+
+	// adding headers, CORS may be removed
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(acceptedResponse))
+}
+
+// receiveReadHandler handles POST requests on /learner/receive_read.
+// This route provides a way to handle the read requests of quorum reads.
+func receiveReadHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal body
+	readRequest := messages.ReadRequest{}
+	err = json.Unmarshal(b, &readRequest)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	readResponse := paxos.ReceiveRead(readRequest)
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(readResponse))
+}
+
 /*
 # ========================================================= #
 #                      SEEKER HANDLERS                      #
@@ -452,6 +770,7 @@ func welcomeHandler(w http.ResponseWriter, _ *http.Request) {
 }
 
 // info handles GET requests to route /info and returns a string containing the execution mode, the PID of the node, and the language this client is written in.
+// The pid and the mode are returned as fields of their own as well, see messages.Info.
 func infoHandler(w http.ResponseWriter, _ *http.Request) {
 	language := "golang"
 	var mode string
@@ -466,8 +785,8 @@ func infoHandler(w http.ResponseWriter, _ *http.Request) {
 	paxos.EnableCors(&w)
 	paxos.AddContentTypeJson(&w)
 
-	// json encoding
-	_, _ = fmt.Fprintf(w, "{ \"message\": \"%s@%s@%d\" }", language, mode, config.CONF.PID)
+	info := messages.Info{Message: fmt.Sprintf("%s@%s@%d", language, mode, config.CONF.PID), PID: config.CONF.PID, Mode: mode}
+	_, _ = fmt.Fprint(w, paxos.ToJson(info))
 }
 
 func startSeekingForeverHandler(w http.ResponseWriter, _ *http.Request) {
@@ -499,6 +818,206 @@ func seek4ever() {
 
 }
 
+// snapshotHandler handles GET requests on /node/snapshot.
+// This route provides a way to retrieve the current snapshot, passing checkpoint=true takes a new checkpoint first.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	var err error
+	var snap messages.Snapshot
+	if r.Form.Get("checkpoint") == "true" {
+		snap, err = queries.Checkpoint(config.CONF.COMPACTION.RETAIN)
+	} else {
+		snap = queries.GetSnapshot()
+	}
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		_, _ = fmt.Fprintf(w, "{ \"message\": \"%s\"}", err.Error())
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(snap))
+	}
+}
+
+// fsckHandler handles GET requests on /node/fsck.
+// This route provides a way to check the consistency of the storage, passing repair=true also fixes what can be fixed safely.
+func fsckHandler(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	report, err := queries.Fsck(r.Form.Get("repair") == "true")
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		_, _ = fmt.Fprintf(w, "{ \"message\": \"%s\"}", err.Error())
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(report))
+	}
+}
+
+// leaderHandler handles GET requests on /node/leader.
+// This route provides a way to retrieve the leader this node believes in.
+func leaderHandler(w http.ResponseWriter, _ *http.Request) {
+	leader := paxos.GetLeader()
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(leader))
+}
+
+// membershipHandler handles GET requests on /node/membership.
+// This route provides a way to retrieve the history of the nodes of the cluster, or the nodes in effect for turn_id when it's given.
+func membershipHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if r.Form.Get("turn_id") == "" {
+		_, _ = fmt.Fprint(w, paxos.ToJson(paxos.GetMembershipHistory()))
+		return
+	}
+	turnID, err := strconv.Atoi(r.Form.Get("turn_id"))
+	if err != nil || turnID <= 0 {
+		http.Error(w, "turn_id must be a positive integer", 400)
+		return
+	}
+	_, _ = fmt.Fprint(w, paxos.ToJson(paxos.MembershipForTurn(turnID)))
+}
+
+// proposeMembershipHandler handles GET requests on /node/membership/propose.
+// This route provides a way to add (op=add) or remove (op=remove) the node at url, id, roles (comma separated), weight and zone are optional.
+// A node with an id can be removed through its id alone.
+// The change is agreed on as the value of a turn id and takes effect membership_delay turn ids later.
+func proposeMembershipHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	change := messages.MembershipChange{
+		Op:   r.Form.Get("op"),
+		URL:  r.Form.Get("url"),
+		Zone: r.Form.Get("zone"),
+	}
+	if r.Form.Get("id") != "" {
+		change.ID, err = strconv.Atoi(r.Form.Get("id"))
+		if err != nil || change.ID <= 0 {
+			http.Error(w, "id must be a positive integer", 400)
+			return
+		}
+	}
+	if r.Form.Get("roles") != "" {
+		change.Roles = strings.Split(r.Form.Get("roles"), ",")
+	}
+	if r.Form.Get("weight") != "" {
+		change.Weight, err = strconv.Atoi(r.Form.Get("weight"))
+		if err != nil || change.Weight <= 0 {
+			http.Error(w, "weight must be a positive integer", 400)
+			return
+		}
+	}
+	timeout := config.CONF.SUBMIT_TIMEOUT
+	if r.Form.Get("timeout") != "" {
+		seconds, err := strconv.Atoi(r.Form.Get("timeout"))
+		if err != nil || seconds <= 0 {
+			http.Error(w, "timeout must be a positive number of seconds", 400)
+			return
+		}
+		timeout = time.Duration(seconds)
+	}
+
+	membershipEpoch, err := paxos.ProposeMembershipChange(change, time.Now().Add(timeout*time.Second))
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	if errors.Is(err, paxos.ErrInvalidMembershipChange) {
+		http.Error(w, err.Error(), 400)
+	} else if err == paxos.ErrSubmitDeadline {
+		http.Error(w, err.Error(), 504)
+	} else if err != nil {
+		http.Error(w, err.Error(), 500)
+	} else {
+		_, _ = fmt.Fprint(w, paxos.ToJson(membershipEpoch))
+	}
+}
+
+// heartbeatHandler handles POST requests on /node/heartbeat.
+// This route provides a way to handle the heartbeats of the leader.
+func heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Read body
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	// Unmarshal POST body
+	heartbeat := messages.Heartbeat{}
+	err = json.Unmarshal(b, &heartbeat)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	knownLeader := paxos.ReceiveHeartbeat(heartbeat)
+
+	// adding response headers
+	paxos.EnableCors(&w)
+	paxos.AddContentTypeJson(&w)
+
+	// json encoding
+	_, _ = fmt.Fprint(w, paxos.ToJson(knownLeader))
+}
+
+// elect4ever sends heartbeats (or runs for leadership) every x seconds. The amount of seconds can be changed in the 'election' section of the '.yaml' file.
+func elect4ever() {
+	for {
+		time.Sleep(config.CONF.ELECTION.HEARTBEAT_INTERVAL * time.Second)
+		paxos.TickElection()
+	}
+}
+
+// deliver4ever sends again the learn requests which have not been acknowledged, it checks for them every x seconds.
+// The amount of seconds can be changed in the '.yaml' file (see learn_retry_interval).
+func deliver4ever() {
+	for {
+		time.Sleep(config.CONF.LEARN_RETRY_INTERVAL * time.Second)
+		paxos.RetryPendingLearns()
+	}
+}
+
+// compact4ever takes a checkpoint every x seconds. The amount of seconds can be changed in the 'compaction' section of the '.yaml' file.
+func compact4ever() {
+	for {
+		time.Sleep(config.CONF.COMPACTION.INTERVAL * time.Second)
+		_, err := queries.Checkpoint(config.CONF.COMPACTION.RETAIN)
+		if err != nil {
+			log.Print("[MAIN] -> Could not take a checkpoint: ", err.Error())
+		}
+	}
+}
+
 func init() {
 
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -513,26 +1032,69 @@ func init() {
 	config.CONF.LoadConfigFile(configPath)
 	config.CONF.FillEmptyFields()
 
-	// checking if database exists
-	info, err := os.Stat(config.CONF.DB_PATH)
-	if os.IsNotExist(err) {
-		// database does not exist, create the database and initialize it
-		_, err = os.Create(config.CONF.DB_PATH)
+	// nodes are looked up by id and by url, both have to be unique
+	err := config.CONF.CheckNodes()
+	if err != nil {
+		log.Fatalf("[ERROR] -> Invalid nodes: %v", err)
+	}
+
+	// a phase-1 quorum which does not intersect every phase-2 quorum could get two values chosen for the same turn id
+	err = quorum.Init(&config.CONF)
+	if err != nil {
+		log.Fatalf("[ERROR] -> Invalid quorums: %v", err)
+	}
+
+	// selecting the storage backend, an unknown db_type stops the node here
+	err = queries.PrepareDBConn(config.CONF.DB_TYPE)
+	if err != nil {
+		log.Fatalf("[ERROR] -> Could not prepare the %s storage: %v", config.CONF.DB_TYPE, err)
+	}
+
+	// tables are created when missing, existing ones are migrated to the current schema version
+	err = queries.InitDatabase()
+	if err != nil {
+		log.Fatalf("[ERROR] -> Could not initialize the %s storage: %v", config.CONF.DB_TYPE, err)
+	}
+
+	// checking the consistency of the storage, problems are reported (or repaired) instead of crashing the node later on
+	if config.CONF.FSCK_ON_STARTUP == "report" || config.CONF.FSCK_ON_STARTUP == "repair" {
+		report, err := queries.Fsck(config.CONF.FSCK_ON_STARTUP == "repair")
 		if err != nil {
-			log.Print("[ERROR] -> Could not create database. Something is wrong.")
-		} else {
-			// now i certainly have a db file.
-			queries.SQLitePrepareDBConn()
-			queries.InitDatabase()
+			log.Print("[MAIN] -> Could not check the storage: ", err.Error())
+		}
+		for _, problem := range report.Problems {
+			log.Printf("[MAIN] -> Fsck: %s %s (%s), repaired: %t.", problem.Kind, problem.Key, problem.Detail, problem.Repaired)
 		}
+	} else if config.CONF.FSCK_ON_STARTUP != "" {
+		log.Fatalf("[ERROR] -> Unknown fsck_on_startup %q, valid values are: report, repair.", config.CONF.FSCK_ON_STARTUP)
+	}
 
-		// might have other err cases i want to handle
-	} else if info.IsDir() {
-		// file does exist but it's a folder, exit and ask the user to change the filename.
-		log.Fatalf("[ERROR] -> %s is a folder. The database has NOT been created. Change filename and retry.", config.CONF.DB_PATH)
+	// a learnt value is spread either to every learner or to a few random ones
+	if config.CONF.DISSEMINATION != "flood" && config.CONF.DISSEMINATION != "gossip" {
+		log.Fatalf("[ERROR] -> Unknown dissemination %q, valid values are: flood, gossip.", config.CONF.DISSEMINATION)
+	}
+	if config.CONF.GOSSIP_FANOUT < 1 {
+		log.Fatalf("[ERROR] -> gossip_fanout must be positive, got %d.", config.CONF.GOSSIP_FANOUT)
 	}
 
-	queries.PrepareDBConn()
+	// sequence numbers are never used twice, not even across restarts; a random pid is kept across restarts as well
+	err = paxos.InitBallots()
+	if err != nil {
+		log.Fatalf("[ERROR] -> Could not load the ballots of this node: %v", err)
+	}
+	err = paxos.CheckPeerPIDs()
+	if err != nil {
+		log.Fatalf("[ERROR] -> Pid clash, please give this node a unique pid: %v", err)
+	}
+
+	// the nodes of the '.yaml' file are changed by the membership changes found in the log
+	paxos.InitMembership()
+
+	// the learn requests left unacknowledged by the previous run are sent again
+	err = paxos.InitDeliveries()
+	if err != nil {
+		log.Fatalf("[ERROR] -> Could not load the learn requests waiting for an acknowledgement: %v", err)
+	}
 }
 
 func main() {
@@ -555,29 +1117,61 @@ func main() {
 	http.HandleFunc("/node/reset_learnt_value", resetLearntValueHandler)
 	http.HandleFunc("/node/reset_all_learnt_values", resetAllLearntValuesHandler)
 
+	http.HandleFunc("/node/snapshot", snapshotHandler)
+	http.HandleFunc("/node/fsck", fsckHandler)
+	http.HandleFunc("/node/leader", leaderHandler)
+	http.HandleFunc("/node/heartbeat", heartbeatHandler)
+	http.HandleFunc("/node/membership", membershipHandler)
+
+	// a node only serves the routes of the roles it plays, see the 'roles' of its entry in the 'nodes' section
 	// PROPOSER ROUTES
-	http.HandleFunc("/proposer/send_prepare", sendPrepareHandler)
-	http.HandleFunc("/proposer/send_accept", sendAcceptHandler)
-	http.HandleFunc("/proposer/send_learn", sendLearnHandler)
+	if config.Members().SelfPlays("proposer") {
+		http.HandleFunc("/proposer/send_prepare", sendPrepareHandler)
+		http.HandleFunc("/proposer/send_accept", sendAcceptHandler)
+		http.HandleFunc("/proposer/send_learn", sendLearnHandler)
+		http.HandleFunc("/proposer/send_range_prepare", sendRangePrepareHandler)
+		http.HandleFunc("/proposer/pipeline", pipelineHandler)
+
+		http.HandleFunc("/node/membership/propose", proposeMembershipHandler)
+		http.HandleFunc("/client/submit", submitHandler)
+	}
+
+	// CLIENT ROUTES
+	http.HandleFunc("/client/read", readHandler) // --> reads are served by the learners, whatever the roles of this node
 
 	// SEEKER ROUTES
-	http.HandleFunc("/seeker/send_seek", sendSeekHandler)       // --> calls send seek manually
-	http.HandleFunc("/seeker/receive_seek", receiveSeekHandler) // --> calls send seek manually
+	if config.Members().SelfPlays("learner") {
+		http.HandleFunc("/seeker/send_seek", sendSeekHandler)       // --> calls send seek manually
+		http.HandleFunc("/seeker/receive_seek", receiveSeekHandler) // --> calls send seek manually
 
-	http.HandleFunc("/seeker/start_seeking_forever", startSeekingForeverHandler)
+		http.HandleFunc("/seeker/start_seeking_forever", startSeekingForeverHandler)
+	}
 
 	// ACCEPTOR ROUTES
-	http.HandleFunc("/acceptor/receive_prepare", receivePrepareHandler)
-	http.HandleFunc("/acceptor/receive_accept", receiveAcceptHandler)
+	if config.Members().SelfPlays("acceptor") {
+		http.HandleFunc("/acceptor/receive_prepare", receivePrepareHandler)
+		http.HandleFunc("/acceptor/receive_accept", receiveAcceptHandler)
+		http.HandleFunc("/acceptor/receive_range_prepare", receiveRangePrepareHandler)
+		http.HandleFunc("/acceptor/confirm_accepted", confirmAcceptedHandler)
+	}
 
 	// LEARNER ROUTES
-	http.HandleFunc("/learner/receive_learn", receiveLearnHandler)
-	http.HandleFunc("/learner/get_learnt_value", getLearntValueHandler)          // --> redundant, clone of /learner/get_learnt_value
-	http.HandleFunc("/learner/get_all_learnt_values", getAllLearntValuesHandler) // --> redundant, clone of /learner/get_all_learnt_values
+	if config.Members().SelfPlays("learner") {
+		http.HandleFunc("/learner/receive_learn", receiveLearnHandler)
+		http.HandleFunc("/learner/receive_accepted", receiveAcceptedHandler)
+		http.HandleFunc("/learner/receive_gossip", receiveGossipHandler)
+		http.HandleFunc("/learner/receive_read", receiveReadHandler)
+		http.HandleFunc("/learner/get_learnt_value", getLearntValueHandler)          // --> redundant, clone of /learner/get_learnt_value
+		http.HandleFunc("/learner/get_all_learnt_values", getAllLearntValuesHandler) // --> redundant, clone of /learner/get_all_learnt_values
+		http.HandleFunc("/learner/get_conflicts", getConflictsHandler)
+	}
+	http.HandleFunc("/learner/pending", pendingLearnsHandler) // --> proposers send learn requests as well, whatever the roles of this node
 
 	if !config.CONF.MANUAL_MODE {
 		log.Printf("[MAIN] -> Automatic Mode is activated for this node. Timeouts: Prepare -(%ds)-> Accept -(%ds)-> Learn.", config.CONF.WAIT_BEFORE_AUTOMATIC_REQUEST, config.CONF.WAIT_BEFORE_AUTOMATIC_REQUEST)
-		if config.CONF.SEEK_ACTIVE {
+		if config.CONF.SEEK_ACTIVE && !config.Members().SelfPlays("learner") {
+			log.Printf("[MAIN] -> Seeking is DEACTIVATED, this node does not play the learner role.")
+		} else if config.CONF.SEEK_ACTIVE {
 			log.Printf("[MAIN] -> Seeking is ACTIVATED and it will be performed every %d seconds", config.CONF.SEEK_TIMEOUT)
 			go seek4ever()
 		} else {
@@ -585,6 +1179,32 @@ func main() {
 		}
 	}
 
+	log.Printf("[MAIN] -> Unacknowledged learn requests are retried every %d seconds at first, every %d seconds at most.", config.CONF.LEARN_RETRY_INTERVAL, config.CONF.LEARN_RETRY_MAX_INTERVAL)
+	go deliver4ever()
+
+	if config.CONF.COMPACTION.ENABLED {
+		log.Printf("[MAIN] -> Compaction is ACTIVATED, a checkpoint will be taken every %d seconds.", config.CONF.COMPACTION.INTERVAL)
+		go compact4ever()
+	}
+
+	if config.CONF.ELECTION.ENABLED && !config.Members().SelfPlays("proposer") {
+		log.Print("[MAIN] -> Leader election is ACTIVATED, this node follows the heartbeats of the leader but never runs for leadership since it does not play the proposer role.")
+	} else if config.CONF.ELECTION.ENABLED {
+		log.Printf("[MAIN] -> Leader election is ACTIVATED, heartbeats every %d seconds, leader timeout %d seconds.", config.CONF.ELECTION.HEARTBEAT_INTERVAL, config.CONF.ELECTION.LEADER_TIMEOUT)
+		go elect4ever()
+	}
+
+	log.Printf("[MAIN] -> Pid: %d, highest sequence number used so far: %d.", config.CONF.PID, paxos.HighestSeq())
+	log.Printf("[MAIN] -> Quorum policy: %s, out of %d acceptor(s) among %d node(s).", quorum.Describe(), len(config.Members().ACCEPTORS), len(config.Members().NODES))
+	if self, found := config.Members().NodeByURL(config.CONF.SELF_URL); found {
+		log.Printf("[MAIN] -> Roles of this node: %s.", strings.Join(self.ROLES, ", "))
+	}
+	if config.CONF.LEARN_FROM_ACCEPTED {
+		log.Print("[MAIN] -> Learning from accepted messages is ACTIVATED, learn requests are not trusted.")
+	}
+	if config.CONF.DISSEMINATION == "gossip" {
+		log.Printf("[MAIN] -> Learnt values are gossiped to %d random learner(s).", config.CONF.GOSSIP_FANOUT)
+	}
 	log.Printf("[MAIN] -> Serving paxos on port %d.", config.CONF.PORT)
 	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(config.CONF.PORT), nil))
 
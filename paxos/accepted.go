@@ -0,0 +1,240 @@
+/*
+
+# Learning from accepted messages:
+By default a learner trusts any learn request it receives, a single buggy node (or a manual /proposer/send_learn)
+can make it learn a value which has never been chosen. When learn_from_accepted is enabled:
+
+	(a) Every acceptor, as soon as it accepts a proposal, sends accepted(turn id, number, value) to every learner;
+	---AND---
+	(b) A learner learns a value only once the acceptors which reported the very same proposal form a phase-2 quorum, i.e. once the value has been chosen.
+
+An accepted message names the acceptor it comes from, any node could send one on behalf of another acceptor though.
+The learner only counts it once the named acceptor, reached at its url in the 'nodes' section, confirms that it has accepted that very proposal.
+The tally of a turn id is kept until the turn id is learnt, for tallyLifetime at most; at most maxTallies turn ids are tallied at once.
+
+Learn requests are then only answered: a learner waits a little for the accepted messages of the value it's asked to learn,
+and refuses the request if the value has not been chosen by then. The seeker still learns the values other learners report, they have been verified by them.
+The proposers do not learn their values directly either: the accept responses they collect are counted as accepted messages,
+and a learnt value reported by an acceptor is only waited for (see learnAndFlood).
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tallyLifetime bounds the time the accepted messages of a turn id are counted for, a turn id not chosen by then is left to the seeker.
+const tallyLifetime = time.Minute
+
+// maxTallies bounds the number of turn ids the accepted messages are counted for at once, the oldest tallies are evicted first.
+const maxTallies = 1024
+
+// tallies holds, for each turn id not learnt yet, the acceptors which reported each accepted proposal.
+// The learners waiting for a turn id to be chosen wait on the channel of the turn id in chosen, it's closed once the turn id is learnt.
+// The entries of a turn id are deleted once it's learnt, whatever the source (see forgetTally), or once they are evicted (see evictTallies);
+// the channel is deleted once its last waiter gives up.
+var tallies = struct {
+	sync.Mutex
+	acceptors map[int]map[proposal.Proposal][]string
+	since     map[int]time.Time // since holds when the first accepted message of each turn id in acceptors was counted.
+	chosen    map[int]chan struct{}
+	waiters   map[int]int
+}{
+	acceptors: make(map[int]map[proposal.Proposal][]string),
+	since:     make(map[int]time.Time),
+	chosen:    make(map[int]chan struct{}),
+	waiters:   make(map[int]int),
+}
+
+// countAccepted counts @acceptors as having accepted @p for @turnID; once the acceptors reporting @p form a phase-2 quorum its value is learnt.
+// It returns the value learnt for @turnID, or an empty string if no value has been chosen yet.
+func countAccepted(turnID int, p proposal.Proposal, acceptors []string) (string, error) {
+	tallies.Lock()
+	if tallies.acceptors[turnID] == nil {
+		evictTallies(time.Now())
+		tallies.acceptors[turnID] = make(map[proposal.Proposal][]string)
+		tallies.since[turnID] = time.Now()
+	}
+	counted := tallies.acceptors[turnID][p]
+	for _, acceptor := range quorum.Without(acceptors, counted) {
+		counted = append(counted, acceptor)
+	}
+	tallies.acceptors[turnID][p] = counted
+	tallies.Unlock()
+	log.Printf("[LEARNER] -> %d acceptor(s) accepted pid: %d, seq: %d, v: %s for turn id %d so far.", len(counted), p.Pid, p.Seq, p.V, turnID)
+
+	if !quorumsForTurn(turnID).IsPhase2Quorum(counted) {
+		return "", nil
+	}
+
+	// learnValue forgets the tally of the turn id and wakes up its waiters
+	err := learnValue(turnID, p.V, "accepted messages")
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[LEARNER] -> A quorum of acceptors accepted '%s' for turn id %d, learning it.", p.V, turnID)
+	return p.V, nil
+}
+
+// forgetTally deletes the accepted proposals counted for @turnID and wakes up the learners waiting for it, it's called once @turnID is learnt.
+func forgetTally(turnID int) {
+	tallies.Lock()
+	defer tallies.Unlock()
+
+	if signal, exists := tallies.chosen[turnID]; exists {
+		close(signal)
+		delete(tallies.chosen, turnID)
+	}
+	delete(tallies.acceptors, turnID)
+	delete(tallies.since, turnID)
+}
+
+// evictTallies deletes the tallies counted for longer than tallyLifetime, then the oldest ones while there are maxTallies of them or more.
+// It makes room for a new tally, the caller must hold tallies lock.
+func evictTallies(now time.Time) {
+	var turnIDs []int
+	for turnID, since := range tallies.since {
+		if now.Sub(since) > tallyLifetime {
+			delete(tallies.acceptors, turnID)
+			delete(tallies.since, turnID)
+		} else {
+			turnIDs = append(turnIDs, turnID)
+		}
+	}
+	if len(turnIDs) < maxTallies {
+		return
+	}
+
+	sort.Slice(turnIDs, func(i, j int) bool { return tallies.since[turnIDs[i]].Before(tallies.since[turnIDs[j]]) })
+	for _, turnID := range turnIDs[:len(turnIDs)-maxTallies+1] {
+		log.Printf("[LEARNER] -> Too many turn ids tallied, forgetting the accepted messages of turn id %d.", turnID)
+		delete(tallies.acceptors, turnID)
+		delete(tallies.since, turnID)
+	}
+}
+
+// confirmAccepted tells whether the acceptor named by @acceptedMessage is an acceptor of the turn id and confirms it has accepted the reported proposal.
+// The acceptor is reached at its url in the 'nodes' section, the message itself might come from any node.
+func confirmAccepted(acceptedMessage messages.AcceptedMessage) bool {
+	turnID := acceptedMessage.TurnID
+	acceptor := acceptedMessage.Acceptor
+
+	isAcceptor := false
+	for _, node := range quorumsForTurn(turnID).Acceptors {
+		isAcceptor = isAcceptor || node == acceptor
+	}
+	if !isAcceptor {
+		return false
+	}
+	if acceptor == config.CONF.SELF_URL {
+		return hasAccepted(turnID, acceptedMessage.Proposal)
+	}
+
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	ch := make(chan []byte, 1)
+	sendPartialRequest(session, acceptor+"/acceptor/confirm_accepted", ch, acceptedMessage)
+	data := <-ch
+	if data == nil {
+		return false
+	}
+	confirmResponse := messages.GenericMessage{}
+	if err := json.Unmarshal(data, &confirmResponse); err != nil {
+		log.Print(err.Error())
+		return false
+	}
+	return confirmResponse.Body.Message == "accepted"
+}
+
+// ReceiveAccepted implements the learner's behaviour when receiving an accepted message, see learn_from_accepted.
+// Once the acceptor confirms it (see confirmAccepted) it's counted for the reported proposal; once the acceptors reporting it form a phase-2 quorum its value is learnt.
+func ReceiveAccepted(acceptedMessage messages.AcceptedMessage) messages.GenericMessage {
+	turnID := acceptedMessage.TurnID
+	p := acceptedMessage.Proposal
+
+	acceptedResponse := messages.GenericMessage{
+		TurnID: turnID,
+		Type:   "accepted_response",
+		Body: messages.Body{
+			Message:  "",
+			Proposal: p,
+			Learnt:   "",
+		},
+	}
+
+	if !config.CONF.LEARN_FROM_ACCEPTED {
+		acceptedResponse.Body.Message = "ignored, learn_from_accepted is disabled"
+		return acceptedResponse
+	}
+	if currentV := queries.GetLearntValue(turnID); currentV != "" {
+		acceptedResponse.Body.Message = "already learnt"
+		acceptedResponse.Body.Learnt = currentV
+		return acceptedResponse
+	}
+
+	if !confirmAccepted(acceptedMessage) {
+		log.Printf("[LEARNER] -> Dropping accepted message for turn id %d, %s did not confirm it accepted pid: %d, seq: %d, v: %s.", turnID, acceptedMessage.Acceptor, p.Pid, p.Seq, p.V)
+		acceptedResponse.Body.Message = "not confirmed"
+		return acceptedResponse
+	}
+	log.Printf("[LEARNER] -> Acceptor %s accepted pid: %d, seq: %d, v: %s for turn id %d.", acceptedMessage.Acceptor, p.Pid, p.Seq, p.V, turnID)
+	chosenV, err := countAccepted(turnID, p, []string{acceptedMessage.Acceptor})
+	if err != nil {
+		acceptedResponse.Body.Message = "Fail: " + err.Error()
+		return acceptedResponse
+	}
+	if chosenV == "" {
+		acceptedResponse.Body.Message = "counted"
+		return acceptedResponse
+	}
+	acceptedResponse.Body.Message = "chosen"
+	acceptedResponse.Body.Learnt = chosenV
+	return acceptedResponse
+}
+
+// awaitChosen waits for a value to be learnt for @turnID from the accepted messages, for at most @timeout.
+// It returns the learnt value, or an empty string if no value has been chosen in time.
+func awaitChosen(turnID int, timeout time.Duration) string {
+	if currentV := queries.GetLearntValue(turnID); currentV != "" {
+		return currentV
+	}
+
+	tallies.Lock()
+	signal, exists := tallies.chosen[turnID]
+	if !exists {
+		signal = make(chan struct{})
+		tallies.chosen[turnID] = signal
+	}
+	tallies.waiters[turnID] += 1
+	tallies.Unlock()
+
+	// the turn id might have been learnt before the channel was there to be closed
+	if queries.GetLearntValue(turnID) == "" {
+		select {
+		case <-signal:
+		case <-time.After(timeout):
+		}
+	}
+
+	tallies.Lock()
+	tallies.waiters[turnID] -= 1
+	if tallies.waiters[turnID] == 0 {
+		delete(tallies.waiters, turnID)
+		if tallies.chosen[turnID] == signal {
+			delete(tallies.chosen, turnID)
+		}
+	}
+	tallies.Unlock()
+	return queries.GetLearntValue(turnID)
+}
@@ -0,0 +1,108 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"testing"
+	"time"
+)
+
+// learnFromAccepted returns a configuration of the node with learn_from_accepted enabled and @acceptors as the other acceptors of the cluster.
+func learnFromAccepted(acceptors ...string) func(c *config.Conf) {
+	return func(c *config.Conf) {
+		c.LEARN_FROM_ACCEPTED = true
+		for i, acceptor := range acceptors {
+			c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{ID: i + 2, URL: acceptor, ROLES: []string{"acceptor"}})
+		}
+	}
+}
+
+func TestAcceptedMessagesChooseValue(t *testing.T) {
+	p := proposal.Proposal{Pid: 2, Seq: 1, V: "v"}
+	peer2, peer3 := acceptorPeer(1, p), acceptorPeer(1, p)
+	defer peer2.Close()
+	defer peer3.Close()
+	defer startNode(t, learnFromAccepted(peer2.URL, peer3.URL)).stop()
+
+	// a learn request is only answered once the value has been chosen
+	learnt := make(chan messages.GenericMessage)
+	go func() {
+		learnt <- ReceiveLearn(messages.GenericMessage{TurnID: 1, Body: messages.Body{Proposal: proposal.Proposal{V: "v"}}})
+	}()
+
+	if response := ReceiveAccepted(messages.AcceptedMessage{TurnID: 1, Acceptor: peer2.URL, Proposal: p}); response.Body.Message != "counted" {
+		t.Fatalf("first accepted message: %+v, want it counted", response.Body)
+	}
+	if response := ReceiveAccepted(messages.AcceptedMessage{TurnID: 1, Acceptor: peer3.URL, Proposal: p}); response.Body.Message != "chosen" {
+		t.Fatalf("accepted message completing a quorum: %+v, want 'v' chosen", response.Body)
+	}
+	if v := queries.GetLearntValue(1); v != "v" {
+		t.Fatalf("learnt value of turn id 1: '%s', want 'v'", v)
+	}
+	if response := <-learnt; response.Body.Learnt != "v" {
+		t.Fatalf("learn request waiting for the accepted messages: %+v, want 'v' learnt", response.Body)
+	}
+}
+
+func TestForgedAcceptedMessages(t *testing.T) {
+	p := proposal.Proposal{Pid: 2, Seq: 1, V: "v"}
+	peer2 := acceptorPeer(1, p)
+	defer peer2.Close()
+	defer startNode(t, learnFromAccepted(peer2.URL, deadPeer())).stop()
+
+	forged := []messages.AcceptedMessage{
+		{TurnID: 1, Acceptor: "http://not-an-acceptor", Proposal: p},
+		{TurnID: 1, Acceptor: peer2.URL, Proposal: proposal.Proposal{Pid: 2, Seq: 1, V: "forged"}},
+		{TurnID: 2, Acceptor: peer2.URL, Proposal: p},
+		{TurnID: 1, Acceptor: config.CONF.SELF_URL, Proposal: p}, // this node has accepted nothing
+	}
+	for _, acceptedMessage := range forged {
+		if response := ReceiveAccepted(acceptedMessage); response.Body.Message != "not confirmed" {
+			t.Fatalf("accepted message %+v: %+v, want it dropped", acceptedMessage, response.Body)
+		}
+	}
+
+	// the same acceptor is counted once, however many times its message is sent
+	for i := 0; i < 3; i++ {
+		if response := ReceiveAccepted(messages.AcceptedMessage{TurnID: 1, Acceptor: peer2.URL, Proposal: p}); response.Body.Message != "counted" {
+			t.Fatalf("accepted message of node 2 sent %d time(s): %+v, want it counted but nothing chosen", i+1, response.Body)
+		}
+	}
+	if v := queries.GetLearntValue(1); v != "" {
+		t.Fatalf("'%s' learnt for turn id 1 out of the messages of a single acceptor", v)
+	}
+}
+
+func TestTalliesAreBounded(t *testing.T) {
+	defer startNode(t, learnFromAccepted(deadPeer(), deadPeer())).stop()
+	p := proposal.Proposal{Pid: 2, Seq: 1, V: "v"}
+
+	// a single acceptor is never a quorum: every turn id stays tallied
+	for turnID := 1; turnID <= maxTallies+10; turnID++ {
+		if _, err := countAccepted(turnID, p, []string{config.CONF.SELF_URL}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tallies.Lock()
+	tallied := len(tallies.acceptors)
+	tallies.Unlock()
+	if tallied > maxTallies {
+		t.Fatalf("%d turn ids tallied, want at most %d", tallied, maxTallies)
+	}
+
+	// a tally older than tallyLifetime is evicted as soon as another turn id is tallied
+	tallies.Lock()
+	tallies.since[maxTallies+10] = time.Now().Add(-2 * tallyLifetime)
+	tallies.Unlock()
+	if _, err := countAccepted(maxTallies+11, p, []string{config.CONF.SELF_URL}); err != nil {
+		t.Fatal(err)
+	}
+	tallies.Lock()
+	_, kept := tallies.acceptors[maxTallies+10]
+	tallies.Unlock()
+	if kept {
+		t.Fatalf("the tally of turn id %d outlived tallyLifetime", maxTallies+10)
+	}
+}
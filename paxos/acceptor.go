@@ -32,10 +32,13 @@ information even if it fails and then restarts.
 package paxos
 
 import (
+	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"go-paxos/paxos/queries"
 	"log"
+	"net/http"
+	"time"
 )
 
 // ReceivePrepare implements the acceptor's behaviour when receiving a prepare request.
@@ -74,30 +77,52 @@ func ReceivePrepare(prepareRequest messages.GenericMessage) messages.GenericMess
 		return earlyResult
 	}
 
+	// the quorums of the turn id are the ones of the membership in effect for it, a proposer lagging behind the changes counts the wrong ones
+	if isStaleEpoch(turnID, prepareRequest.Body.Epoch) {
+		log.Printf("[ACCEPTOR] -> Refusing prepare request of node %d, it has been sent with an outdated membership (epoch %d) for turn id %d.", pid, prepareRequest.Body.Epoch, turnID)
+		return staleEpochResponse(turnID)
+	}
+
+	// while the lease of a leader lasts, only the leader can get promises (see 'election.go')
+	if leader, refused := refusesProposer(pid); refused {
+		log.Printf("[ACCEPTOR] -> Node %d is the leader, refusing prepare request of node %d; sending back a retry.", leader.Ballot.Pid, pid)
+		return messages.GenericMessage{
+			TurnID: turnID,
+			Type:   "accept_response",
+			Body: messages.Body{
+				Message:  "retry",
+				Proposal: leader.Ballot,
+				Learnt:   "",
+			},
+		}
+	}
+
 	// we DO NOT currently have a learnt value for turn_id
-	// @ok is a boolean variable, true iff @oldP is valid (i.e. @oldP.pid && @oldP.seq != nil)
-	oldP, ok := queries.GetProposal(turnID)
+	// comparing and storing happen atomically, concurrent requests for the same turn id cannot interleave.
+	// @oldP is the proposal stored before this request, it might be null
 	newP := proposal.Proposal{Pid: pid, Seq: seq, V: proposedV}
+	oldState, _, promised, err := queries.PromiseIfHigher(turnID, newP)
+
+	// a promise carries the highest accepted proposal (i.e. the value the proposer has to adopt),
+	// a retry carries the promised number so that the proposer knows which number it has to exceed.
+	oldP := oldState.Promised
 
 	// computing @response
 	// @response is a status variable, it holds the result of the message we will be sending back.
 	// Default is "retry" since an acceptor can always "safely ignore" a proposal request.```
 	response := "retry"
-	if !ok || newP.IsGreaterThan(&oldP) {
-
-		err := queries.SetProposal(turnID, newP, false)
-		if err != nil {
-			// could not store @newP
-			log.Print("[ACCEPTOR] -> Refusing prepare request, could not store the new proposal. Here's the error: ", err.Error())
-		} else {
-			// no errors while storing @newP, return a promise
-			response = "promise"
-			log.Printf("[ACCEPTOR] -> Seq: %d pid: %d is the highest proposal for turn id %d; sending back a promise.", seq, pid, turnID)
-		}
+	if err != nil {
+		// could not compare or store @newP
+		log.Print("[ACCEPTOR] -> Refusing prepare request, could not store the new proposal. Here's the error: ", err.Error())
+	} else if promised {
+		// no errors while storing @newP, return a promise
+		response = "promise"
+		oldP = oldState.Accepted
+		log.Printf("[ACCEPTOR] -> Seq: %d pid: %d is the highest proposal for turn id %d; sending back a promise.", seq, pid, turnID)
 	} else {
 		// @oldP is higher than @newP
 		response = "retry"
-		log.Printf("[ACCEPTOR] -> Seq: %d, pid: %d is not strictly higher than the current highest proposal (seq: %d, pid: %d) for turn id %d; sending back a retry.", seq, pid, oldP.Seq, oldP.Pid, turnID)
+		log.Printf("[ACCEPTOR] -> Seq: %d, pid: %d is not strictly higher than the current highest proposal (seq: %d, pid: %d) for turn id %d; sending back a retry.", seq, pid, oldState.Promised.Seq, oldState.Promised.Pid, turnID)
 	}
 	// @response is now set
 
@@ -116,6 +141,20 @@ func ReceivePrepare(prepareRequest messages.GenericMessage) messages.GenericMess
 
 }
 
+// staleEpochResponse is the response to a prepare or accept request for @turnID sent with an outdated membership, see isStaleEpoch.
+// It's neither a promise (an accept) nor a retry (a decline): the proposer has to learn the turn ids it's missing, a higher number would not help.
+func staleEpochResponse(turnID int) messages.GenericMessage {
+	return messages.GenericMessage{
+		TurnID: turnID,
+		Type:   "accept_response",
+		Body: messages.Body{
+			Message:  "stale membership",
+			Proposal: proposal.Proposal{},
+			Learnt:   "",
+		},
+	}
+}
+
 // ReceiveAccept implements the acceptor's behaviour when receiving an accept request.
 // This function compares the stored proposal (@oldP) against the
 // proposal received as input (@newP) and returns an "accept" when
@@ -152,35 +191,59 @@ func ReceiveAccept(acceptRequest messages.GenericMessage) messages.GenericMessag
 		return earlyResult
 	}
 
+	// the quorums of the turn id are the ones of the membership in effect for it, a proposer lagging behind the changes counts the wrong ones
+	if isStaleEpoch(turnID, acceptRequest.Body.Epoch) {
+		log.Printf("[ACCEPTOR] -> Refusing accept request of node %d, it has been sent with an outdated membership (epoch %d) for turn id %d.", pid, acceptRequest.Body.Epoch, turnID)
+		return staleEpochResponse(turnID)
+	}
+
+	// while the lease of a leader lasts, only the leader can get its proposals accepted (see 'election.go')
+	if leader, refused := refusesProposer(pid); refused {
+		log.Printf("[ACCEPTOR] -> Node %d is the leader, refusing accept request of node %d; sending back a decline.", leader.Ballot.Pid, pid)
+		return messages.GenericMessage{
+			TurnID: turnID,
+			Type:   "accept_response",
+			Body: messages.Body{
+				Message:  "decline",
+				Proposal: leader.Ballot,
+				Learnt:   "",
+			},
+		}
+	}
+
 	// we DO NOT currently have a learnt value for turn_id
-	// @ok is a boolean variable, true iff @oldP is valid (i.e. @oldP.pid, @oldP.seq != NULL)
-	oldP, ok := queries.GetProposal(turnID)
+	// newP is stored iff (oldP is NOT valid) OR (oldP is valid but newP>=oldP), comparing and storing happen atomically.
+	// oldP is probably newP saved during the prepare request.
+
+	// CLARIFICATION --> this has to be >= (not only > as in the prepare request)
+	// so that when proposal numbered n is promised after a prepare_request,
+	// the following accept_request with same number n wont be declined
+
+	// @oldP is the proposal stored before this request, it might be null
 	newP := proposal.Proposal{Pid: pid, Seq: seq, V: v}
+	oldState, _, accepted, err := queries.AcceptIfNotLower(turnID, newP)
+
+	// an accept carries the proposal accepted before this request, a decline carries the promised number to exceed.
+	oldP := oldState.Promised
 
 	// response is a status var that holds the response message we're sending back
 	response := "decline"
-	if !ok || newP.IsGEThan(&oldP) {
-		// if (oldP is NOT valid) OR (oldP is valid but newP>=oldP)
-		// oldP is probably newP saved during the prepare request.
-
-		// CLARIFICATION --> this has to be >= (not only > as in the prepare request)
-		// so that when proposal numbered n is promised after a prepare_request,
-		// the following accept_request with same number n wont be declined
-
-		// save newP
-		err := queries.SetProposal(turnID, newP, true)
-		if err != nil {
-			// could not store @newP
-			log.Print("[ACCEPTOR] -> Declining accept request, could not store the new proposal. Here's the error: ", err.Error())
-		} else {
-			// no errors storing @newP, return a promise
-			response = "accept"
-			log.Printf("[ACCEPTOR] -> Seq: %d pid: %d is the highest proposal for turn id %d; sending back an accept.", seq, pid, turnID)
+	if err != nil {
+		// could not compare or store @newP
+		log.Print("[ACCEPTOR] -> Declining accept request, could not store the new proposal. Here's the error: ", err.Error())
+	} else if accepted {
+		// no errors storing @newP, return an accept
+		response = "accept"
+		oldP = oldState.Accepted
+		log.Printf("[ACCEPTOR] -> Seq: %d pid: %d is the highest proposal for turn id %d; sending back an accept.", seq, pid, turnID)
+
+		if config.CONF.LEARN_FROM_ACCEPTED {
+			go broadcastAccepted(turnID, newP)
 		}
 	} else {
 		// @oldP is valid and higher than @newP
 		response = "decline"
-		log.Printf("[ACCEPTOR] -> Seq: %d, pid: %d is not higher than (or equal to) the current highest proposal (seq: %d, pid: %d) for turn id %d; sending back a decline.", seq, pid, oldP.Seq, oldP.Pid, turnID)
+		log.Printf("[ACCEPTOR] -> Seq: %d, pid: %d is not higher than (or equal to) the current highest proposal (seq: %d, pid: %d) for turn id %d; sending back a decline.", seq, pid, oldState.Promised.Seq, oldState.Promised.Pid, turnID)
 	}
 
 	// composing message
@@ -196,3 +259,106 @@ func ReceiveAccept(acceptRequest messages.GenericMessage) messages.GenericMessag
 
 	return result
 }
+
+// ReceiveRangePrepare implements the acceptor's behaviour when receiving a range prepare request from a would-be leader.
+// It works as ReceivePrepare does, but for every turn id higher than or equal to the requested one at once (see queries.PromiseRange).
+// A "promise" carries the proposals accepted for the covered turn ids which have not been learnt yet, together with the values learnt for them,
+// so that the leader can finish what previous proposers started. A "retry" carries the number the leader has to exceed.
+func ReceiveRangePrepare(rangePrepareRequest messages.RangePrepareRequest) messages.RangePrepareResponse {
+	fromTurnID := rangePrepareRequest.FromTurnID
+	pid := rangePrepareRequest.Proposal.Pid
+	seq := rangePrepareRequest.Proposal.Seq
+
+	log.Printf("[ACCEPTOR] -> Receiving range prepare request for turn ids >= %d, pid: %d, seq: %d.", fromTurnID, pid, seq)
+
+	result := messages.RangePrepareResponse{
+		Message:    "retry",
+		FromTurnID: fromTurnID,
+		Accepted:   []messages.ProposalWithTid{},
+		Learnt:     make(map[int]string),
+	}
+
+	if isStaleEpoch(fromTurnID, rangePrepareRequest.Epoch) {
+		log.Printf("[ACCEPTOR] -> Refusing range prepare request of node %d, it has been sent with an outdated membership (epoch %d) for turn id %d.", pid, rangePrepareRequest.Epoch, fromTurnID)
+		result.Message = "stale membership"
+		return result
+	}
+
+	// while the lease of a leader lasts, no other node can become the leader (see 'election.go')
+	if leader, refused := refusesProposer(pid); refused {
+		log.Printf("[ACCEPTOR] -> Node %d is the leader, refusing range prepare request of node %d; sending back a retry.", leader.Ballot.Pid, pid)
+		result.Promised = leader.Ballot
+		return result
+	}
+
+	rp, promised, err := queries.PromiseRange(fromTurnID, proposal.Proposal{Pid: pid, Seq: seq})
+	if err != nil {
+		log.Print("[ACCEPTOR] -> Refusing range prepare request, could not store the range promise. Here's the error: ", err.Error())
+		return result
+	} else if !promised {
+		log.Printf("[ACCEPTOR] -> Seq: %d, pid: %d is not strictly higher than the promise (seq: %d, pid: %d) for turn id %d; sending back a retry.", seq, pid, rp.Promised.Seq, rp.Promised.Pid, rp.FromTurnID)
+		result.Promised = rp.Promised
+		return result
+	}
+
+	// collecting after promising: anything accepted before the promise is listed, nothing lower can be accepted after it
+	for turnID := range *queries.GetLearntValuesTurnID() {
+		if turnID >= fromTurnID {
+			result.Learnt[turnID] = queries.GetLearntValue(turnID)
+		}
+	}
+	for _, p := range queries.GetAllProposals() {
+		_, isLearnt := result.Learnt[p.TurnID]
+		if p.TurnID >= fromTurnID && !isLearnt && (p.Proposal.Pid != 0 || p.Proposal.Seq != 0) {
+			result.Accepted = append(result.Accepted, p)
+		}
+	}
+
+	log.Printf("[ACCEPTOR] -> Seq: %d pid: %d is the highest proposal for turn ids >= %d; sending back a promise with %d accepted proposal(s).", seq, pid, fromTurnID, len(result.Accepted))
+	result.Message = "promise"
+	return result
+}
+
+// ConfirmAccepted implements the acceptor's behaviour when a learner asks whether it has accepted the proposal of an accepted message, see learn_from_accepted.
+// The answer is "accepted" only when the proposal, value included, is the one accepted by this node for the turn id.
+func ConfirmAccepted(acceptedMessage messages.AcceptedMessage) messages.GenericMessage {
+	turnID := acceptedMessage.TurnID
+	state, _ := queries.GetProposal(turnID)
+
+	confirmResponse := messages.GenericMessage{
+		TurnID: turnID,
+		Type:   "confirm_accepted_response",
+		Body: messages.Body{
+			Message:  "not accepted",
+			Proposal: state.Accepted,
+			Learnt:   "",
+		},
+	}
+	if hasAccepted(turnID, acceptedMessage.Proposal) {
+		confirmResponse.Body.Message = "accepted"
+	}
+	return confirmResponse
+}
+
+// hasAccepted tells whether @p, value included, is the proposal this node has accepted for @turnID.
+func hasAccepted(turnID int, p proposal.Proposal) bool {
+	state, _ := queries.GetProposal(turnID)
+	return state.HasAccepted() && state.Accepted.IsEqualTo(&p) && state.Accepted.V == p.V
+}
+
+// broadcastAccepted lets every learner know that this acceptor has accepted @p for @turnID, see ReceiveAccepted.
+// Responses are ignored: a learner missing the message will learn the value from the other nodes.
+func broadcastAccepted(turnID int, p proposal.Proposal) {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	acceptedMessage := messages.AcceptedMessage{
+		TurnID:   turnID,
+		Acceptor: config.CONF.SELF_URL,
+		Proposal: p,
+	}
+
+	learners := config.Members().LEARNERS
+	ch := make(chan []byte, len(learners))
+	for _, node := range learners {
+		go sendPartialRequest(session, node+"/learner/receive_accepted", ch, acceptedMessage)
+	}
+}
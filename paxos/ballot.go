@@ -0,0 +1,170 @@
+/*
+
+# Ballots:
+A proposal number is the pair (seq, pid): the numbers of two nodes differ as long as their pids do,
+the numbers of a single node differ as long as it never uses the same sequence number twice, restarts included.
+Each node keeps in its storage the highest sequence number it has used for a prepare (or range prepare) request:
+
+	(a) A new round uses a sequence number higher than any used before, see issueSeq;
+	---AND---
+	(b) A prepare request numbered with a sequence number used already (e.g. a manual /proposer/send_prepare) is refused.
+
+Sequence numbers are counted for the node, not for each turn id: a number used for turn id 1 cannot be used for turn id 2 anymore,
+so the sequence numbers of the prepare requests of a node are increasing in the order they have been sent.
+The refusal of (b) gives the next sequence number the node can use, see freshSeq.
+Accept requests reuse the number of their prepare request, they are not checked.
+The pid is checked at startup: a random pid (pid: 0) is stored and reused by the next runs of the node,
+and the node refuses to start when one of its peers answers /info with the same pid.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Meta keys under which the ballot state of the node is stored, see queries.SetMeta.
+const (
+	seqMetaKey = "highest_seq"
+	pidMetaKey = "pid"
+)
+
+// ErrSeqUsed is returned when a prepare request is numbered with a sequence number this node has used already.
+var ErrSeqUsed = errors.New("sequence number already used by this node")
+
+// ballots holds the highest sequence number used by this node, as stored in the storage.
+var ballots struct {
+	sync.Mutex
+	highestSeq int
+}
+
+// InitBallots loads the highest sequence number used by this node.
+// A random pid is replaced by the one picked by a previous run of the node, the first run stores its own.
+func InitBallots() error {
+	ballots.Lock()
+	defer ballots.Unlock()
+
+	stored, err := queries.GetMeta(seqMetaKey)
+	if err != nil {
+		return err
+	}
+	if stored != "" {
+		ballots.highestSeq, err = strconv.Atoi(stored)
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s' in the storage: %v", seqMetaKey, stored, err)
+		}
+	}
+
+	if !config.CONF.RandomPID() {
+		return nil
+	}
+	stored, err = queries.GetMeta(pidMetaKey)
+	if err != nil {
+		return err
+	}
+	if stored == "" {
+		return queries.SetMeta(pidMetaKey, strconv.Itoa(config.CONF.PID))
+	}
+	pid, err := strconv.Atoi(stored)
+	if err != nil {
+		return fmt.Errorf("invalid %s '%s' in the storage: %v", pidMetaKey, stored, err)
+	}
+	config.CONF.UsePID(pid)
+	return nil
+}
+
+// HighestSeq returns the highest sequence number used by this node.
+func HighestSeq() int {
+	ballots.Lock()
+	defer ballots.Unlock()
+	return ballots.highestSeq
+}
+
+// freshSeq returns @seq, or the first sequence number above the ones used by this node when @seq has been used already. Nothing is recorded.
+func freshSeq(seq int) int {
+	ballots.Lock()
+	defer ballots.Unlock()
+
+	if seq <= ballots.highestSeq {
+		return ballots.highestSeq + 1
+	}
+	return seq
+}
+
+// recordSeq stores @seq as the highest sequence number used, the caller must hold the lock.
+func recordSeq(seq int) error {
+	err := queries.SetMeta(seqMetaKey, strconv.Itoa(seq))
+	if err != nil {
+		return err
+	}
+	ballots.highestSeq = seq
+	return nil
+}
+
+// useSeq records @seq as used by a prepare (or range prepare) request, ErrSeqUsed is returned when @seq is not higher than every sequence number used already,
+// whatever the turn id they have been used for.
+func useSeq(seq int) error {
+	ballots.Lock()
+	defer ballots.Unlock()
+
+	if seq <= ballots.highestSeq {
+		return ErrSeqUsed
+	}
+	return recordSeq(seq)
+}
+
+// issueSeq returns a sequence number never used by this node, @seq itself when it's high enough, and records it as used.
+func issueSeq(seq int) (int, error) {
+	ballots.Lock()
+	defer ballots.Unlock()
+
+	if seq <= ballots.highestSeq {
+		seq = ballots.highestSeq + 1
+	}
+	return seq, recordSeq(seq)
+}
+
+// CheckPeerPIDs asks the other nodes for their pid through /info (the 'pid' field of messages.Info), an error is returned when one of them uses the pid of this node.
+// Nodes which cannot be reached, or do not report their pid, are skipped.
+func CheckPeerPIDs() error {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+
+	for _, node := range config.Members().Peers() {
+		res, err := session.Get(node + "/info")
+		if err != nil {
+			log.Printf("[MAIN] -> Could not check the pid of %s, it's not reachable.", node)
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			continue
+		}
+
+		var info messages.Info
+		if json.Unmarshal(body, &info) != nil || info.PID == 0 {
+			log.Printf("[MAIN] -> Could not check the pid of %s, its /info does not report it.", node)
+			continue
+		}
+		pid := info.PID
+		if pid == config.CONF.PID {
+			return fmt.Errorf("pid %d is used by %s as well", pid, node)
+		}
+		if entry, found := config.Members().NodeByURL(node); found && entry.ID != 0 && entry.ID != pid {
+			log.Printf("[MAIN] -> !!WARNING!! %s answers with pid %d, its proposals will not be mapped back to it.", config.Members().NodeName(node), pid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// infoPeer answers /info with @info, as a node of the cluster.
+func infoPeer(info messages.Info) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ToJson(info)))
+	}))
+}
+
+func TestSeqIsNeverReused(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	for _, want := range []int{1, 2, 3} {
+		seq, err := issueSeq(1)
+		if err != nil || seq != want {
+			t.Fatalf("issued seq: %d (err = %v), want %d", seq, err, want)
+		}
+	}
+	if seq, _ := issueSeq(10); seq != 10 {
+		t.Fatalf("issued seq: %d, want the higher seq asked for (10)", seq)
+	}
+
+	// sequence numbers are counted for the node, not for each turn id
+	for _, seq := range []int{5, 10} {
+		if err := useSeq(seq); err != ErrSeqUsed {
+			t.Fatalf("use of seq %d after seq 10: err = %v, want ErrSeqUsed", seq, err)
+		}
+	}
+	if seq := freshSeq(5); seq != 11 {
+		t.Fatalf("fresh seq after seq 10: %d, want 11", seq)
+	}
+	if err := useSeq(11); err != nil {
+		t.Fatal(err)
+	}
+
+	messageToUser := SendPrepare(2, 11, "a", false)
+	if !strings.Contains(messageToUser, "seq=12") {
+		t.Fatalf("prepare request with a used seq: '%s', want the next usable seq (12) given back", messageToUser)
+	}
+}
+
+func TestBallotsArePersisted(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) {
+		// a plain url: the pid is picked at random
+		c.NODE_ENTRIES = []config.Node{{URL: c.SELF_URL}}
+	}).stop()
+
+	pid := config.CONF.PID
+	if stored, _ := queries.GetMeta(pidMetaKey); stored != strconv.Itoa(pid) {
+		t.Fatalf("stored pid: '%s', want the random pid %d", stored, pid)
+	}
+	if _, err := issueSeq(7); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart of the node: a new random pid, nothing in memory
+	ballots.highestSeq = 0
+	config.CONF.UsePID(pid%10000 + 1)
+	err := InitBallots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CONF.PID != pid {
+		t.Fatalf("pid after a restart: %d, want the stored one (%d)", config.CONF.PID, pid)
+	}
+	if seq := HighestSeq(); seq != 7 {
+		t.Fatalf("highest seq after a restart: %d, want 7", seq)
+	}
+}
+
+func TestCheckPeerPIDs(t *testing.T) {
+	other := infoPeer(messages.Info{Message: "golang@automatic@2", PID: 2})
+	defer other.Close()
+	legacy := infoPeer(messages.Info{Message: "python@automatic@1"}) // no pid field: not checked
+	defer legacy.Close()
+	clashing := infoPeer(messages.Info{Message: "golang@automatic@1", PID: 1})
+	defer clashing.Close()
+
+	node := startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{URL: other.URL}, config.Node{URL: legacy.URL}, config.Node{URL: deadPeer()})
+	})
+	err := CheckPeerPIDs()
+	node.stop()
+	if err != nil {
+		t.Fatalf("pids of the peers checked: %v, want no clash", err)
+	}
+
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{URL: other.URL}, config.Node{URL: clashing.URL})
+	}).stop()
+	if err := CheckPeerPIDs(); err == nil {
+		t.Fatal("a peer using the pid of this node has not been reported")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+
+# Batching:
+A turn id carries a single value, a client submitting at a high rate would need a whole Paxos instance per value.
+The batcher gathers the values submitted to this node and proposes them together, as the value of a single turn id:
+
+	(a) The first value of a batch waits at most BATCH_MAX_DELAY milliseconds for other values;
+	---OR---
+	(b) The batch is proposed as soon as it holds BATCH_MAX_SIZE values.
+
+A batch is encoded as the batch prefix followed by the JSON list of its values, submitted values cannot start with the prefix.
+Each value of the log has a position (turn_id, index): a plain value is the only entry of its turn id (index 0),
+the values of a batch are its entries in order. The learnt-value routes expose both, see GetLearntEntry.
+The submit id a value or a batch has been proposed with (see tagSubmit) is not part of the entries.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchPrefix starts the value of every turn id holding a batch.
+const batchPrefix = "batch:"
+
+// batchEntry is a value waiting in the batcher, the outcome of its batch is sent to done.
+type batchEntry struct {
+	v        string
+	deadline time.Time
+	done     chan batchOutcome
+}
+
+// batchOutcome is the outcome of the submit of a batch, as seen by one of its entries.
+type batchOutcome struct {
+	position messages.SubmitResponse
+	err      error
+}
+
+// batcher holds the values gathered for the next batch, the timer proposes them after BATCH_MAX_DELAY milliseconds.
+var batcher struct {
+	sync.Mutex
+	pending []*batchEntry
+	timer   *time.Timer
+}
+
+// isBatch tells whether @v is the encoding of a batch.
+func isBatch(v string) bool {
+	return strings.HasPrefix(v, batchPrefix)
+}
+
+// encodeBatch encodes the values @vs into the value of a single turn id.
+func encodeBatch(vs []string) string {
+	encoded, _ := json.Marshal(vs)
+	return batchPrefix + string(encoded)
+}
+
+// decodeEntries returns the entries of the value @v learnt for a turn id: the values of the batch, or @v itself when it's not a batch.
+func decodeEntries(v string) []string {
+	if v == "" {
+		return nil
+	}
+	_, v = untagSubmit(v)
+	if !isBatch(v) {
+		return []string{v}
+	}
+
+	var vs []string
+	err := json.Unmarshal([]byte(strings.TrimPrefix(v, batchPrefix)), &vs)
+	if err != nil {
+		// a learnt value is never rejected, an undecodable batch is exposed as a single entry
+		log.Printf("[BATCHER] -> Could not decode the batch '%s': %v", v, err)
+		return []string{v}
+	}
+	return vs
+}
+
+// submitBatched adds @v to the next batch and waits for the batch to be learnt, or for @deadline to expire.
+func submitBatched(v string, deadline time.Time) (messages.SubmitResponse, error) {
+	entry := &batchEntry{v: v, deadline: deadline, done: make(chan batchOutcome, 1)}
+
+	batcher.Lock()
+	batcher.pending = append(batcher.pending, entry)
+	if len(batcher.pending) >= config.CONF.BATCH_MAX_SIZE {
+		batch := takeBatch()
+		go submitBatch(batch)
+	} else if len(batcher.pending) == 1 {
+		batcher.timer = time.AfterFunc(time.Duration(config.CONF.BATCH_MAX_DELAY)*time.Millisecond, flushBatch)
+	}
+	batcher.Unlock()
+
+	select {
+	case outcome := <-entry.done:
+		return outcome.position, outcome.err
+	case <-time.After(time.Until(deadline)):
+		return messages.SubmitResponse{}, ErrSubmitDeadline
+	}
+}
+
+// takeBatch empties the batcher and returns the values it held, the caller must hold the lock.
+func takeBatch() []*batchEntry {
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+		batcher.timer = nil
+	}
+	batch := batcher.pending
+	batcher.pending = nil
+	return batch
+}
+
+// flushBatch submits the values gathered so far, it's called when the first of them has waited BATCH_MAX_DELAY milliseconds.
+func flushBatch() {
+	batcher.Lock()
+	batch := takeBatch()
+	batcher.Unlock()
+
+	if len(batch) != 0 {
+		submitBatch(batch)
+	}
+}
+
+// submitBatch submits the values of @batch as the value of a single turn id and reports the position of each of them.
+// The batch is given the latest deadline of its values, each value stops waiting at its own deadline anyway.
+func submitBatch(batch []*batchEntry) {
+	var vs []string
+	deadline := time.Now()
+	for _, entry := range batch {
+		vs = append(vs, entry.v)
+		if entry.deadline.After(deadline) {
+			deadline = entry.deadline
+		}
+	}
+
+	log.Printf("[BATCHER] -> Submitting a batch of %d value(s).", len(batch))
+	turnID, err := submitTurn(encodeBatch(vs), deadline)
+
+	for index, entry := range batch {
+		entry.done <- batchOutcome{
+			position: messages.SubmitResponse{TurnID: turnID, Index: index, V: entry.v},
+			err:      err,
+		}
+	}
+}
+
+// GetLearntEntry returns a message with the 'learnt' field containing the value at position (@turnID, @index) of the log.
+// If there is no such value the 'learnt' field will contain an empty string.
+func GetLearntEntry(turnID int, index int) messages.GenericMessage {
+	getLearntResponse := GetLearntValue(turnID)
+
+	entries := decodeEntries(getLearntResponse.Body.Learnt)
+	if index >= 0 && index < len(entries) {
+		getLearntResponse.Body.Learnt = entries[index]
+	} else {
+		getLearntResponse.Body.Learnt = ""
+	}
+	return getLearntResponse
+}
+
+// GetAllLearntEntries returns every value of the log, the entries of the batches included, ordered by position.
+func GetAllLearntEntries() []messages.LearntEntry {
+	var entries []messages.LearntEntry
+
+	turnIDs := queries.GetLearntValuesTurnID()
+	for turnID := 1; turnID <= queries.GetLastTurnID(); turnID++ {
+		if !(*turnIDs)[turnID] {
+			continue
+		}
+		for index, v := range decodeEntries(queries.GetLearntValue(turnID)) {
+			entries = append(entries, messages.LearntEntry{TurnID: turnID, Index: index, V: v})
+		}
+	}
+	return entries
+}
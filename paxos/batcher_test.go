@@ -0,0 +1,84 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchProposedWhenFull(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) {
+		c.BATCH_MAX_SIZE = 3
+		c.BATCH_MAX_DELAY = 60000
+	}).stop()
+
+	vs := []string{"a", "b", "c"}
+	positions := make([]messages.SubmitResponse, len(vs))
+	var wg sync.WaitGroup
+	for i := range vs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			positions[i], _ = Submit(vs[i], time.Now().Add(5*time.Second))
+		}(i)
+	}
+	wg.Wait()
+
+	indexes := make(map[int]bool)
+	for i, position := range positions {
+		if position.TurnID != 1 {
+			t.Fatalf("'%s' got turn id %d, want the 3 values batched at turn id 1", vs[i], position.TurnID)
+		}
+		indexes[position.Index] = true
+		if entry := GetLearntEntry(1, position.Index); entry.Body.Learnt != vs[i] {
+			t.Fatalf("entry (1, %d): '%s', want '%s'", position.Index, entry.Body.Learnt, vs[i])
+		}
+	}
+	if len(indexes) != len(vs) {
+		t.Fatalf("positions of the batch: %+v, want an index per value", positions)
+	}
+}
+
+func TestBatchProposedAfterDelay(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) {
+		c.BATCH_MAX_SIZE = 10
+		c.BATCH_MAX_DELAY = 20
+	}).stop()
+
+	first := submitWithin(t, "a", 5)
+	second := submitWithin(t, "b", 5)
+	if first.TurnID != 1 || first.Index != 0 || second.TurnID != 2 || second.Index != 0 {
+		t.Fatalf("positions of two values submitted one after the other: %+v and %+v, want (1, 0) and (2, 0)", first, second)
+	}
+
+	entries := GetAllLearntEntries()
+	if len(entries) != 2 || entries[0].V != "a" || entries[1].V != "b" {
+		t.Fatalf("entries of the log: %+v, want 'a' then 'b'", entries)
+	}
+}
+
+func TestDecodeEntries(t *testing.T) {
+	cases := []struct {
+		v    string
+		want []string
+	}{
+		{v: "plain", want: []string{"plain"}},
+		{v: encodeBatch([]string{"a", "b"}), want: []string{"a", "b"}},
+		{v: tagSubmit("1.1", encodeBatch([]string{"a", "b"})), want: []string{"a", "b"}},
+		{v: batchPrefix + "not json", want: []string{batchPrefix + "not json"}}, // exposed as a single entry
+		{v: "", want: nil},
+	}
+	for _, c := range cases {
+		entries := decodeEntries(c.v)
+		if len(entries) != len(c.want) {
+			t.Fatalf("entries of '%s': %v, want %v", c.v, entries, c.want)
+		}
+		for i := range c.want {
+			if entries[i] != c.want[i] {
+				t.Fatalf("entries of '%s': %v, want %v", c.v, entries, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,366 @@
+/*
+
+# Submit(v):
+Appends a value to the replicated log without the caller having to pick a turn id.
+The client picks the next free turn id and runs prepare/accept rounds on it until a value is chosen:
+
+	(a) If the chosen value is ours, its turn id is returned;
+	---OR---
+	(b) If another value wins the turn id, the client moves on to the next free turn id and starts over.
+
+The value is proposed together with a submit id unique to the submit (see tagSubmit), the chosen value is ours when it carries our id:
+two submits of the same value are never mistaken for one another.
+Rounds are retried with higher sequence numbers until the deadline expires.
+In leader mode the prepare phase is skipped altogether, see 'leader.go'.
+Several values can share a single turn id, see 'batcher.go'.
+The nodes of the cluster are changed through the log as well, see 'membership.go'.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSubmitDeadline is returned by Submit when the deadline expires before the submitted value is chosen.
+var ErrSubmitDeadline = errors.New("the deadline expired before the value was chosen")
+
+// ErrSubmitOutcomeUnknown is returned by SubmitToLeader when the connection to the leader broke after the submit was sent:
+// the leader might have appended the value or not, submitting it again could append it twice.
+var ErrSubmitOutcomeUnknown = errors.New("the connection to the leader broke after the submit was sent, the value might have been appended or not")
+
+// submitPrefix starts every value proposed by a submit, it's followed by the submit id and the submitted value, see tagSubmit.
+const submitPrefix = "submit:"
+
+// submitIDs holds the counter of the submit ids handed out by this node.
+var submitIDs struct {
+	sync.Mutex
+	counter int64
+}
+
+// nextSubmitID returns a submit id never handed out before by any node: the pid of this node followed by a counter.
+// The counter starts from the time of the first submit, so that the ids are not handed out again after a restart.
+func nextSubmitID() string {
+	submitIDs.Lock()
+	defer submitIDs.Unlock()
+
+	if submitIDs.counter == 0 {
+		submitIDs.counter = time.Now().UnixNano()
+	}
+	submitIDs.counter += 1
+	return fmt.Sprintf("%d.%d", config.CONF.PID, submitIDs.counter)
+}
+
+// tagSubmit encodes @v proposed by the submit @id into the value of a turn id: "submit:<id>:<v>".
+func tagSubmit(id string, v string) string {
+	return submitPrefix + id + ":" + v
+}
+
+// untagSubmit splits the value @v of a turn id into the submit id and the submitted value.
+// The id is empty when @v has not been proposed by a submit (e.g. a value proposed through /proposer/send_prepare).
+func untagSubmit(v string) (id string, submitted string) {
+	if !strings.HasPrefix(v, submitPrefix) {
+		return "", v
+	}
+	parts := strings.SplitN(strings.TrimPrefix(v, submitPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", v
+	}
+	return parts[0], parts[1]
+}
+
+// reserved holds the highest turn id handed out to a submit, so that concurrent submits on this node never run on the same turn id.
+var reserved struct {
+	sync.Mutex
+	turnID int
+}
+
+// nextFreeTurnID reserves and returns the first turn id higher than any turn id we know of: learnt, proposed, or handed out to another submit.
+func nextFreeTurnID() int {
+	reserved.Lock()
+	defer reserved.Unlock()
+
+	turnID := queries.GetLastTurnID()
+	for proposalTurnID := range *queries.GetProposalsTurnID() {
+		if proposalTurnID > turnID {
+			turnID = proposalTurnID
+		}
+	}
+	if reserved.turnID > turnID {
+		turnID = reserved.turnID
+	}
+
+	reserved.turnID = turnID + 1
+	return reserved.turnID
+}
+
+// sessionUntil returns the session the requests of a round are sent with: a request times out after TIMEOUT seconds,
+// or earlier when @deadline comes first, so that no round outlives the deadline.
+func sessionUntil(deadline time.Time) *http.Client {
+	timeout := time.Second * config.CONF.TIMEOUT
+	if remaining := time.Until(deadline); remaining < timeout {
+		timeout = remaining
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// backoff waits a random amount of time, up to a second but never past @deadline, before a round is retried.
+// Proposers competing for the same turn id keep preempting each other with higher sequence numbers when they retry at once,
+// waiting a random amount lets one of them finish its round first.
+func backoff(deadline time.Time) {
+	wait := time.Duration(rand.Float64() * float64(time.Second))
+	if remaining := time.Until(deadline); wait > remaining {
+		wait = remaining
+	}
+	time.Sleep(wait)
+}
+
+// submitRound runs one prepare/accept round for @turnID, proposing @v unless the acceptors report a value accepted earlier.
+// It returns the value chosen for @turnID, or an empty string together with the sequence number to retry with if no quorum was reached.
+func submitRound(session *http.Client, turnID int, seq int, v string) (chosenV string, retrySeq int, err error) {
+	if learntV := queries.GetLearntValue(turnID); learntV != "" {
+		return learntV, 0, nil
+	}
+
+	// prepare phase, with the acceptors of the membership in effect for the turn id
+	q := quorumsForTurn(turnID)
+	prepare, err := tallyPromises(broadcastPrepare(session, q.Acceptors, turnID, seq, v))
+	if err != nil {
+		return "", 0, err
+	}
+	if prepare.learnt != nil {
+		if learntV := learnAndFlood(*prepare.learnt); learntV != "" {
+			return learntV, 0, nil
+		}
+		return "", seq + 1, nil
+	}
+	if !q.IsPhase1Quorum(prepare.promisers) {
+		log.Printf("[CLIENT] -> Quorum has NOT been reached (%d/%d) for prepare request with turn id %d, seq %d.", prepare.agreements, len(q.Acceptors), turnID, seq)
+		if prepare.highestRetry.Seq > seq {
+			seq = prepare.highestRetry.Seq
+		}
+		return "", seq + 1, nil
+	}
+
+	// the value accepted with the highest number must be proposed instead of ours
+	proposedV := v
+	if prepare.highestPromise.V != "" {
+		proposedV = prepare.highestPromise.V
+	}
+
+	trackPhase(turnID, "accept")
+	return acceptRound(session, turnID, seq, proposedV)
+}
+
+// acceptRound runs the accept phase of a round for @turnID, proposing @v with sequence number @seq.
+// It returns the value chosen for @turnID, or an empty string together with the sequence number to retry with if no quorum was reached.
+func acceptRound(session *http.Client, turnID int, seq int, v string) (chosenV string, retrySeq int, err error) {
+	q := quorumsForTurn(turnID)
+	accept, err := tallyApprovals(broadcastAccept(session, q.Acceptors, turnID, seq, v))
+	if err != nil {
+		return "", 0, err
+	}
+	if accept.learnt != nil {
+		if learntV := learnAndFlood(*accept.learnt); learntV != "" {
+			return learntV, 0, nil
+		}
+		return "", seq + 1, nil
+	}
+	if !q.IsPhase2Quorum(accept.approvers) {
+		log.Printf("[CLIENT] -> Quorum has NOT been reached (%d/%d) for accept request with turn id %d, seq %d.", accept.approvals, len(q.Acceptors), turnID, seq)
+		if accept.highestDecline.Seq > seq {
+			seq = accept.highestDecline.Seq
+		}
+		return "", seq + 1, nil
+	}
+
+	// learn phase: the value is chosen, learning it here first; the other learners are reached by the caller
+	if config.CONF.LEARN_FROM_ACCEPTED {
+		// the accept responses are accepted messages as well, the value is learnt once they are counted like the ones sent to the learners
+		_, err = countAccepted(turnID, proposal.Proposal{Pid: config.CONF.PID, Seq: seq, V: v}, accept.approvers)
+	} else {
+		err = learnValue(turnID, v, "client")
+	}
+	if _, isConflict := err.(*queries.LearntConflictError); isConflict {
+		return queries.GetLearntValue(turnID), 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	return v, 0, nil
+}
+
+// awaitLearnQuorum sends learn requests for (@turnID, @v) until a learner quorum has learnt @v or @deadline expires.
+// Any learner quorum, the one answering a quorum read included, holds a learner which has learnt @v then.
+func awaitLearnQuorum(turnID int, v string, deadline time.Time) error {
+	for time.Now().Before(deadline) {
+		session := sessionUntil(deadline)
+
+		q := quorumsForTurn(turnID)
+		learners := learnQuorum(session, turnID, v)
+		if q.IsLearnerQuorum(learners) {
+			return nil
+		}
+		log.Printf("[CLIENT] -> Only %d/%d learners have learnt '%s' for turn id %d, sending the learn requests again.", len(learners), len(q.Learners), v, turnID)
+		backoff(deadline)
+	}
+	return ErrSubmitDeadline
+}
+
+// Submit appends @v to the replicated log and returns its position: the turn id it has been learnt for and its index in the value of that turn.
+// Values are gathered into batches when batch_max_size is higher than 1 (see 'batcher.go'), otherwise each value has a turn id of its own (index 0).
+// The call blocks until @v is learnt by a quorum of learners, so that a quorum read cannot miss it (see Read),
+// or until @deadline expires, in which case ErrSubmitDeadline is returned.
+// Note that @v might still be chosen after the deadline, as a value accepted by some acceptors is carried on by later rounds.
+func Submit(v string, deadline time.Time) (messages.SubmitResponse, error) {
+	if v == "" {
+		return messages.SubmitResponse{}, errors.New("cannot submit an empty value")
+	}
+	if isBatch(v) {
+		return messages.SubmitResponse{}, fmt.Errorf("cannot submit a value starting with '%s', it's reserved to batches", batchPrefix)
+	}
+	if isMembershipChange(v) {
+		return messages.SubmitResponse{}, fmt.Errorf("cannot submit a value starting with '%s', it's reserved to membership changes", configPrefix)
+	}
+	if strings.HasPrefix(v, submitPrefix) {
+		return messages.SubmitResponse{}, fmt.Errorf("cannot submit a value starting with '%s', it's reserved to submit ids", submitPrefix)
+	}
+
+	if config.CONF.BATCH_MAX_SIZE > 1 {
+		return submitBatched(v, deadline)
+	}
+	turnID, err := submitTurn(v, deadline)
+	return messages.SubmitResponse{TurnID: turnID, V: v}, err
+}
+
+// submitTurn runs rounds until @v is chosen for a turn id of its own, which is returned, see Submit.
+// @v is proposed under a new submit id, the turn id is ours only when the chosen value carries that id.
+func submitTurn(v string, deadline time.Time) (turnID int, err error) {
+	err = enterPipeline(deadline)
+	if err != nil {
+		log.Printf("[CLIENT] -> No room in the pipeline for '%s' before the deadline.", v)
+		return 0, err
+	}
+
+	// whatever the outcome, the turn id tried last stops being tracked on return
+	turnID = nextFreeTurnID()
+	previousTurnID := turnID
+	defer func() { leavePipeline(previousTurnID) }()
+	seq := 1
+	id := nextSubmitID()
+	tagged := tagSubmit(id, v)
+	log.Printf("[CLIENT] -> Submitting '%s' (submit id %s), trying turn id %d.", v, id, turnID)
+
+	for time.Now().Before(deadline) {
+		session := sessionUntil(deadline)
+
+		if !membershipKnownFor(turnID) {
+			// the quorums of the turn id could be the ones of a membership change this node has not learnt yet, the seeker fetches the missing turn ids
+			log.Printf("[CLIENT] -> The membership in effect for turn id %d is not known yet, waiting for the previous turn ids to be learnt.", turnID)
+			backoff(deadline)
+			continue
+		}
+
+		if config.CONF.LEADER_MODE && !isLeaderFor(turnID) {
+			// losing the race for leadership is not an error, the round below is a full one
+			_ = acquireLeadership(session, turnID)
+		}
+
+		var chosenV string
+		var retrySeq int
+		if isLeaderFor(turnID) {
+			trackTurn(previousTurnID, turnID, seq, v, "accept")
+			chosenV, retrySeq, err = leaderRound(session, turnID, tagged)
+		} else {
+			// a sequence number is never used twice by this node, see 'ballot.go'
+			seq, err = issueSeq(seq)
+			if err != nil {
+				return 0, err
+			}
+			trackTurn(previousTurnID, turnID, seq, v, "prepare")
+			chosenV, retrySeq, err = submitRound(session, turnID, seq, tagged)
+		}
+		previousTurnID = turnID
+		if err != nil {
+			return 0, err
+		}
+
+		chosenID, chosenSubmitted := untagSubmit(chosenV)
+		if chosenV != "" && chosenID == id {
+			trackPhase(turnID, "learn")
+			err = awaitLearnQuorum(turnID, chosenV, deadline)
+			if err != nil {
+				log.Printf("[CLIENT] -> '%s' has been chosen for turn id %d but a quorum of learners could not be reached before the deadline.", v, turnID)
+				return 0, err
+			}
+			log.Printf("[CLIENT] -> '%s' has been learnt for turn id %d.", v, turnID)
+			return turnID, nil
+		} else if chosenV != "" {
+			go SendLearn(turnID, chosenV)
+			log.Printf("[CLIENT] -> Turn id %d went to '%s', moving on to the next free turn id.", turnID, chosenSubmitted)
+			turnID = nextFreeTurnID()
+			seq = 1
+		} else {
+			seq = retrySeq
+			backoff(deadline)
+		}
+	}
+
+	log.Printf("[CLIENT] -> Could not submit '%s' before the deadline, last tried turn id %d.", v, turnID)
+	return 0, ErrSubmitDeadline
+}
+
+// SubmitToLeader forwards the submit of @v to the leader found at @leaderURL and returns the position it has been learnt at, see Submit.
+// If the connection to the leader cannot be established @v is submitted by this node instead; if it breaks once the submit has been sent,
+// ErrSubmitOutcomeUnknown is returned as the leader might have appended @v already.
+func SubmitToLeader(leaderURL string, v string, deadline time.Time) (messages.SubmitResponse, error) {
+	seconds := int(math.Ceil(time.Until(deadline).Seconds()))
+	if seconds <= 0 {
+		return messages.SubmitResponse{}, ErrSubmitDeadline
+	}
+
+	// the leader is given the whole deadline, the session waits a little longer for its response
+	session := &http.Client{Timeout: time.Until(deadline) + time.Second*config.CONF.TIMEOUT}
+	submitURL := fmt.Sprintf("%s/client/submit?forwarded=true&timeout=%d&v=%s", leaderURL, seconds, url.QueryEscape(v))
+
+	log.Printf("[CLIENT] -> Forwarding '%s' to the leader at %s.", v, leaderURL)
+	res, err := session.Get(submitURL)
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		// the leader never received the submit
+		log.Printf("[CLIENT] -> The leader at %s is not reachable, submitting '%s' from this node.", leaderURL, v)
+		return Submit(v, deadline)
+	} else if err != nil {
+		log.Printf("[CLIENT] -> The request forwarding '%s' to the leader at %s failed: %v", v, leaderURL, err)
+		return messages.SubmitResponse{}, fmt.Errorf("%w: %v", ErrSubmitOutcomeUnknown, err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	switch res.StatusCode {
+	case http.StatusOK:
+		submitResponse := messages.SubmitResponse{}
+		err = json.Unmarshal(body, &submitResponse)
+		return submitResponse, err
+	case http.StatusGatewayTimeout:
+		return messages.SubmitResponse{}, ErrSubmitDeadline
+	default:
+		return messages.SubmitResponse{}, fmt.Errorf("the leader at %s refused the submit: %s", leaderURL, strings.TrimSpace(string(body)))
+	}
+}
@@ -0,0 +1,92 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"testing"
+	"time"
+)
+
+func TestSubmitAppendsToNextTurn(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	for i, v := range []string{"a", "b", "c"} {
+		submitResponse := submitWithin(t, v, 5)
+		if submitResponse.TurnID != i+1 || submitResponse.V != v {
+			t.Fatalf("submit of '%s': %+v, want turn id %d", v, submitResponse, i+1)
+		}
+		if _, learntV := untagSubmit(queries.GetLearntValue(i + 1)); learntV != v {
+			t.Fatalf("learnt value of turn id %d: '%s', want '%s'", i+1, learntV, v)
+		}
+	}
+}
+
+func TestSubmitSameValueTwice(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	first := submitWithin(t, "a", 5)
+	second := submitWithin(t, "a", 5)
+	if first.TurnID == second.TurnID {
+		t.Fatalf("both submits of 'a' got turn id %d, want one turn id each", first.TurnID)
+	}
+}
+
+func TestSubmitCarriesAcceptedValue(t *testing.T) {
+	// another proposer got 'other' accepted for turn id 1 by node 2, it must be chosen before ours
+	peer := acceptorPeer(1, proposal.Proposal{Pid: 2, Seq: 1, V: "other"})
+	defer peer.Close()
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES,
+			config.Node{ID: 2, URL: peer.URL, ROLES: []string{"acceptor"}},
+			config.Node{ID: 3, URL: deadPeer(), ROLES: []string{"acceptor"}})
+	}).stop()
+
+	submitResponse := submitWithin(t, "mine", 5)
+	if submitResponse.TurnID != 2 {
+		t.Fatalf("submit of 'mine' got turn id %d, want 2", submitResponse.TurnID)
+	}
+	if v := queries.GetLearntValue(1); v != "other" {
+		t.Fatalf("learnt value of turn id 1: '%s', want 'other'", v)
+	}
+}
+
+func TestSubmitRejectsReservedValues(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	for _, v := range []string{"", batchPrefix + "x", configPrefix + "x", submitPrefix + "x"} {
+		_, err := Submit(v, time.Now().Add(time.Second))
+		if err == nil {
+			t.Fatalf("submit of '%s' succeeded, want it refused", v)
+		}
+	}
+	if turnID := queries.GetLastTurnID(); turnID != 0 {
+		t.Fatalf("a refused submit got turn id %d learnt", turnID)
+	}
+}
+
+func TestSubmitDeadlineWithoutQuorum(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{ID: 2, URL: deadPeer()}, config.Node{ID: 3, URL: deadPeer()})
+	}).stop()
+
+	start := time.Now()
+	_, err := Submit("a", start.Add(1500*time.Millisecond))
+	if err != ErrSubmitDeadline {
+		t.Fatalf("submit without a quorum: err = %v, want ErrSubmitDeadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("submit without a quorum returned after %v, want it to give up at the deadline", elapsed)
+	}
+}
+
+func TestUntagSubmit(t *testing.T) {
+	id, v := untagSubmit(tagSubmit("1.2", "a:b"))
+	if id != "1.2" || v != "a:b" {
+		t.Fatalf("untagged submit: id '%s', value '%s', want '1.2' and 'a:b'", id, v)
+	}
+	id, v = untagSubmit("plain")
+	if id != "" || v != "plain" {
+		t.Fatalf("untagged plain value: id '%s', value '%s', want no id and 'plain'", id, v)
+	}
+}
@@ -7,12 +7,31 @@ import (
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
 // CONF is the Conf object which holds all the variables
 var CONF Conf
 
+// members holds the Conf the membership in use is read from, see Members.
+var members atomic.Value
+
+// Members returns the Conf holding the membership in use: NODE_ENTRIES, NODES, ACCEPTORS, LEARNERS and the quorum sizes.
+// CONF keeps the nodes of the '.yaml' file, the membership in use changes with the membership changes learnt (see SetMembers).
+// The returned Conf is shared, it must not be modified.
+func Members() *Conf {
+	if c, ok := members.Load().(*Conf); ok {
+		return c
+	}
+	return &CONF
+}
+
+// SetMembers makes the nodes of @c the membership in use, @c must not be modified afterwards.
+func SetMembers(c *Conf) {
+	members.Store(c)
+}
+
 // Conf is a type describing some of the meta variables used by different parts of the algorithm.
 type Conf struct {
 	DB_PATH   string `yaml:"db_path"`   // DB_PATH locates the database file.
@@ -30,15 +49,102 @@ type Conf struct {
 	PR_PROPOSALS float64 `yaml:"pr_proposals"` // PR_PROPOSALS defines the probability of removing a proposal from the dangling proposals list. It's used by the seeker to reduce the amount of requests
 	PR_NODES     float64 `yaml:"pr_nodes"`     // PR_NODES defines the probability to choose a node towards which to perform a seek request
 
-	NODES  []string `yaml:"nodes"`  // NODES defines the list of the paxos nodes of the system.
-	QUORUM int      `yaml:"quorum"` // QUORUM defines the number of positive responses needed for the algorithm to proceed. It's computed at execution time, but can be provided explicitly.
+	NODE_ENTRIES []Node   `yaml:"nodes"`  // NODE_ENTRIES defines the paxos nodes of the system, each entry is either a url or a map holding the id, the url, the roles, the weight and the zone of the node.
+	NODES        []string `yaml:"-"`      // NODES lists the urls of NODE_ENTRIES, in the same order. It's computed at execution time.
+	ACCEPTORS    []string `yaml:"-"`      // ACCEPTORS lists the urls of the nodes playing the acceptor role, the only ones counted in quorums. It's computed at execution time.
+	LEARNERS     []string `yaml:"-"`      // LEARNERS lists the urls of the nodes playing the learner role, the ones learn requests are sent to. It's computed at execution time.
+	QUORUM       int      `yaml:"quorum"` // QUORUM defines the number of positive responses (of acceptors) needed for the algorithm to proceed. It's computed at execution time, but can be provided explicitly with the "majority" policy.
+
+	QUORUM_POLICY string `yaml:"quorum_policy"` // QUORUM_POLICY selects the quorum system: "majority" (by default), "weighted" (see Node.WEIGHT) or "zones" (see Node.ZONE).
+	PHASE1_QUORUM int    `yaml:"phase1_quorum"` // PHASE1_QUORUM defines the number of promises needed by a prepare (or range prepare) request with the "majority" policy (it cannot be set with the other ones), QUORUM by default.
+	PHASE2_QUORUM int    `yaml:"phase2_quorum"` // PHASE2_QUORUM defines the number of accepts needed by an accept request with the "majority" policy (it cannot be set with the other ones), QUORUM by default. PHASE1_QUORUM + PHASE2_QUORUM must exceed the number of ACCEPTORS.
 
 	NUMBER_OF_TIDS int    `yaml:"number_of_tids"`
 	LISTENER_IP    string `yaml:"listener_ip"`
 
-	DB_TYPE    string `yaml:"db_type"`
+	DB_TYPE         string `yaml:"db_type"`         // DB_TYPE selects the storage backend: "sqlite", "redis", "wal" or "memory". Unknown values are rejected at startup.
+	DB_JOURNAL_MODE string `yaml:"db_journal_mode"` // DB_JOURNAL_MODE defines the SQLite journal mode (e.g. "WAL", "DELETE"), WAL is used by default.
+	DB_BUSY_TIMEOUT int    `yaml:"db_busy_timeout"` // DB_BUSY_TIMEOUT defines the time (in milliseconds) SQLite waits for a locked database before failing, 5000 by default.
+
+	REDIS_ADDR       string `yaml:"redis_addr"`       // REDIS_ADDR defines the "host:port" of the Redis server, "localhost:6379" by default.
+	REDIS_PASSWORD   string `yaml:"redis_password"`   // REDIS_PASSWORD defines the password of the Redis server, leave it empty if none is needed.
+	REDIS_DB         int    `yaml:"redis_db"`         // REDIS_DB defines the Redis logical database, 0 by default.
+	REDIS_KEY_PREFIX string `yaml:"redis_key_prefix"` // REDIS_KEY_PREFIX is prepended to every Redis key, nodes sharing a Redis server need different prefixes (e.g. "node1:").
+
+	FSCK_ON_STARTUP string `yaml:"fsck_on_startup"` // FSCK_ON_STARTUP defines whether the consistency of the storage is checked at startup: "" (never), "report" or "repair". Only some backends (e.g. "redis") have a checker.
+
+	WAL_PATH string `yaml:"wal_path"` // WAL_PATH locates the log file used by the "wal" backend, "./paxos.wal" by default.
+
+	COMPACTION Compaction `yaml:"compaction"` // COMPACTION defines if and how often the learnt values are folded into a snapshot.
+
+	SUBMIT_TIMEOUT time.Duration `yaml:"submit_timeout"` // SUBMIT_TIMEOUT defines the time duration (in seconds) a client submit waits for its value to be learnt when no timeout is given, 10 by default.
+
+	LEARN_FROM_ACCEPTED bool `yaml:"learn_from_accepted"` // LEARN_FROM_ACCEPTED defines whether learners only learn the values a quorum of acceptors reported as accepted with the same number, learn requests are not trusted then.
+
+	DISSEMINATION string `yaml:"dissemination"` // DISSEMINATION defines how a learner spreads a value it has just learnt: "flood" (by default, to every other learner) or "gossip" (to GOSSIP_FANOUT random learners).
+	GOSSIP_FANOUT int    `yaml:"gossip_fanout"` // GOSSIP_FANOUT defines how many random learners a value is forwarded to with the "gossip" dissemination, 3 by default.
+
+	LEARN_RETRY_INTERVAL     time.Duration `yaml:"learn_retry_interval"`     // LEARN_RETRY_INTERVAL defines the time duration (in seconds) before a learn request which has not been acknowledged is sent again, 1 by default. It doubles at each attempt.
+	LEARN_RETRY_MAX_INTERVAL time.Duration `yaml:"learn_retry_max_interval"` // LEARN_RETRY_MAX_INTERVAL caps the time duration (in seconds) between two attempts of the same learn request, 60 by default.
+
+	MEMBERSHIP_DELAY int `yaml:"membership_delay"` // MEMBERSHIP_DELAY defines how many turn ids after its own a membership change takes effect, PIPELINE_WINDOW by default.
+
+	PIPELINE_WINDOW int `yaml:"pipeline_window"` // PIPELINE_WINDOW defines how many turn ids client submits can have in flight at once, 16 by default.
+
+	BATCH_MAX_SIZE  int `yaml:"batch_max_size"`  // BATCH_MAX_SIZE defines how many submitted values at most share a single turn id, batching is disabled when it's lower than 2.
+	BATCH_MAX_DELAY int `yaml:"batch_max_delay"` // BATCH_MAX_DELAY defines the time (in milliseconds) the first value of a batch waits for other values before the batch is proposed, 10 by default.
+
+	LEADER_MODE bool `yaml:"leader_mode"` // LEADER_MODE defines whether client submits try to become the leader, so that the prepare phase is skipped for consecutive turn ids.
+
+	SELF_ID  int      `yaml:"self_id"`  // SELF_ID is the id of this node in NODE_ENTRIES, PID defaults to it. It's found through SELF_URL by default.
+	SELF_URL string   `yaml:"self_url"` // SELF_URL is the url of this node as listed in NODES, it's advertised to the other nodes when leading. The url of SELF_ID, or "http://localhost:<PORT>", by default.
+	ELECTION Election `yaml:"election"` // ELECTION defines whether a leader is elected through heartbeats, and how fast.
+
+	OPTIMIZATION bool `yaml:"optimization"`
+
+	givenQuorums [3]int // givenQuorums holds QUORUM, PHASE1_QUORUM and PHASE2_QUORUM as found in the '.yaml' file, see WithNodes.
+	randomPID    bool   // randomPID tells whether PID has been picked at random, see UsePID.
+	defaultV     bool   // defaultV tells whether V_DEFAULT has been derived from PID.
+}
+
+// Roles lists the roles a node can play, every node plays all of them by default.
+// A node only serves the routes of its roles, e.g. a learner-only node is a read replica which is never counted in quorums.
+var Roles = []string{"proposer", "acceptor", "learner"}
+
+// Node describes an entry of the 'nodes' section of the '.yaml' file, written either as a plain url or as a map.
+type Node struct {
+	ID     int      `yaml:"id"`     // ID identifies the node, it's the PID the node uses in its proposals. 0 when the entry is a plain url.
+	URL    string   `yaml:"url"`    // URL is where the node can be reached.
+	ROLES  []string `yaml:"roles"`  // ROLES lists the roles played by the node, see Roles.
+	WEIGHT int      `yaml:"weight"` // WEIGHT is the weight of the node with the "weighted" quorum policy, 1 by default.
+	ZONE   string   `yaml:"zone"`   // ZONE is the zone (e.g. the rack) of the node with the "zones" quorum policy.
+}
+
+// UnmarshalYAML lets an entry of the 'nodes' section be a plain url, as it used to be, or a map.
+func (n *Node) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var url string
+	if err := unmarshal(&url); err == nil {
+		*n = Node{URL: url}
+		return nil
+	}
+
+	// plain has the fields of Node but not its UnmarshalYAML method, avoiding an endless recursion
+	type plain Node
+	return unmarshal((*plain)(n))
+}
+
+// Compaction describes the 'compaction' section of the '.yaml' file.
+type Compaction struct {
+	ENABLED  bool          `yaml:"enabled"`  // ENABLED defines whether checkpoints are taken periodically. Checkpoints can always be taken by hand through /node/snapshot.
+	INTERVAL time.Duration `yaml:"interval"` // INTERVAL defines the time duration (in seconds) between two checkpoints, 60 by default.
+	RETAIN   int           `yaml:"retain"`   // RETAIN defines how many of the last learnt turn ids are kept out of the snapshot, so that peers slightly behind keep receiving single values.
+}
 
-	OPTIMIZATION 	bool `yaml:"optimization"`
+// Election describes the 'election' section of the '.yaml' file.
+type Election struct {
+	ENABLED            bool          `yaml:"enabled"`            // ENABLED defines whether the nodes elect a leader, client submits received by the other nodes are forwarded to it.
+	HEARTBEAT_INTERVAL time.Duration `yaml:"heartbeat_interval"` // HEARTBEAT_INTERVAL defines the time duration (in seconds) between two heartbeats of the leader, 1 by default.
+	LEADER_TIMEOUT     time.Duration `yaml:"leader_timeout"`     // LEADER_TIMEOUT defines the time duration (in seconds) without heartbeats after which the leader is considered dead, 3 by default.
 }
 
 // LoadConfigFile loads the config '.yaml' file onto the callee Conf object.
@@ -58,12 +164,50 @@ func (c *Conf) LoadConfigFile(fn string) {
 // These are the only fields which can be left blank, if one of the field is not initialized by this function, has to be initialized by the user in the '.yaml' file.
 func (c *Conf) FillEmptyFields() {
 
+	c.givenQuorums = [3]int{c.QUORUM, c.PHASE1_QUORUM, c.PHASE2_QUORUM}
+
+	c.NODES, c.ACCEPTORS, c.LEARNERS = nil, nil, nil
+	for i := range c.NODE_ENTRIES {
+		if c.NODE_ENTRIES[i].WEIGHT == 0 {
+			c.NODE_ENTRIES[i].WEIGHT = 1
+		}
+		if len(c.NODE_ENTRIES[i].ROLES) == 0 {
+			c.NODE_ENTRIES[i].ROLES = append([]string(nil), Roles...)
+		}
+		c.NODES = append(c.NODES, c.NODE_ENTRIES[i].URL)
+		if c.NODE_ENTRIES[i].Plays("acceptor") {
+			c.ACCEPTORS = append(c.ACCEPTORS, c.NODE_ENTRIES[i].URL)
+		}
+		if c.NODE_ENTRIES[i].Plays("learner") {
+			c.LEARNERS = append(c.LEARNERS, c.NODE_ENTRIES[i].URL)
+		}
+	}
+
+	if self, found := c.NodeByID(c.SELF_ID); c.SELF_URL == "" && c.SELF_ID != 0 && found {
+		c.SELF_URL = self.URL
+	}
+
+	if c.SELF_URL == "" {
+		c.SELF_URL = fmt.Sprintf("http://localhost:%d", c.PORT)
+	}
+
+	if self, found := c.NodeByURL(c.SELF_URL); c.SELF_ID == 0 && found {
+		c.SELF_ID = self.ID
+	}
+
+	if c.PID == 0 && c.SELF_ID != 0 {
+		c.PID = c.SELF_ID
+	}
+
 	if c.PID == 0 {
-		c.PID = rand.Intn(10000)
+		// 0 is never a valid pid
+		c.PID = rand.Intn(10000) + 1
+		c.randomPID = true
 	}
 
 	if c.V_DEFAULT == "" {
 		c.V_DEFAULT = fmt.Sprintf("paxos@%d", c.PID)
+		c.defaultV = true
 	}
 
 	if c.TIMEOUT == 0 {
@@ -74,8 +218,238 @@ func (c *Conf) FillEmptyFields() {
 		c.SEEK_TIMEOUT = 5
 	}
 
+	if c.DB_TYPE == "" {
+		c.DB_TYPE = "sqlite"
+	}
+
+	if c.DB_JOURNAL_MODE == "" {
+		c.DB_JOURNAL_MODE = "WAL"
+	}
+
+	if c.DB_BUSY_TIMEOUT == 0 {
+		c.DB_BUSY_TIMEOUT = 5000
+	}
+
+	if c.REDIS_ADDR == "" {
+		c.REDIS_ADDR = "localhost:6379"
+	}
+
+	if c.WAL_PATH == "" {
+		c.WAL_PATH = "./paxos.wal"
+	}
+
+	if c.COMPACTION.INTERVAL == 0 {
+		c.COMPACTION.INTERVAL = 60
+	}
+
+	if c.SUBMIT_TIMEOUT == 0 {
+		c.SUBMIT_TIMEOUT = 10
+	}
+
+	if c.DISSEMINATION == "" {
+		c.DISSEMINATION = "flood"
+	}
+
+	if c.GOSSIP_FANOUT == 0 {
+		c.GOSSIP_FANOUT = 3
+	}
+
+	if c.LEARN_RETRY_INTERVAL == 0 {
+		c.LEARN_RETRY_INTERVAL = 1
+	}
+
+	if c.LEARN_RETRY_MAX_INTERVAL == 0 {
+		c.LEARN_RETRY_MAX_INTERVAL = 60
+	}
+
+	if c.PIPELINE_WINDOW == 0 {
+		c.PIPELINE_WINDOW = 16
+	}
+
+	if c.MEMBERSHIP_DELAY == 0 {
+		c.MEMBERSHIP_DELAY = c.PIPELINE_WINDOW
+	}
+
+	if c.BATCH_MAX_DELAY == 0 {
+		c.BATCH_MAX_DELAY = 10
+	}
+
+	if c.ELECTION.HEARTBEAT_INTERVAL == 0 {
+		c.ELECTION.HEARTBEAT_INTERVAL = 1
+	}
+
+	if c.ELECTION.LEADER_TIMEOUT == 0 {
+		c.ELECTION.LEADER_TIMEOUT = 3
+	}
+
 	if c.QUORUM == 0 {
-		c.QUORUM = len(c.NODES)/2 + 1
+		c.QUORUM = len(c.ACCEPTORS)/2 + 1
+	}
+
+	if c.QUORUM_POLICY == "" {
+		c.QUORUM_POLICY = "majority"
+	}
+
+	if c.PHASE1_QUORUM == 0 {
+		c.PHASE1_QUORUM = c.QUORUM
 	}
 
+	if c.PHASE2_QUORUM == 0 {
+		c.PHASE2_QUORUM = c.QUORUM
+	}
+
+}
+
+// WithNodes returns a copy of the callee Conf where NODE_ENTRIES is replaced by @entries.
+// The fields computed out of the nodes (NODES, ACCEPTORS, LEARNERS and the quorum sizes left empty in the '.yaml' file) are computed again.
+func (c Conf) WithNodes(entries []Node) Conf {
+	c.NODE_ENTRIES = append([]Node(nil), entries...)
+	c.QUORUM, c.PHASE1_QUORUM, c.PHASE2_QUORUM = c.givenQuorums[0], c.givenQuorums[1], c.givenQuorums[2]
+	c.FillEmptyFields()
+	return c
+}
+
+// QuorumSizesGiven tells whether QUORUM, PHASE1_QUORUM or PHASE2_QUORUM has been set in the '.yaml' file, rather than computed.
+func (c *Conf) QuorumSizesGiven() bool {
+	return c.givenQuorums != [3]int{}
+}
+
+// RandomPID tells whether PID has been picked at random, i.e. whether it was left empty in the '.yaml' file.
+func (c *Conf) RandomPID() bool {
+	return c.randomPID
+}
+
+// UsePID replaces PID with @pid, e.g. with the random pid picked by a previous run of the node. V_DEFAULT follows PID when it was left empty.
+func (c *Conf) UsePID(pid int) {
+	c.PID = pid
+	if c.defaultV {
+		c.V_DEFAULT = fmt.Sprintf("paxos@%d", c.PID)
+	}
+}
+
+// CheckNodes returns an error when two entries of NODE_ENTRIES share their id or their url, when a role is unknown or played by no node.
+// The entry of this node, when it has an id, must match PID.
+func (c *Conf) CheckNodes() error {
+	played := make(map[string]bool)
+	ids := make(map[int]bool)
+	urls := make(map[string]bool)
+	for _, node := range c.NODE_ENTRIES {
+		if node.URL == "" {
+			return fmt.Errorf("the url of node %d is missing", node.ID)
+		}
+		if urls[node.URL] {
+			return fmt.Errorf("url %s is listed twice", node.URL)
+		}
+		urls[node.URL] = true
+
+		if node.ID < 0 || ids[node.ID] {
+			return fmt.Errorf("id %d of %s is not valid or is used twice", node.ID, node.URL)
+		}
+		if node.ID != 0 {
+			ids[node.ID] = true
+		}
+
+		for _, role := range node.ROLES {
+			if !isRole(role) {
+				return fmt.Errorf("unknown role %q of %s, valid roles are: %v", role, node.URL, Roles)
+			}
+			played[role] = true
+		}
+	}
+	for _, role := range Roles {
+		if !played[role] {
+			return fmt.Errorf("no node plays the %s role", role)
+		}
+	}
+
+	if self, found := c.NodeByURL(c.SELF_URL); found && self.ID != 0 && self.ID != c.PID {
+		return fmt.Errorf("this node (%s) has id %d but its pid is %d", c.SELF_URL, self.ID, c.PID)
+	}
+	return nil
+}
+
+// isRole tells whether @role is one of Roles.
+func isRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Plays tells whether the node plays @role.
+func (n Node) Plays(role string) bool {
+	for _, r := range n.ROLES {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// SelfPlays tells whether this node plays @role. A node which is not listed in NODE_ENTRIES (e.g. removed by a membership change) plays every role.
+func (c *Conf) SelfPlays(role string) bool {
+	if self, found := c.NodeByURL(c.SELF_URL); found {
+		return self.Plays(role)
+	}
+	return true
+}
+
+// NodeByID returns the entry of NODE_ENTRIES whose id is @id, the boolean is false when there is none.
+func (c *Conf) NodeByID(id int) (Node, bool) {
+	for _, node := range c.NODE_ENTRIES {
+		if id != 0 && node.ID == id {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// NodeByURL returns the entry of NODE_ENTRIES whose url is @url, the boolean is false when there is none.
+func (c *Conf) NodeByURL(url string) (Node, bool) {
+	for _, node := range c.NODE_ENTRIES {
+		if node.URL == url {
+			return node, true
+		}
+	}
+	return Node{}, false
+}
+
+// Peers returns the urls of NODES, this node excluded.
+func (c *Conf) Peers() []string {
+	var peers []string
+	for _, node := range c.NODES {
+		if node != c.SELF_URL {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+// LearnerPeers returns the urls of LEARNERS, this node excluded.
+func (c *Conf) LearnerPeers() []string {
+	var peers []string
+	for _, node := range c.LEARNERS {
+		if node != c.SELF_URL {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+// NodeName returns a name for the node at @url to be used in logs and responses, e.g. "node 2 (http://localhost:7772)".
+func (c *Conf) NodeName(url string) string {
+	if node, found := c.NodeByURL(url); found && node.ID != 0 {
+		return fmt.Sprintf("node %d (%s)", node.ID, node.URL)
+	}
+	return url
+}
+
+// PIDName returns a name for the node whose pid is @pid to be used in logs and responses, e.g. "node 2 (http://localhost:7772)".
+func (c *Conf) PIDName(pid int) string {
+	if node, found := c.NodeByID(pid); found {
+		return fmt.Sprintf("node %d (%s)", node.ID, node.URL)
+	}
+	return fmt.Sprintf("pid %d", pid)
 }
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+// confFrom decodes @doc as a '.yaml' file and fills its empty fields.
+func confFrom(t *testing.T, doc string) *Conf {
+	t.Helper()
+
+	c := &Conf{}
+	err := yaml.Unmarshal([]byte(doc), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.FillEmptyFields()
+	return c
+}
+
+func TestNodeEntries(t *testing.T) {
+	c := confFrom(t, `
+port: 7772
+nodes:
+  - http://localhost:7771
+  - id: 7
+    url: http://localhost:7772
+    roles: [acceptor]
+  - {id: 3, url: "http://localhost:7773", weight: 2, zone: b}
+`)
+
+	want := []Node{
+		{URL: "http://localhost:7771", ROLES: Roles, WEIGHT: 1},
+		{ID: 7, URL: "http://localhost:7772", ROLES: []string{"acceptor"}, WEIGHT: 1},
+		{ID: 3, URL: "http://localhost:7773", ROLES: Roles, WEIGHT: 2, ZONE: "b"},
+	}
+	if len(c.NODE_ENTRIES) != len(want) {
+		t.Fatalf("nodes: %+v, want %+v", c.NODE_ENTRIES, want)
+	}
+	for i, node := range c.NODE_ENTRIES {
+		if node.ID != want[i].ID || node.URL != want[i].URL || node.WEIGHT != want[i].WEIGHT || node.ZONE != want[i].ZONE || len(node.ROLES) != len(want[i].ROLES) {
+			t.Errorf("node %d: %+v, want %+v", i, node, want[i])
+		}
+	}
+
+	// the entry of this node is found through its url, its id is its pid
+	if c.SELF_ID != 7 || c.PID != 7 {
+		t.Errorf("self id %d, pid %d, want the id of the entry of http://localhost:7772 (7)", c.SELF_ID, c.PID)
+	}
+	if len(c.ACCEPTORS) != 3 || len(c.LEARNERS) != 2 {
+		t.Errorf("acceptors %v, learners %v, want the acceptor-only node out of the learners", c.ACCEPTORS, c.LEARNERS)
+	}
+	if name := c.PIDName(3); name != "node 3 (http://localhost:7773)" {
+		t.Errorf("name of pid 3: '%s'", name)
+	}
+	if name := c.NodeName("http://localhost:7771"); name != "http://localhost:7771" {
+		t.Errorf("name of a node without id: '%s', want its url", name)
+	}
+	if err := c.CheckNodes(); err != nil {
+		t.Errorf("valid nodes refused: %v", err)
+	}
+}
+
+func TestSelfID(t *testing.T) {
+	c := confFrom(t, `
+self_id: 2
+nodes:
+  - {id: 1, url: "http://localhost:7771"}
+  - {id: 2, url: "http://localhost:7772"}
+`)
+	if c.SELF_URL != "http://localhost:7772" || c.PID != 2 {
+		t.Errorf("self url '%s', pid %d, want the entry of id 2", c.SELF_URL, c.PID)
+	}
+}
+
+func TestCheckNodesRefusesInvalidEntries(t *testing.T) {
+	invalid := map[string]string{
+		"same id":      "nodes: [{id: 1, url: 'http://a'}, {id: 1, url: 'http://b'}]",
+		"same url":     "nodes: ['http://a', 'http://a']",
+		"missing url":  "nodes: [{id: 1}, 'http://b']",
+		"unknown role": "nodes: [{url: 'http://a', roles: [leader]}]",
+		"no learner":   "nodes: [{url: 'http://a', roles: [proposer, acceptor]}]",
+		"wrong pid":    "pid: 2\nself_url: http://a\nnodes: [{id: 1, url: 'http://a'}]",
+	}
+	for name, doc := range invalid {
+		if err := confFrom(t, doc).CheckNodes(); err == nil {
+			t.Errorf("%s: the nodes have been accepted", name)
+		}
+	}
+}
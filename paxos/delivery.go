@@ -0,0 +1,277 @@
+/*
+
+# Learn delivery:
+A learn request sent to a learner which is down used to be lost, the learner missed the value until the seeker happened to ask for it.
+Every learn request sent by this node (see SendLearn, learnQuorum, floodLearntValue and gossipLearntValue) is tracked per peer until the peer acknowledges it,
+i.e. until the peer answers holding a value for the turn id:
+
+	(a) A peer which does not answer, or answers without a learnt value (e.g. "not chosen yet" with learn_from_accepted), is sent the request again
+		LEARN_RETRY_INTERVAL seconds later, the interval doubling at each attempt up to LEARN_RETRY_MAX_INTERVAL seconds;
+	---AND---
+	(b) The learn requests waiting for an acknowledgement are kept in the storage, one meta key per turn id and peer, so that a restart of this node does not forget them.
+		A learn request is written when it's first sent and deleted when it's acknowledged, before the call returns. Only the attempts and the next retry of the requests
+		sent again are written on the next retry tick (as the writes which failed are): a crash loses at most a few attempts, never a learn request.
+
+A peer which is not a learner of the turn id anymore (see learnersOf) is not waited for.
+The learn requests waiting for an acknowledgement are exposed through /learner/pending.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pendingLearnMetaPrefix prefixes the meta keys under which the learn requests waiting for an acknowledgement are stored, one key per turn id and peer (see pendingLearnMetaKey).
+const pendingLearnMetaPrefix = "pending_learn:"
+
+// legacyPendingLearnsMetaKey is the meta key under which the learn requests waiting for an acknowledgement used to be stored all together.
+const legacyPendingLearnsMetaKey = "pending_learns"
+
+// delivery identifies the learn request of a turn id sent to a peer.
+type delivery struct {
+	peer   string
+	turnID int
+}
+
+// pendingLearnMetaKey returns the meta key under which the learn request @key is stored.
+func pendingLearnMetaKey(key delivery) string {
+	return fmt.Sprintf("%s%d:%s", pendingLearnMetaPrefix, key.turnID, key.peer)
+}
+
+// deliveries holds the learn requests waiting for an acknowledgement.
+var deliveries struct {
+	sync.Mutex
+	pending map[delivery]*messages.PendingLearn // pending is nil until InitDeliveries is called.
+	dirty   map[delivery]bool                   // dirty holds the learn requests changed since they were last written (retries, failed writes), they are written on the next retry tick.
+}
+
+// InitDeliveries loads the learn requests left waiting for an acknowledgement by a previous run of the node.
+func InitDeliveries() error {
+	deliveries.Lock()
+	defer deliveries.Unlock()
+
+	deliveries.pending = make(map[delivery]*messages.PendingLearn)
+	deliveries.dirty = make(map[delivery]bool)
+	stored, err := queries.ListMeta(pendingLearnMetaPrefix)
+	if err != nil {
+		return err
+	}
+	for metaKey, encoded := range stored {
+		pendingLearn := &messages.PendingLearn{}
+		err = json.Unmarshal([]byte(encoded), pendingLearn)
+		if err != nil {
+			return fmt.Errorf("invalid %s in the storage: %v", metaKey, err)
+		}
+		deliveries.pending[delivery{peer: pendingLearn.Peer, turnID: pendingLearn.TurnID}] = pendingLearn
+	}
+	return migrateLegacyDeliveries()
+}
+
+// migrateLegacyDeliveries moves the learn requests stored all together under legacyPendingLearnsMetaKey to their own keys, the caller must hold the lock.
+func migrateLegacyDeliveries() error {
+	stored, err := queries.GetMeta(legacyPendingLearnsMetaKey)
+	if err != nil || stored == "" {
+		return err
+	}
+
+	var pending []messages.PendingLearn
+	err = json.Unmarshal([]byte(stored), &pending)
+	if err != nil {
+		return fmt.Errorf("invalid %s in the storage: %v", legacyPendingLearnsMetaKey, err)
+	}
+	for i := range pending {
+		key := delivery{peer: pending[i].Peer, turnID: pending[i].TurnID}
+		deliveries.pending[key] = &pending[i]
+		deliveries.dirty[key] = true
+	}
+	if !storeDeliveries() {
+		return fmt.Errorf("could not move the learn requests of %s to their own keys", legacyPendingLearnsMetaKey)
+	}
+	return queries.DeleteMeta(legacyPendingLearnsMetaKey)
+}
+
+// storeDeliveries writes the learn requests changed since the last call, and deletes the acknowledged ones, the caller must hold the lock.
+// The changes which could not be written are kept for the next call, storeDeliveries returns whether all of them were written.
+func storeDeliveries() bool {
+	for key := range deliveries.dirty {
+		err := storeDelivery(key)
+		if err != nil {
+			log.Printf("[LEARNER] -> Could not store the learn request of turn id %d to %s, trying again on the next retry: %v", key.turnID, config.Members().NodeName(key.peer), err)
+			return false
+		}
+		delete(deliveries.dirty, key)
+	}
+	return true
+}
+
+// storeDelivery writes the learn request @key, or deletes it when it's not tracked anymore, the caller must hold the lock.
+func storeDelivery(key delivery) error {
+	pendingLearn, tracked := deliveries.pending[key]
+	if !tracked {
+		return queries.DeleteMeta(pendingLearnMetaKey(key))
+	}
+	encoded, _ := json.Marshal(pendingLearn)
+	return queries.SetMeta(pendingLearnMetaKey(key), string(encoded))
+}
+
+// storeDeliveryNow writes the change to the learn request @key at once, it's left for the next retry tick when it could not be written. The caller must hold the lock.
+func storeDeliveryNow(key delivery) {
+	err := storeDelivery(key)
+	if err != nil {
+		log.Printf("[LEARNER] -> Could not store the learn request of turn id %d to %s, trying again on the next retry: %v", key.turnID, config.Members().NodeName(key.peer), err)
+		deliveries.dirty[key] = true
+		return
+	}
+	delete(deliveries.dirty, key)
+}
+
+// retryInterval returns the time waited after the attempt number @attempts before sending the learn request again.
+func retryInterval(attempts int) time.Duration {
+	interval := config.CONF.LEARN_RETRY_INTERVAL * time.Second
+	maxInterval := config.CONF.LEARN_RETRY_MAX_INTERVAL * time.Second
+	for i := 1; i < attempts && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+	return interval
+}
+
+// trackLearns records that the learn request (@turnID, @v) is being sent to @peers, they are sent it again until they acknowledge it.
+// A learn request sent for the first time is written to the storage before trackLearns returns, the later attempts on the next retry tick.
+func trackLearns(peers []string, turnID int, v string) {
+	deliveries.Lock()
+	defer deliveries.Unlock()
+
+	if deliveries.pending == nil {
+		return
+	}
+	for _, peer := range peers {
+		key := delivery{peer: peer, turnID: turnID}
+		pendingLearn, tracked := deliveries.pending[key]
+		if !tracked {
+			pendingLearn = &messages.PendingLearn{Peer: peer, TurnID: turnID}
+			deliveries.pending[key] = pendingLearn
+		}
+		pendingLearn.V = v
+		pendingLearn.Attempts += 1
+		pendingLearn.NextRetry = time.Now().Add(retryInterval(pendingLearn.Attempts))
+		if tracked {
+			deliveries.dirty[key] = true
+		} else {
+			storeDeliveryNow(key)
+		}
+	}
+}
+
+// ackLearns records that @peers have acknowledged the learn request of @turnID, their learn requests are deleted from the storage before ackLearns returns.
+func ackLearns(peers []string, turnID int) {
+	deliveries.Lock()
+	defer deliveries.Unlock()
+
+	for _, peer := range peers {
+		key := delivery{peer: peer, turnID: turnID}
+		if _, tracked := deliveries.pending[key]; tracked {
+			delete(deliveries.pending, key)
+			storeDeliveryNow(key)
+		}
+	}
+}
+
+// isLearnAck tells whether the response to a learn request acknowledges it: the peer holds a value for the turn id, be it the requested one or a conflicting one.
+func isLearnAck(response nodeResponse) bool {
+	// @response.data is nil when a node did not respond in time
+	if response.data == nil {
+		return false
+	}
+	responseMessage := messages.GenericMessage{}
+	err := json.Unmarshal(response.data, &responseMessage)
+	if err != nil {
+		log.Print(err.Error())
+		return false
+	}
+	return ResponseHasLearntValue(responseMessage)
+}
+
+// collectLearnAcks waits for the responses to the learn requests of @turnID collected in @ch and records the acknowledgements.
+func collectLearnAcks(ch chan nodeResponse, turnID int) {
+	var ackers []string
+	for i := 0; i < cap(ch); i++ {
+		response := <-ch
+		if isLearnAck(response) {
+			ackers = append(ackers, response.node)
+		}
+	}
+	ackLearns(ackers, turnID)
+}
+
+// RetryPendingLearns sends again the learn requests whose peers have not acknowledged them in time, it's called periodically.
+// The learn requests of the peers which are not learners of the turn id anymore are dropped.
+// The attempts of the learn requests sent again, and the changes which could not be written before, are written to the storage at once.
+func RetryPendingLearns() {
+	deliveries.Lock()
+	due := make(map[int][]string)
+	values := make(map[int]string)
+	for key, pendingLearn := range deliveries.pending {
+		if time.Now().Before(pendingLearn.NextRetry) {
+			continue
+		}
+		if len(quorum.Without([]string{key.peer}, learnersOf(key.turnID))) != 0 {
+			log.Printf("[LEARNER] -> %s is not a learner of turn id %d anymore, dropping its learn request.", config.Members().NodeName(key.peer), key.turnID)
+			delete(deliveries.pending, key)
+			deliveries.dirty[key] = true
+			continue
+		}
+		due[key.turnID] = append(due[key.turnID], key.peer)
+		values[key.turnID] = pendingLearn.V
+	}
+	deliveries.Unlock()
+
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	for turnID, peers := range due {
+		log.Printf("[LEARNER] -> %d peer(s) have not acknowledged the learn request of turn id %d, sending it again.", len(peers), turnID)
+		trackLearns(peers, turnID, values[turnID])
+		go collectLearnAcks(broadcastLearn(session, peers, turnID, values[turnID]), turnID)
+	}
+
+	deliveries.Lock()
+	storeDeliveries()
+	deliveries.Unlock()
+}
+
+// GetPendingLearns returns, for each peer, the learn requests it has not acknowledged yet.
+func GetPendingLearns() []messages.PeerPendingLearns {
+	deliveries.Lock()
+	defer deliveries.Unlock()
+
+	byPeer := make(map[string]*messages.PeerPendingLearns)
+	for _, pendingLearn := range deliveries.pending {
+		peerPending, exists := byPeer[pendingLearn.Peer]
+		if !exists {
+			peerPending = &messages.PeerPendingLearns{Peer: pendingLearn.Peer, Name: config.Members().NodeName(pendingLearn.Peer)}
+			byPeer[pendingLearn.Peer] = peerPending
+		}
+		peerPending.Pending = append(peerPending.Pending, *pendingLearn)
+	}
+
+	pending := []messages.PeerPendingLearns{}
+	for _, peerPending := range byPeer {
+		sort.Slice(peerPending.Pending, func(i, j int) bool { return peerPending.Pending[i].TurnID < peerPending.Pending[j].TurnID })
+		pending = append(pending, *peerPending)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Peer < pending[j].Peer })
+	return pending
+}
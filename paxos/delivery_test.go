@@ -0,0 +1,85 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/queries"
+	"testing"
+	"time"
+)
+
+// storedLearn returns the learn request of @turnID to @peer as written in the storage, "" when none is.
+func storedLearn(t *testing.T, peer string, turnID int) string {
+	t.Helper()
+
+	stored, err := queries.ListMeta(pendingLearnMetaPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored[pendingLearnMetaKey(delivery{peer: peer, turnID: turnID})]
+}
+
+func TestLearnRequestIsStoredWhenSent(t *testing.T) {
+	peer := startLearnerPeer(nil)
+	defer peer.Close()
+	down := deadPeer()
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES,
+			config.Node{ID: 2, URL: peer.URL, ROLES: []string{"learner"}},
+			config.Node{ID: 3, URL: down, ROLES: []string{"learner"}})
+	}).stop()
+
+	// the learn request is in the storage as soon as the submit returns, not on the next retry tick
+	submitWithin(t, "a", 5)
+	if storedLearn(t, down, 1) == "" {
+		t.Fatal("the learn request of turn id 1 to the node which is down has not been stored")
+	}
+	if storedLearn(t, peer.URL, 1) != "" {
+		t.Fatal("the acknowledged learn request of turn id 1 is still stored")
+	}
+
+	ackLearns([]string{down}, 1)
+	if storedLearn(t, down, 1) != "" {
+		t.Fatal("the learn request of turn id 1 is still stored once acknowledged")
+	}
+}
+
+func TestPendingLearnsSurviveRestart(t *testing.T) {
+	down := deadPeer()
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{ID: 2, URL: down, ROLES: []string{"learner"}})
+	}).stop()
+
+	trackLearns([]string{down}, 1, "a")
+	err := InitDeliveries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := GetPendingLearns()
+	if len(pending) != 1 || len(pending[0].Pending) != 1 || pending[0].Pending[0].V != "a" {
+		t.Fatalf("learn requests waiting after a restart: %+v, want 'a' to the node which is down", pending)
+	}
+}
+
+func TestPendingLearnsAreSentAgain(t *testing.T) {
+	peer := startLearnerPeer(nil)
+	defer peer.Close()
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{ID: 2, URL: peer.URL, ROLES: []string{"learner"}})
+	}).stop()
+
+	// the learn request has been tracked but never received, e.g. the peer was down
+	trackLearns([]string{peer.URL}, 1, "a")
+	deliveries.Lock()
+	deliveries.pending[delivery{peer: peer.URL, turnID: 1}].NextRetry = time.Now()
+	deliveries.Unlock()
+
+	RetryPendingLearns()
+	waitFor(t, "the learn request to be acknowledged", func() bool { return len(GetPendingLearns()) == 0 })
+	if v := peer.Learnt(1); v != "a" {
+		t.Fatalf("value of turn id 1 learnt by the peer: '%s', want 'a'", v)
+	}
+	if storedLearn(t, peer.URL, 1) != "" {
+		t.Fatal("the learn request of turn id 1 is still stored once acknowledged")
+	}
+}
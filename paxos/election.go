@@ -0,0 +1,200 @@
+/*
+
+# Leader election:
+The leader is the proposer holding the highest-numbered range promise (see 'leader.go').
+Every HEARTBEAT_INTERVAL seconds the leader sends a heartbeat carrying its number and its url to each node:
+
+	(a) A node that hears no heartbeat for LEADER_TIMEOUT seconds waits a random amount of time and, if still no heartbeat arrived,
+	    sends a range prepare request with a number higher than any seen so far, i.e. it runs for leadership;
+	---AND---
+	(b) A leader that hears of a higher number (in a heartbeat or in the response to one) steps down.
+
+While a node hears the heartbeats of a leader, its acceptor refuses the prepare, range prepare and accept requests of any other node.
+Hence a leader whose heartbeat has been acknowledged by enough nodes to meet every phase-1 quorum holds a lease: until half of LEADER_TIMEOUT has elapsed since the heartbeat
+was sent (the other half covers clock drift) no other node can get a value chosen, and reads can be served by the leader alone (see Read).
+The lease assumes that no clock runs twice as fast as another one: the nodes measure LEADER_TIMEOUT from the time they receive the heartbeat,
+the leader measures half of it from the time it sent the heartbeat, both with their own clock. Jumps of the wall clock are harmless, the elapsed times are measured
+with the monotonic clock; a leader paused for longer than its lease (e.g. a stopped VM) might serve a stale read though.
+
+Nodes that are not the leader forward the client submits they receive to the leader, see SubmitToLeader.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// knownLeader holds the leader this node believes in, as announced by the last heartbeat received.
+var knownLeader struct {
+	sync.Mutex
+	heartbeat messages.Heartbeat
+	lastSeen  time.Time
+}
+
+// lease holds the leader lease of this node, it's extended each time a quorum acknowledges a heartbeat.
+var lease struct {
+	sync.Mutex
+	ballot proposal.Proposal // ballot is the number of the leader the lease has been granted to.
+	until  time.Time
+}
+
+// holdsLease tells whether this node is the leader and its lease has not expired.
+func holdsLease() bool {
+	ballot, _, leading := leaderBallot()
+
+	lease.Lock()
+	defer lease.Unlock()
+	return leading && lease.ballot.IsEqualTo(&ballot) && time.Now().Before(lease.until)
+}
+
+// refusesProposer tells whether the acceptor has to refuse the requests of the proposer @pid, because it hears the heartbeats of another leader.
+// The heartbeat of that leader is returned. Nothing is ever refused when the election is disabled.
+func refusesProposer(pid int) (messages.Heartbeat, bool) {
+	if !config.CONF.ELECTION.ENABLED {
+		return messages.Heartbeat{}, false
+	}
+
+	knownLeader.Lock()
+	defer knownLeader.Unlock()
+	return knownLeader.heartbeat, leaderAlive() && knownLeader.heartbeat.Ballot.Pid != pid
+}
+
+// leaderAlive tells whether a heartbeat of the known leader has been received within the leader timeout. The caller must hold knownLeader lock.
+func leaderAlive() bool {
+	return !knownLeader.lastSeen.IsZero() && time.Since(knownLeader.lastSeen) < config.CONF.ELECTION.LEADER_TIMEOUT*time.Second
+}
+
+// ReceiveHeartbeat implements a node's behaviour when receiving a heartbeat.
+// The sender becomes the known leader when its number is not lower than the known leader's one, or when the known leader timed out.
+// The heartbeat of the leader this node believes in is returned, so that a stale leader learns it has to step down.
+func ReceiveHeartbeat(heartbeat messages.Heartbeat) messages.Heartbeat {
+	knownLeader.Lock()
+	defer knownLeader.Unlock()
+
+	current := knownLeader.heartbeat
+	if heartbeat.Ballot.IsGEThan(&current.Ballot) || !leaderAlive() {
+		if !heartbeat.Ballot.IsEqualTo(&current.Ballot) {
+			log.Printf("[ELECTION] -> %s is the leader for turn ids >= %d, seq: %d.", config.Members().NodeName(heartbeat.URL), heartbeat.FromTurnID, heartbeat.Ballot.Seq)
+		}
+		knownLeader.heartbeat = heartbeat
+		knownLeader.lastSeen = time.Now()
+
+		if heartbeat.Ballot.Pid != config.CONF.PID {
+			yieldTo(heartbeat.Ballot)
+		}
+	}
+	return knownLeader.heartbeat
+}
+
+// GetLeader returns the leader this node believes in.
+func GetLeader() messages.LeaderInfo {
+	knownLeader.Lock()
+	defer knownLeader.Unlock()
+
+	_, _, leading := leaderBallot()
+	leaderInfo := messages.LeaderInfo{
+		Heartbeat: knownLeader.heartbeat,
+		IsSelf:    leading,
+		Alive:     leading || leaderAlive(),
+		LastSeen:  knownLeader.lastSeen,
+	}
+	if leaderInfo.Ballot.Pid != 0 {
+		leaderInfo.Name = config.Members().PIDName(leaderInfo.Ballot.Pid)
+	}
+	return leaderInfo
+}
+
+// LeaderURL returns the url of the leader when it's alive and it's not this node.
+func LeaderURL() (string, bool) {
+	leader := GetLeader()
+	if leader.IsSelf || !leader.Alive || leader.Ballot.Pid == config.CONF.PID || leader.URL == "" {
+		return "", false
+	}
+	return leader.URL, true
+}
+
+// sendHeartbeats sends @heartbeat to each node, responses are checked in the background so that an unreachable node never delays the next heartbeat.
+// The lease is extended as soon as enough nodes to meet every phase-1 quorum acknowledge @heartbeat.
+func sendHeartbeats(heartbeat messages.Heartbeat) {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	sentAt := time.Now()
+	nodes := config.Members().NODES
+	ch := make(chan nodeResponse, len(nodes))
+
+	for _, node := range nodes {
+		go sendTaggedRequest(session, node, "/node/heartbeat", ch, heartbeat)
+	}
+
+	go func() {
+		var ackers []string
+		leased := false
+		for i := 0; i < cap(ch); i++ {
+			response := <-ch
+			if response.data == nil {
+				continue
+			}
+			responseMessage := messages.Heartbeat{}
+			if err := json.Unmarshal(response.data, &responseMessage); err != nil {
+				log.Print(err.Error())
+				continue
+			}
+			if responseMessage.Ballot.IsGreaterThan(&heartbeat.Ballot) {
+				yieldTo(responseMessage.Ballot)
+			} else if responseMessage.Ballot.IsEqualTo(&heartbeat.Ballot) {
+				ackers = append(ackers, response.node)
+				if !leased && quorum.MeetsEveryPhase1Quorum(ackers) {
+					leased = true
+					lease.Lock()
+					lease.ballot = heartbeat.Ballot
+					lease.until = sentAt.Add(config.CONF.ELECTION.LEADER_TIMEOUT * time.Second / 2)
+					lease.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+// TickElection is called every HEARTBEAT_INTERVAL seconds: the leader sends its heartbeats, the other nodes run for leadership when the leader timed out.
+func TickElection() {
+	if ballot, fromTurnID, leading := leaderBallot(); leading {
+		sendHeartbeats(messages.Heartbeat{URL: config.CONF.SELF_URL, Ballot: ballot, FromTurnID: fromTurnID})
+		return
+	}
+
+	knownLeader.Lock()
+	alive := leaderAlive()
+	knownLeader.Unlock()
+	if alive {
+		return
+	}
+
+	// waiting a random amount before running, so that the nodes noticing the timeout together do not duel
+	r := rand.Float64() * float64(config.CONF.ELECTION.HEARTBEAT_INTERVAL*time.Second)
+	time.Sleep(time.Duration(r))
+
+	knownLeader.Lock()
+	alive = leaderAlive()
+	knownLeader.Unlock()
+	if alive {
+		return
+	}
+
+	log.Printf("[ELECTION] -> No heartbeat from a leader in the last %d seconds, running for leadership.", config.CONF.ELECTION.LEADER_TIMEOUT)
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	if acquireLeadership(session, queries.GetLastTurnID()+1) {
+		ballot, fromTurnID, _ := leaderBallot()
+		sendHeartbeats(messages.Heartbeat{URL: config.CONF.SELF_URL, Ballot: ballot, FromTurnID: fromTurnID})
+	}
+}
@@ -0,0 +1,111 @@
+package paxos
+
+import (
+	"errors"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withElection enables the leader election.
+func withElection(c *config.Conf) {
+	c.ELECTION.ENABLED = true
+}
+
+func TestTickElectionWithoutLeader(t *testing.T) {
+	defer startNode(t, withElection).stop()
+
+	TickElection()
+	if _, _, leading := leaderBallot(); !leading {
+		t.Fatal("no leader was known, yet the node did not become the leader")
+	}
+	waitFor(t, "the lease of the leader", holdsLease)
+
+	leader := GetLeader()
+	if !leader.IsSelf || !leader.Alive {
+		t.Fatalf("leader known by the node: %+v, want the node itself", leader)
+	}
+	if leaderURL, isRemote := LeaderURL(); isRemote {
+		t.Fatalf("submits forwarded to %s, want them served by the node itself", leaderURL)
+	}
+}
+
+func TestHeartbeatOfHigherLeader(t *testing.T) {
+	defer startNode(t, withElection).stop()
+
+	SendRangePrepare(1, 2)
+	if _, _, leading := leaderBallot(); !leading {
+		t.Fatal("the node did not become the leader")
+	}
+
+	other := messages.Heartbeat{URL: "http://other", Ballot: proposal.Proposal{Pid: 2, Seq: 5}, FromTurnID: 1}
+	ReceiveHeartbeat(other)
+	if _, _, leading := leaderBallot(); leading {
+		t.Fatal("the node is still leading after the heartbeat of a higher leader")
+	}
+	if leaderURL, isRemote := LeaderURL(); !isRemote || leaderURL != other.URL {
+		t.Fatalf("submits forwarded to '%s' (remote = %v), want %s", leaderURL, isRemote, other.URL)
+	}
+	if _, refused := refusesProposer(1); !refused {
+		t.Fatal("the requests of another proposer are accepted while the leader is alive")
+	}
+	if _, refused := refusesProposer(2); refused {
+		t.Fatal("the requests of the leader are refused")
+	}
+
+	// a lower leader is answered with the known one, so that it steps down
+	lower := messages.Heartbeat{URL: "http://lower", Ballot: proposal.Proposal{Pid: 3, Seq: 1}, FromTurnID: 1}
+	if response := ReceiveHeartbeat(lower); !response.Ballot.IsEqualTo(&other.Ballot) {
+		t.Fatalf("heartbeat of a lower leader answered with %+v, want the known leader's ballot %+v", response.Ballot, other.Ballot)
+	}
+}
+
+func TestRefusesNothingWithoutElection(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	ReceiveHeartbeat(messages.Heartbeat{URL: "http://other", Ballot: proposal.Proposal{Pid: 2, Seq: 5}, FromTurnID: 1})
+	if _, refused := refusesProposer(1); refused {
+		t.Fatal("a proposer has been refused while the election is disabled")
+	}
+}
+
+func TestSubmitToLeader(t *testing.T) {
+	defer startNode(t, withElection).stop()
+	deadline := time.Now().Add(5 * time.Second)
+
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("forwarded") != "true" {
+			http.Error(w, "not forwarded", 400)
+			return
+		}
+		_, _ = w.Write([]byte(ToJson(messages.SubmitResponse{TurnID: 7, V: r.URL.Query().Get("v")})))
+	}))
+	defer leader.Close()
+	submitResponse, err := SubmitToLeader(leader.URL, "a b", deadline)
+	if err != nil || submitResponse.TurnID != 7 || submitResponse.V != "a b" {
+		t.Fatalf("submit forwarded to the leader: %+v, err = %v, want 'a b' at turn id 7", submitResponse, err)
+	}
+
+	// the leader cannot be reached: the node submits the value itself
+	submitResponse, err = SubmitToLeader(deadPeer(), "b", deadline)
+	if err != nil || submitResponse.TurnID != 1 {
+		t.Fatalf("submit with an unreachable leader: %+v, err = %v, want it served by the node at turn id 1", submitResponse, err)
+	}
+
+	// the connection breaks once the submit is sent: the leader might have appended the value
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer broken.Close()
+	_, err = SubmitToLeader(broken.URL, "c", deadline)
+	if !errors.Is(err, ErrSubmitOutcomeUnknown) {
+		t.Fatalf("submit with a broken connection: err = %v, want ErrSubmitOutcomeUnknown", err)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+
+# Gossip:
+With the "flood" dissemination a learner learning a new value sends it to every other learner, a single learn costs O(N²) requests.
+With the "gossip" dissemination the value spreads like an epidemic instead:
+
+	(a) A learner forwards a value only the first time it learns it, to GOSSIP_FANOUT learners picked at random;
+	---AND---
+	(b) Every copy carries the digest of its value, a learner drops the copies of a (turn id, digest) pair it has seen already without writing to the storage.
+		The digest is checked against the value first: a copy whose value does not match its digest is never taken for one seen already.
+
+A learn costs at most N * GOSSIP_FANOUT gossip requests, and reaches every learner within O(log N) hops with high probability.
+The learners no copy reaches still get the learn requests of the proposer (see SendLearn and 'delivery.go'), or find the value through the seeker.
+
+*/
+
+package paxos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// gossipMemory bounds the number of turn ids, below the highest one seen, the digests seen are remembered for.
+const gossipMemory = 1024
+
+// gossipKey identifies a copy of a gossiped value: the same value for the same turn id always has the same key.
+type gossipKey struct {
+	turnID int
+	digest string
+}
+
+// gossip holds the (turn id, digest) pairs this node has learnt, so that the copies arriving later are dropped cheaply.
+var gossip struct {
+	sync.Mutex
+	seen          map[gossipKey]bool
+	highestTurnID int
+}
+
+// digestOf returns the hex encoded SHA-256 of @v.
+func digestOf(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+// gossipSeen tells whether the value of @key has already been learnt by this node through gossip.
+func gossipSeen(key gossipKey) bool {
+	gossip.Lock()
+	defer gossip.Unlock()
+	return gossip.seen[key]
+}
+
+// markGossipSeen records that the value of @key has been learnt by this node.
+// The pairs of the turn ids more than gossipMemory below the highest one are forgotten, their copies are checked against the storage again.
+func markGossipSeen(key gossipKey) {
+	gossip.Lock()
+	defer gossip.Unlock()
+
+	if gossip.seen == nil {
+		gossip.seen = make(map[gossipKey]bool)
+	}
+	gossip.seen[key] = true
+	if key.turnID > gossip.highestTurnID {
+		gossip.highestTurnID = key.turnID
+	}
+
+	if len(gossip.seen) > 2*gossipMemory {
+		for seenKey := range gossip.seen {
+			if seenKey.turnID < gossip.highestTurnID-gossipMemory {
+				delete(gossip.seen, seenKey)
+			}
+		}
+	}
+}
+
+// disseminateLearntValue spreads the value @v this node has just learnt for @turnID to the other learners, according to DISSEMINATION.
+func disseminateLearntValue(turnID int, v string) {
+	if config.CONF.DISSEMINATION == "gossip" {
+		gossipLearntValue(turnID, v)
+	} else {
+		floodLearntValue(turnID, v)
+	}
+}
+
+// gossipLearntValue sends the value @v learnt for @turnID to GOSSIP_FANOUT learners picked at random, this node excluded.
+// The learners which do not acknowledge it are sent a learn request again later, see 'delivery.go'.
+func gossipLearntValue(turnID int, v string) {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	gossipMessage := messages.GossipMessage{TurnID: turnID, Digest: digestOf(v), V: v}
+	markGossipSeen(gossipKey{turnID: turnID, digest: gossipMessage.Digest})
+
+	peers := config.Members().LearnerPeers()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > config.CONF.GOSSIP_FANOUT {
+		peers = peers[:config.CONF.GOSSIP_FANOUT]
+	}
+	log.Printf("[LEARNER] -> Gossiping '%s' for turn id %d to %d learner(s).", v, turnID, len(peers))
+
+	trackLearns(peers, turnID, v)
+	ch := make(chan nodeResponse, len(peers))
+	for _, node := range peers {
+		go sendTaggedRequest(session, node, "/learner/receive_gossip", ch, gossipMessage)
+	}
+	go collectLearnAcks(ch, turnID)
+}
+
+// ReceiveGossip implements the learner's behaviour when receiving a gossiped value, see dissemination.
+// A copy whose value does not match its digest is refused, a copy of a value this node has learnt already is dropped and answered with the value learnt by this node;
+// otherwise the value is handled like a learn request, and forwarded if it's new to this node.
+func ReceiveGossip(gossipMessage messages.GossipMessage) messages.GenericMessage {
+	turnID := gossipMessage.TurnID
+	key := gossipKey{turnID: turnID, digest: gossipMessage.Digest}
+
+	gossipResponse := messages.GenericMessage{
+		TurnID: turnID,
+		Type:   "gossip_response",
+		Body: messages.Body{
+			Message:  "",
+			Proposal: proposal.Proposal{},
+			Learnt:   "",
+		},
+	}
+
+	if digestOf(gossipMessage.V) != gossipMessage.Digest {
+		log.Printf("[LEARNER] -> Dropping gossip for turn id %d, the digest does not match the value.", turnID)
+		gossipResponse.Body.Message = "digest mismatch"
+		return gossipResponse
+	}
+	if gossipSeen(key) {
+		// the value learnt by this node is the one acknowledged, not the copy of the sender
+		if learntV := queries.GetLearntValue(turnID); learntV != "" {
+			gossipResponse.Body.Message = "duplicate"
+			gossipResponse.Body.Learnt = learntV
+			return gossipResponse
+		}
+	}
+
+	learnResponse := ReceiveLearn(messages.GenericMessage{
+		TurnID: turnID,
+		Type:   "learn_gossip",
+		Body: messages.Body{
+			Message:  "",
+			Proposal: proposal.Proposal{V: gossipMessage.V},
+			Learnt:   "",
+		},
+	})
+	if learnResponse.Body.Learnt == gossipMessage.V {
+		markGossipSeen(key)
+	}
+	gossipResponse.Body = learnResponse.Body
+	return gossipResponse
+}
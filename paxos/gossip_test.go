@@ -0,0 +1,77 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"testing"
+)
+
+func TestGossipDigestIsCheckedFirst(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) { c.DISSEMINATION = "gossip" }).stop()
+
+	markGossipSeen(gossipKey{turnID: 1, digest: digestOf("a")})
+
+	// a copy claiming the digest of a value seen already is not a duplicate of it
+	response := ReceiveGossip(messages.GossipMessage{TurnID: 1, Digest: digestOf("a"), V: "forged"})
+	if response.Body.Message != "digest mismatch" || response.Body.Learnt != "" {
+		t.Fatalf("response to a copy not matching its digest: %+v, want it refused", response.Body)
+	}
+
+	// the pair has been seen but nothing is learnt, e.g. the storage has been reset: the copy is learnt
+	response = ReceiveGossip(messages.GossipMessage{TurnID: 1, Digest: digestOf("a"), V: "a"})
+	if response.Body.Learnt != "a" || queries.GetLearntValue(1) != "a" {
+		t.Fatalf("response to a copy of a value not learnt: %+v, want 'a' learnt", response.Body)
+	}
+}
+
+func TestGossipDuplicateAnswersLearntValue(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) { c.DISSEMINATION = "gossip" }).stop()
+
+	gossipCopy := messages.GossipMessage{TurnID: 1, Digest: digestOf("a"), V: "a"}
+	if response := ReceiveGossip(gossipCopy); response.Body.Learnt != "a" {
+		t.Fatalf("response to the first copy: %+v, want 'a' learnt", response.Body)
+	}
+	response := ReceiveGossip(gossipCopy)
+	if response.Body.Message != "duplicate" || response.Body.Learnt != "a" {
+		t.Fatalf("response to the second copy: %+v, want a duplicate of the learnt 'a'", response.Body)
+	}
+}
+
+func TestGossipIsForwardedToFanout(t *testing.T) {
+	var peers []*learnerPeer
+	for i := 0; i < 4; i++ {
+		peer := startLearnerPeer(nil)
+		defer peer.Close()
+		peers = append(peers, peer)
+	}
+	defer startNode(t, func(c *config.Conf) {
+		c.DISSEMINATION = "gossip"
+		c.GOSSIP_FANOUT = 2
+		for i, peer := range peers {
+			c.NODE_ENTRIES = append(c.NODE_ENTRIES, config.Node{ID: i + 2, URL: peer.URL, ROLES: []string{"learner"}})
+		}
+	}).stop()
+
+	ReceiveGossip(messages.GossipMessage{TurnID: 1, Digest: digestOf("a"), V: "a"})
+	learners := func() int {
+		learnt := 0
+		for _, peer := range peers {
+			if peer.Learnt(1) == "a" {
+				learnt++
+			}
+		}
+		return learnt
+	}
+	waitFor(t, "the value to be forwarded", func() bool { return learners() == 2 })
+
+	// a copy of a value learnt already is not forwarded again
+	ReceiveGossip(messages.GossipMessage{TurnID: 1, Digest: digestOf("a"), V: "a"})
+	requests := 0
+	for _, peer := range peers {
+		requests += peer.Requests()
+	}
+	if requests != 2 || learners() != 2 {
+		t.Fatalf("gossip requests sent: %d to %d learner(s), want one to each of the 2 learners of the fanout", requests, learners())
+	}
+}
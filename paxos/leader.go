@@ -0,0 +1,293 @@
+/*
+
+# Leader mode (Multi-Paxos):
+A proposer submitting turn after turn does not need a prepare request for each of them.
+It sends a single range prepare request numbered n for every turn id >= k and, once a majority of acceptors promised it,
+it becomes the leader and sends accept requests only:
+
+	(a) For the turn ids >= k for which some acceptor reported an accepted proposal, the value of the highest-numbered one is proposed again;
+	---AND---
+	(b) For any other turn id >= k the leader proposes its own values.
+
+The leader steps down as soon as one of its accept requests does not reach a quorum (e.g. an acceptor declined it because it promised a higher number),
+the next submit goes through a new range prepare request with a higher number.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// leadership holds the range promise this node has obtained from a majority of acceptors, if any.
+var leadership struct {
+	sync.Mutex
+	active     bool
+	fromTurnID int                       // fromTurnID is the first turn id covered by the range promise.
+	epoch      int                       // epoch identifies the membership of the acceptors which promised, the leader only skips the prepare phase for the turn ids of that membership.
+	seq        int                       // seq is the sequence number of the leader, or the highest one seen while trying to become the leader.
+	pending    map[int]proposal.Proposal // pending holds the highest accepted proposals reported by the acceptors, they have to be proposed again.
+}
+
+// isLeaderFor tells whether this node is the leader for @turnID, i.e. whether it can skip the prepare phase for @turnID.
+// The acceptors of a later membership have not promised anything, a turn id in effect with another membership goes through a new range prepare.
+func isLeaderFor(turnID int) bool {
+	epochID := epochForTurn(turnID).changedAt
+
+	leadership.Lock()
+	defer leadership.Unlock()
+	return leadership.active && turnID >= leadership.fromTurnID && epochID == leadership.epoch
+}
+
+// stepDown drops the leadership, @retrySeq is the sequence number the next range prepare request has to use at least.
+func stepDown(turnID int, retrySeq int) {
+	leadership.Lock()
+	defer leadership.Unlock()
+
+	if leadership.active {
+		log.Printf("[LEADER] -> Accept request for turn id %d has been refused, stepping down.", turnID)
+	}
+	leadership.active = false
+	leadership.pending = nil
+	if retrySeq-1 > leadership.seq {
+		leadership.seq = retrySeq - 1
+	}
+}
+
+// leaderBallot returns the number (pid, seq) of the leader and the first turn id it leads, the boolean is false when this node is not the leader.
+func leaderBallot() (ballot proposal.Proposal, fromTurnID int, leading bool) {
+	leadership.Lock()
+	defer leadership.Unlock()
+	return proposal.Proposal{Pid: config.CONF.PID, Seq: leadership.seq}, leadership.fromTurnID, leadership.active
+}
+
+// yieldTo drops the leadership when @ballot, the number of another leader, is higher than ours.
+func yieldTo(ballot proposal.Proposal) {
+	leadership.Lock()
+	defer leadership.Unlock()
+
+	ours := proposal.Proposal{Pid: config.CONF.PID, Seq: leadership.seq}
+	if !ballot.IsGreaterThan(&ours) {
+		return
+	}
+	if leadership.active {
+		log.Printf("[LEADER] -> %s is leading with a higher number (seq: %d), stepping down.", config.Members().PIDName(ballot.Pid), ballot.Seq)
+	}
+	leadership.active = false
+	leadership.pending = nil
+	leadership.seq = ballot.Seq
+}
+
+// broadcastRangePrepare sends the range prepare request (@fromTurnID, @seq) to each node in @NODES, the responses are collected in the returned channel.
+func broadcastRangePrepare(session *http.Client, NODES []string, fromTurnID int, seq int) chan nodeResponse {
+	ch := make(chan nodeResponse, len(NODES))
+	epochID := epochForTurn(fromTurnID).changedAt
+
+	for _, node := range NODES {
+		rangePrepareRequest := messages.RangePrepareRequest{
+			FromTurnID: fromTurnID,
+			Proposal: proposal.Proposal{
+				Pid: config.CONF.PID,
+				Seq: seq,
+			},
+			Epoch: epochID,
+		}
+		go sendTaggedRequest(session, node, "/acceptor/receive_range_prepare", ch, rangePrepareRequest)
+	}
+	return ch
+}
+
+// rangePrepare tries to become the leader for every turn id >= @fromTurnID with sequence number @seq.
+// The values the acceptors report as learnt are learnt on the way, whatever the outcome.
+func rangePrepare(session *http.Client, fromTurnID int, seq int) (acquired bool, err error) {
+	log.Printf("[LEADER] -> Starting range prepare request; turn ids >= %d, seq: %d.", fromTurnID, seq)
+	e := epochForTurn(fromTurnID)
+	ch := broadcastRangePrepare(session, e.quorums.Acceptors, fromTurnID, seq)
+
+	var promisers []string
+	highestRetry := proposal.Proposal{}
+	pending := make(map[int]proposal.Proposal)
+	learnt := make(map[int]string)
+
+	for i := 0; i < cap(ch); i++ {
+		response := <-ch
+
+		// @response.data is nil when a node did not respond in time
+		if response.data == nil {
+			continue
+		}
+		responseMessage := messages.RangePrepareResponse{}
+		err := json.Unmarshal(response.data, &responseMessage)
+		if err != nil {
+			log.Print(err.Error())
+			return false, err
+		}
+
+		for turnID, v := range responseMessage.Learnt {
+			learnt[turnID] = v
+		}
+
+		if responseMessage.Message == "promise" {
+			promisers = append(promisers, response.node)
+			for _, p := range responseMessage.Accepted {
+				if highest, ok := pending[p.TurnID]; !ok || p.Proposal.IsGreaterThan(&highest) {
+					pending[p.TurnID] = p.Proposal
+				}
+			}
+		} else if responseMessage.Message == "retry" && responseMessage.Promised.IsGreaterThan(&highestRetry) {
+			highestRetry = responseMessage.Promised
+		}
+	}
+
+	for turnID, v := range learnt {
+		delete(pending, turnID)
+		if config.CONF.LEARN_FROM_ACCEPTED {
+			// the reported values are learnt from the accepted messages only, see 'accepted.go'
+			continue
+		}
+		if queries.GetLearntValue(turnID) == "" && learnValue(turnID, v, "leader") == nil {
+			go SendLearn(turnID, v)
+		}
+	}
+
+	leadership.Lock()
+	defer leadership.Unlock()
+
+	if highestRetry.Seq > leadership.seq {
+		leadership.seq = highestRetry.Seq
+	}
+	if !e.quorums.IsPhase1Quorum(promisers) {
+		log.Printf("[LEADER] -> Quorum has NOT been reached (%d/%d) for range prepare request with turn ids >= %d, seq: %d.", len(promisers), len(e.quorums.Acceptors), fromTurnID, seq)
+		return false, nil
+	}
+	if seq > leadership.seq {
+		leadership.seq = seq
+	}
+
+	leadership.active = true
+	leadership.fromTurnID = fromTurnID
+	leadership.epoch = e.changedAt
+	leadership.pending = pending
+	log.Printf("[LEADER] -> Quorum has been reached (%d/%d) for range prepare request with turn ids >= %d, seq: %d. This node is the leader, %d accepted proposal(s) to finish.", len(promisers), len(e.quorums.Acceptors), fromTurnID, seq, len(pending))
+
+	go finishPending()
+	return true, nil
+}
+
+// acquireLeadership tries to become the leader for every turn id >= @fromTurnID, using a sequence number higher than any seen so far.
+func acquireLeadership(session *http.Client, fromTurnID int) bool {
+	leadership.Lock()
+	seq := leadership.seq + 1
+	leadership.Unlock()
+
+	seq, err := issueSeq(seq)
+	if err != nil {
+		log.Printf("[LEADER] -> Could not store the sequence number of the range prepare request: %v", err)
+		return false
+	}
+
+	acquired, err := rangePrepare(session, fromTurnID, seq)
+	if err != nil {
+		log.Printf("[LEADER] -> Unexpected behavior in range prepare request: %v", err)
+	}
+	return acquired
+}
+
+// leaderRound runs an accept-only round for @turnID with the number of the leader, proposing @v unless an accepted proposal has to be finished first.
+// It returns the value chosen for @turnID, or an empty string together with the sequence number to retry with if the round failed; in that case the leadership is dropped.
+func leaderRound(session *http.Client, turnID int, v string) (chosenV string, retrySeq int, err error) {
+	if learntV := queries.GetLearntValue(turnID); learntV != "" {
+		return learntV, 0, nil
+	}
+
+	leadership.Lock()
+	seq := leadership.seq
+	proposedV := v
+	if p, isPending := leadership.pending[turnID]; isPending {
+		proposedV = p.V
+	}
+	leadership.Unlock()
+
+	// nothing to propose, e.g. the pending proposal has been finished by a client submit already
+	if proposedV == "" {
+		return "", 0, nil
+	}
+
+	chosenV, retrySeq, err = acceptRound(session, turnID, seq, proposedV)
+	if err != nil {
+		return "", 0, err
+	}
+	if chosenV == "" {
+		stepDown(turnID, retrySeq)
+		return "", retrySeq, nil
+	}
+
+	leadership.Lock()
+	delete(leadership.pending, turnID)
+	leadership.Unlock()
+	return chosenV, 0, nil
+}
+
+// finishPending proposes again the accepted proposals reported to the leader, lowest turn id first.
+func finishPending() {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+
+	leadership.Lock()
+	var turnIDs []int
+	for turnID := range leadership.pending {
+		turnIDs = append(turnIDs, turnID)
+	}
+	leadership.Unlock()
+	sort.Ints(turnIDs)
+
+	for _, turnID := range turnIDs {
+		if !isLeaderFor(turnID) {
+			return
+		}
+		chosenV, _, err := leaderRound(session, turnID, "")
+		if err != nil {
+			log.Printf("[LEADER] -> Could not finish the accepted proposal of turn id %d: %v", turnID, err)
+		} else if chosenV != "" {
+			go SendLearn(turnID, chosenV)
+		}
+	}
+}
+
+// SendRangePrepare sends a range prepare request for every turn id >= @fromTurnID to all the acceptors in the network.
+// When a majority of them promises, this node becomes the leader and client submits skip the prepare phase (see Submit).
+func SendRangePrepare(fromTurnID int, seq int) (messageToUser string) {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+
+	err := useSeq(seq)
+	if err == ErrSeqUsed {
+		return fmt.Sprintf("Sequence number %d is not higher than %d, the highest one used by this node for any turn id. Please retry with a higher range prepare request as follows:"+
+			" /proposer/send_range_prepare?turn_id=%d&seq=%d", seq, HighestSeq(), fromTurnID, freshSeq(seq))
+	} else if err != nil {
+		return fmt.Sprintf("Could not store the sequence number: %v", err)
+	}
+
+	acquired, err := rangePrepare(session, fromTurnID, seq)
+	if err != nil {
+		return fmt.Sprintf("Errors while unmarshalling responses: %v", err)
+	}
+	if !acquired {
+		leadership.Lock()
+		retrySeq := leadership.seq + 1
+		leadership.Unlock()
+		retrySeq = freshSeq(retrySeq)
+		return fmt.Sprintf("Quorum has NOT been reached for range prepare request with turn ids >= %d. Please retry with a higher range prepare request as follows:"+
+			" /proposer/send_range_prepare?turn_id=%d&seq=%d", fromTurnID, fromTurnID, retrySeq)
+	}
+	return fmt.Sprintf("Quorum has been reached for range prepare request with turn ids >= %d, seq: %d. This node is the leader.", fromTurnID, seq)
+}
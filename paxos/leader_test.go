@@ -0,0 +1,64 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"testing"
+)
+
+func TestLeaderSkipsPrepare(t *testing.T) {
+	node := startNode(t, func(c *config.Conf) { c.LEADER_MODE = true })
+	defer node.stop()
+
+	for i, v := range []string{"a", "b", "c"} {
+		if submitResponse := submitWithin(t, v, 5); submitResponse.TurnID != i+1 {
+			t.Fatalf("submit of '%s' got turn id %d, want %d", v, submitResponse.TurnID, i+1)
+		}
+	}
+	if hits := node.Hits("/acceptor/receive_range_prepare"); hits != 1 {
+		t.Fatalf("%d range prepare requests sent, want a single one", hits)
+	}
+	if hits := node.Hits("/acceptor/receive_prepare"); hits != 0 {
+		t.Fatalf("%d prepare requests sent by the leader, want none", hits)
+	}
+}
+
+func TestLeaderFinishesAcceptedProposals(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	// a previous proposer got 'other' accepted for turn id 3, but not learnt
+	_, _, accepted, err := queries.AcceptIfNotLower(3, proposal.Proposal{Pid: 2, Seq: 1, V: "other"})
+	if err != nil || !accepted {
+		t.Fatalf("accept request: accepted = %v, err = %v", accepted, err)
+	}
+
+	SendRangePrepare(1, 2)
+	if !isLeaderFor(1) {
+		t.Fatal("a range prepare request promised by every acceptor did not make the node the leader")
+	}
+	waitFor(t, "the leader to finish turn id 3", func() bool { return queries.GetLearntValue(3) == "other" })
+}
+
+func TestLeaderStepsDownWhenPreempted(t *testing.T) {
+	node := startNode(t, func(c *config.Conf) { c.LEADER_MODE = true })
+	defer node.stop()
+
+	submitWithin(t, "a", 5)
+
+	// another node becomes the leader with a higher number
+	_, promised, err := queries.PromiseRange(2, proposal.Proposal{Pid: 2, Seq: 9})
+	if err != nil || !promised {
+		t.Fatalf("range prepare request: promised = %v, err = %v", promised, err)
+	}
+
+	if submitResponse := submitWithin(t, "b", 5); submitResponse.TurnID != 2 {
+		t.Fatalf("submit of 'b' got turn id %d, want 2", submitResponse.TurnID)
+	}
+	if ballot, _, leading := leaderBallot(); !leading || ballot.Seq <= 9 {
+		t.Fatalf("leadership after the preemption: %+v (leading = %v), want it acquired again with a seq higher than 9", ballot, leading)
+	}
+	if hits := node.Hits("/acceptor/receive_range_prepare"); hits != 2 {
+		t.Fatalf("%d range prepare requests sent, want one before and one after the preemption", hits)
+	}
+}
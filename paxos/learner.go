@@ -1,12 +1,62 @@
 package paxos
 
 import (
+	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"go-paxos/paxos/queries"
 	"log"
+	"sync"
+	"time"
 )
 
+// LearntConflict records a refused attempt to learn a value different from the one already learnt.
+type LearntConflict struct {
+	queries.LearntConflictError
+	Source string    `json:"source"` // Source tells who tried to learn the refused value, e.g. "learn request" or "seeker".
+	Time   time.Time `json:"time"`
+}
+
+// conflicts holds the conflicts detected since the node started, they are kept in memory only.
+var conflicts struct {
+	sync.Mutex
+	list []LearntConflict
+}
+
+// learnValue stores @v as the learnt value for @turnID, learnt values are written once (see queries.SetLearntValue).
+// Trying to learn a value different from the one already learnt returns a *queries.LearntConflictError,
+// the conflict is also logged and recorded together with @source so that it can be inspected through GetConflicts.
+func learnValue(turnID int, v string, source string) error {
+	err := queries.SetLearntValue(turnID, v)
+
+	conflictErr, isConflict := err.(*queries.LearntConflictError)
+	if isConflict {
+		log.Printf("[LEARNER] -> !!WARNING!! %s: %s, some node (or user) is not following the algorithm.", source, conflictErr.Error())
+
+		conflicts.Lock()
+		conflicts.list = append(conflicts.list, LearntConflict{LearntConflictError: *conflictErr, Source: source, Time: time.Now()})
+		conflicts.Unlock()
+	}
+	if err == nil || isConflict {
+		// a value is learnt for the turn id either way, the accepted messages counted for it are not needed anymore
+		forgetTally(turnID)
+	}
+	if err == nil {
+		applyMembership()
+	}
+	return err
+}
+
+// GetConflicts returns the conflicts recorded by learnValue since the node started, oldest first.
+func GetConflicts() []LearntConflict {
+	conflicts.Lock()
+	defer conflicts.Unlock()
+
+	list := make([]LearntConflict, len(conflicts.list))
+	copy(list, conflicts.list)
+	return list
+}
+
 // GetLearntValue returns a message with the 'learnt' field containing the value (@v) of the proposal with turn ID = @turnID.
 // If the requested turn ID does not exist, the 'learnt' field will contain an empty string.
 func GetLearntValue(turnID int) messages.GenericMessage {
@@ -28,7 +78,8 @@ func GetLearntValue(turnID int) messages.GenericMessage {
 // ReceiveLearn implements the learner's behaviour when receiving a learn request.
 // If the proposed value has not been learnt yet it gets learnt immediately and learn requests with that value are sent to each known node.
 // If the proposed value has already been learnt then no action is performed.
-// If we get a proposal to learn a value which is different from the value we already have for that turn id
+// If we get a proposal to learn a value which is different from the value we already have for that turn id, the request is refused and the conflict is recorded.
+// When learn_from_accepted is enabled the proposed value is never learnt from the request itself, see ReceiveAccepted.
 func ReceiveLearn(learnRequest messages.GenericMessage) messages.GenericMessage {
 
 	turnID := learnRequest.TurnID
@@ -47,33 +98,62 @@ func ReceiveLearn(learnRequest messages.GenericMessage) messages.GenericMessage
 		},
 	}
 
-	if proposedV != currentV && currentV != "" {
+	if config.CONF.LEARN_FROM_ACCEPTED {
+		// learn requests are not trusted, only the accepted messages of the acceptors are (see ReceiveAccepted)
+		chosenV := awaitChosen(turnID, time.Second*config.CONF.TIMEOUT/2)
+		if chosenV == "" {
+			log.Printf("[LEARNER] -> Refusing learn request, '%s' has not been reported as accepted by a quorum of acceptors for turn id %d.", proposedV, turnID)
+			learnResponse.Body.Message = "not chosen yet"
+		} else if chosenV != proposedV {
+			log.Print("[LEARNER] -> Refusing learn request. I already have a learnt value for this turn id, please respect the algorithm.")
+			learnResponse.Body.Message = "Trying to learn a different value, please respect the algorithm."
+			learnResponse.Body.Learnt = chosenV
+		} else {
+			learnResponse.Body.Message = "already learnt"
+			learnResponse.Body.Learnt = chosenV
+		}
+		return learnResponse
+	}
+
+	err := learnValue(turnID, proposedV, "learn request")
+
+	if _, isConflict := err.(*queries.LearntConflictError); isConflict {
 		log.Print("[LEARNER] -> Refusing learn request. I already have a learnt value for this turn id, please respect the algorithm.")
 		learnResponse.Body.Message = "Trying to learn a different value, please respect the algorithm."
+		learnResponse.Body.Learnt = currentV
+	} else if err != nil {
+		log.Print("[LEARNER] -> Refusing learn request, could not store the new proposal. Here's the error: ", err.Error())
+		learnResponse.Body.Message = "Fail: " + err.Error()
+	} else if currentV == proposedV {
+		log.Printf("[LEARNER] -> Value '%s' has already been learnt for turn id %d. Don't need to learn that again.", proposedV, turnID)
+		learnResponse.Body.Message = "already learnt"
+		learnResponse.Body.Learnt = currentV
 	} else {
+		// currentV was empty, spread the value to the other learners (see dissemination).
+		// PropagateLearnedValue(turn_id, v)
+		log.Printf("[LEARNER] -> Learning and propagating '%s' for turn_id: %d.", proposedV, turnID)
+		learnResponse.Body.Message = "value stored"
+		learnResponse.Body.Learnt = proposedV
 
-		err := queries.SetLearntValue(turnID, proposedV)
-
-		if err != nil {
-			log.Print("[LEARNER] -> Refusing learn request, could not store the new proposal. Here's the error: ", err.Error())
-			learnResponse.Body.Message = "Fail: " + err.Error()
-		} else {
-
-			if currentV == proposedV {
-				log.Printf("[LEARNER] -> Value '%s' has already been learnt for turn id %d. Don't need to learn that again.", proposedV, turnID)
-			} else {
-				// currentV was empty, flood the other nodes with requests.
-				// PropagateLearnedValue(turn_id, v)
-				log.Printf("[LEARNER] -> Learning and propagating '%s' for turn_id: %d.", proposedV, turnID)
-				learnResponse.Body.Message = "value stored"
-				learnResponse.Body.Learnt = proposedV
+		go disseminateLearntValue(turnID, proposedV)
+	}
 
-				go floodLearntValue(turnID, proposedV)
+	return learnResponse
+}
 
-			}
-		}
+// ReceiveRead implements the learner's behaviour when receiving a read request, see Read.
+// The value learnt for the requested turn id is returned together with the last learnt turn id; when the requested turn id is 0 the last learnt turn id is read.
+func ReceiveRead(readRequest messages.ReadRequest) messages.ReadResponse {
+	lastTurnID := queries.GetLastTurnID()
 
+	turnID := readRequest.TurnID
+	if turnID == 0 {
+		turnID = lastTurnID
 	}
 
-	return learnResponse
+	return messages.ReadResponse{
+		TurnID:     turnID,
+		V:          queries.GetLearntValue(turnID),
+		LastTurnID: lastTurnID,
+	}
 }
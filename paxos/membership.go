@@ -0,0 +1,355 @@
+/*
+
+# Membership changes:
+The nodes of the cluster are the ones of the 'nodes' section of the '.yaml' file (the same on every node) until a membership change is learnt.
+A change, adding or removing a node, is agreed on through the log itself: it's proposed as the value of a turn id,
+encoded as the config prefix followed by the JSON of the change. A change learnt for turn id c takes effect at turn id c + MEMBERSHIP_DELAY:
+
+	(a) Every node reads the changes in turn id order, once it has learnt every turn id before them.
+		A change which is not valid anymore (e.g. a node added twice by concurrent changes) is ignored by every node alike;
+	---AND---
+	(b) Once a node has learnt every turn id before c + MEMBERSHIP_DELAY, it switches to the new NODES and quorum system, no restart needed.
+
+A turn id is always proposed to the acceptors, and counted against the quorums, of the membership in effect for it rather than the one in use (see quorumsForTurn).
+A proposer only knows that membership once it has learnt every turn id up to MEMBERSHIP_DELAY turn ids before it, it does not propose further (see membershipKnownFor).
+The requests carry the membership they have been sent with (see messages.Body.Epoch), an acceptor refuses the ones sent with a membership it knows to be outdated.
+The delay should exceed the turn ids in flight (see PIPELINE_WINDOW), so that the turn ids proposed before a change is learnt keep the membership they started with.
+A node joining the cluster starts with the nodes of the '.yaml' file as well, it catches up with the changes through the seeker.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configPrefix starts the value of every turn id holding a membership change.
+const configPrefix = "config:"
+
+// ErrInvalidMembershipChange is returned by ProposeMembershipChange when the change cannot be applied to the latest membership.
+var ErrInvalidMembershipChange = errors.New("invalid membership change")
+
+// epoch is a membership of the cluster, in effect from fromTurnID on.
+type epoch struct {
+	fromTurnID int
+	changedAt  int                        // changedAt is the turn id the change has been learnt for, 0 for the membership of the '.yaml' file. It identifies the epoch in the requests.
+	change     *messages.MembershipChange // change is the change leading to the membership, nil for the membership of the '.yaml' file.
+	nodes      []config.Node
+	conf       *config.Conf    // conf is config.CONF with the nodes of the epoch, see config.Members.
+	quorums    *quorum.Quorums // quorums are the quorums formed by the nodes of the epoch.
+}
+
+// newEpoch builds the epoch of @nodes, in effect from @fromTurnID on, out of @change learnt for @changedAt.
+func newEpoch(fromTurnID int, changedAt int, change *messages.MembershipChange, nodes []config.Node) (epoch, error) {
+	c := config.CONF.WithNodes(nodes)
+	q, err := quorum.New(&c)
+	if err != nil {
+		return epoch{}, err
+	}
+	return epoch{fromTurnID: fromTurnID, changedAt: changedAt, change: change, nodes: c.NODE_ENTRIES, conf: &c, quorums: q}, nil
+}
+
+// membership holds the memberships known so far, oldest first, and the one in use.
+var membership struct {
+	sync.Mutex
+	epochs      []epoch // epochs is nil until InitMembership is called.
+	active      int     // active is the index in epochs of the membership in use.
+	scannedUpTo int     // scannedUpTo is the last of the contiguous learnt turn ids read for changes.
+}
+
+// isMembershipChange tells whether @v is the encoding of a membership change.
+func isMembershipChange(v string) bool {
+	return strings.HasPrefix(v, configPrefix)
+}
+
+// encodeMembershipChange encodes @change into the value of a turn id.
+func encodeMembershipChange(change messages.MembershipChange) string {
+	encoded, _ := json.Marshal(change)
+	return configPrefix + string(encoded)
+}
+
+// decodeMembershipChange decodes the value @v learnt for a turn id into a membership change.
+func decodeMembershipChange(v string) (messages.MembershipChange, error) {
+	change := messages.MembershipChange{}
+	err := json.Unmarshal([]byte(strings.TrimPrefix(v, configPrefix)), &change)
+	return change, err
+}
+
+// applyChange returns the nodes resulting from @change applied to @nodes.
+// An error is returned when @change does not apply to @nodes, or when the resulting nodes would not form valid quorums.
+func applyChange(nodes []config.Node, change messages.MembershipChange) ([]config.Node, error) {
+	if change.URL == "" && change.Op == "remove" {
+		for _, node := range nodes {
+			if change.ID != 0 && node.ID == change.ID {
+				change.URL = node.URL
+			}
+		}
+	}
+	if change.URL == "" {
+		return nil, fmt.Errorf("%w: the url of the node is missing", ErrInvalidMembershipChange)
+	}
+
+	var changed []config.Node
+	switch change.Op {
+	case "add":
+		for _, node := range nodes {
+			if node.URL == change.URL {
+				return nil, fmt.Errorf("%w: %s is already a node of the cluster", ErrInvalidMembershipChange, change.URL)
+			}
+		}
+		changed = append(append(changed, nodes...), config.Node{ID: change.ID, URL: change.URL, ROLES: change.Roles, WEIGHT: change.Weight, ZONE: change.Zone})
+	case "remove":
+		for _, node := range nodes {
+			if node.URL != change.URL {
+				changed = append(changed, node)
+			}
+		}
+		if len(changed) == len(nodes) {
+			return nil, fmt.Errorf("%w: %s is not a node of the cluster", ErrInvalidMembershipChange, change.URL)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q, valid values are: add, remove", ErrInvalidMembershipChange, change.Op)
+	}
+
+	c := config.CONF.WithNodes(changed)
+	if err := c.CheckNodes(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMembershipChange, err)
+	}
+	if _, err := quorum.NewSystem(&c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMembershipChange, err)
+	}
+	return c.NODE_ENTRIES, nil
+}
+
+// activate switches to the nodes of @e, and to the quorum system they form. The caller must hold the lock.
+// The membership in use is replaced as a whole, the readers go through config.Members and quorum.Current.
+func activate(e epoch) {
+	config.SetMembers(e.conf)
+	quorum.Use(e.quorums)
+	log.Printf("[MEMBERSHIP] -> Switched to the nodes in effect from turn id %d: %v. Quorum policy: %s.", e.fromTurnID, e.conf.NODES, e.quorums)
+
+	for _, node := range e.conf.NODES {
+		if node == config.CONF.SELF_URL {
+			return
+		}
+	}
+	log.Printf("[MEMBERSHIP] -> This node (%s) is not part of the cluster anymore.", config.CONF.SELF_URL)
+}
+
+// InitMembership starts from the nodes of the '.yaml' file and applies the membership changes found in the log.
+func InitMembership() {
+	membership.Lock()
+	membership.epochs = []epoch{{fromTurnID: 1, nodes: config.CONF.NODE_ENTRIES, conf: &config.CONF, quorums: quorum.Current()}}
+	membership.active = 0
+	membership.scannedUpTo = 0
+	membership.Unlock()
+
+	applyMembership()
+}
+
+// applyMembership reads the membership changes of the turn ids learnt since the last call, as long as they are contiguous,
+// then switches to the latest membership in effect for the first turn id not learnt yet. It's called whenever a value is learnt.
+func applyMembership() {
+	membership.Lock()
+	defer membership.Unlock()
+
+	if membership.epochs == nil {
+		return
+	}
+
+	for {
+		turnID := membership.scannedUpTo + 1
+		v := queries.GetLearntValue(turnID)
+		if v == "" {
+			break
+		}
+		membership.scannedUpTo = turnID
+		_, v = untagSubmit(v)
+		if !isMembershipChange(v) {
+			continue
+		}
+
+		change, err := decodeMembershipChange(v)
+		if err != nil {
+			log.Printf("[MEMBERSHIP] -> Could not decode the membership change '%s' of turn id %d, ignoring it: %v", v, turnID, err)
+			continue
+		}
+		latest := membership.epochs[len(membership.epochs)-1]
+		nodes, err := applyChange(latest.nodes, change)
+		if err != nil {
+			log.Printf("[MEMBERSHIP] -> Ignoring the membership change of turn id %d: %v", turnID, err)
+			continue
+		}
+		changed, err := newEpoch(turnID+config.CONF.MEMBERSHIP_DELAY, turnID, &change, nodes)
+		if err != nil {
+			// applyChange checked the quorums already
+			log.Printf("[MEMBERSHIP] -> !!WARNING!! Could not build the quorums of the membership change of turn id %d, ignoring it: %v", turnID, err)
+			continue
+		}
+
+		membership.epochs = append(membership.epochs, changed)
+		log.Printf("[MEMBERSHIP] -> Learnt membership change of turn id %d (%s %s), in effect from turn id %d.", turnID, change.Op, config.Members().NodeName(change.URL), turnID+config.CONF.MEMBERSHIP_DELAY)
+	}
+
+	for membership.active+1 < len(membership.epochs) && membership.epochs[membership.active+1].fromTurnID <= membership.scannedUpTo+1 {
+		membership.active += 1
+		activate(membership.epochs[membership.active])
+	}
+}
+
+// toMessage converts the membership @e into a message, @active tells whether it's the membership in use.
+func (e epoch) toMessage(active bool) messages.MembershipEpoch {
+	epochMessage := messages.MembershipEpoch{
+		FromTurnID: e.fromTurnID,
+		ChangedAt:  e.changedAt,
+		Change:     e.change,
+		Nodes:      []messages.MembershipNode{},
+		Active:     active,
+	}
+	for _, node := range e.nodes {
+		epochMessage.Nodes = append(epochMessage.Nodes, messages.MembershipNode{ID: node.ID, URL: node.URL, Roles: node.ROLES, Weight: node.WEIGHT, Zone: node.ZONE})
+	}
+	return epochMessage
+}
+
+// GetMembershipHistory returns every membership known so far, oldest first.
+func GetMembershipHistory() []messages.MembershipEpoch {
+	membership.Lock()
+	defer membership.Unlock()
+
+	history := []messages.MembershipEpoch{}
+	for i, e := range membership.epochs {
+		history = append(history, e.toMessage(i == membership.active))
+	}
+	return history
+}
+
+// MembershipForTurn returns the membership in effect for @turnID, as far as this node knows:
+// the changes learnt after the turn ids this node is missing are not known yet.
+func MembershipForTurn(turnID int) messages.MembershipEpoch {
+	membership.Lock()
+	defer membership.Unlock()
+
+	found := indexForTurn(turnID)
+	return membership.epochs[found].toMessage(found == membership.active)
+}
+
+// indexForTurn returns the index in epochs of the membership in effect for @turnID, see MembershipForTurn. The caller must hold the lock.
+func indexForTurn(turnID int) int {
+	found := 0
+	for i, e := range membership.epochs {
+		if e.fromTurnID <= turnID {
+			found = i
+		}
+	}
+	return found
+}
+
+// epochForTurn returns the membership in effect for @turnID, see MembershipForTurn.
+// Before InitMembership is called, it's the membership of the '.yaml' file.
+func epochForTurn(turnID int) epoch {
+	membership.Lock()
+	defer membership.Unlock()
+
+	if membership.epochs == nil {
+		return epoch{fromTurnID: 1, nodes: config.CONF.NODE_ENTRIES, conf: &config.CONF, quorums: quorum.Current()}
+	}
+	return membership.epochs[indexForTurn(turnID)]
+}
+
+// quorumsForTurn returns the quorums @turnID is proposed with: the ones of the membership in effect for @turnID, see MembershipForTurn.
+func quorumsForTurn(turnID int) *quorum.Quorums {
+	return epochForTurn(turnID).quorums
+}
+
+// membershipKnownFor tells whether this node knows the membership in effect for @turnID for sure, i.e. whether it has learnt
+// every turn id a change in effect for @turnID could have been learnt for. A proposer does not propose @turnID until then.
+func membershipKnownFor(turnID int) bool {
+	membership.Lock()
+	defer membership.Unlock()
+
+	if membership.epochs == nil {
+		return true
+	}
+	return turnID <= membership.scannedUpTo+config.CONF.MEMBERSHIP_DELAY
+}
+
+// isStaleEpoch tells whether @epochID, the membership a request for @turnID has been sent with (see messages.Body.Epoch),
+// is older than the membership this node knows to be in effect for @turnID.
+func isStaleEpoch(turnID int, epochID int) bool {
+	return epochID < epochForTurn(turnID).changedAt
+}
+
+// NodesForTurn returns the urls of the nodes in effect for @turnID, see MembershipForTurn.
+func NodesForTurn(turnID int) []string {
+	var nodes []string
+	for _, node := range MembershipForTurn(turnID).Nodes {
+		nodes = append(nodes, node.URL)
+	}
+	return nodes
+}
+
+// ProposeMembershipChange gets @change chosen as the value of a turn id, it takes effect MEMBERSHIP_DELAY turn ids later.
+// The change is checked against the latest membership known by this node, ErrInvalidMembershipChange is returned when it does not apply.
+// The returned membership has no nodes when this node has not learnt every turn id before the change yet.
+func ProposeMembershipChange(change messages.MembershipChange, deadline time.Time) (messages.MembershipEpoch, error) {
+	membership.Lock()
+	latest := membership.epochs[len(membership.epochs)-1]
+	membership.Unlock()
+
+	_, err := applyChange(latest.nodes, change)
+	if err != nil {
+		return messages.MembershipEpoch{}, err
+	}
+	if change.URL == "" {
+		// a node removed by id: the log records its url as well
+		for _, node := range latest.nodes {
+			if node.ID == change.ID {
+				change.URL = node.URL
+			}
+		}
+	}
+
+	turnID, err := submitTurn(encodeMembershipChange(change), deadline)
+	if err != nil {
+		return messages.MembershipEpoch{}, err
+	}
+
+	membership.Lock()
+	defer membership.Unlock()
+	for i, e := range membership.epochs {
+		if e.changedAt == turnID {
+			return e.toMessage(i == membership.active), nil
+		}
+	}
+	if membership.scannedUpTo >= turnID {
+		// another change learnt for an earlier turn id made this one invalid
+		return messages.MembershipEpoch{}, fmt.Errorf("%w: the change has been learnt for turn id %d but a previous change made it void", ErrInvalidMembershipChange, turnID)
+	}
+	return messages.MembershipEpoch{FromTurnID: turnID + config.CONF.MEMBERSHIP_DELAY, ChangedAt: turnID, Change: &change, Nodes: []messages.MembershipNode{}}, nil
+}
+
+// learnersOf returns the nodes the learn requests for @turnID are sent to: the current learners,
+// and the learners in effect for @turnID which have been removed since, so that they learn the turn ids preceding their removal.
+func learnersOf(turnID int) []string {
+	var learnersThen []string
+	for _, node := range MembershipForTurn(turnID).Nodes {
+		if (config.Node{ROLES: node.Roles}).Plays("learner") {
+			learnersThen = append(learnersThen, node.URL)
+		}
+	}
+
+	learners := append([]string(nil), config.Members().LEARNERS...)
+	return append(learners, quorum.Without(learnersThen, learners)...)
+}
@@ -0,0 +1,87 @@
+package paxos
+
+import (
+	"errors"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"testing"
+	"time"
+)
+
+func TestMembershipChangeTakesEffect(t *testing.T) {
+	peer := startLearnerPeer(nil)
+	defer peer.Close()
+	defer startNode(t, func(c *config.Conf) { c.MEMBERSHIP_DELAY = 2 }).stop()
+
+	if !membershipKnownFor(2) || membershipKnownFor(3) {
+		t.Fatal("the membership of turn id 3 is known before turn id 1 is learnt")
+	}
+
+	change := messages.MembershipChange{Op: "add", ID: 2, URL: peer.URL, Roles: []string{"learner"}}
+	changed, err := ProposeMembershipChange(change, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.ChangedAt != 1 || changed.FromTurnID != 3 || len(changed.Nodes) != 2 || changed.Active {
+		t.Fatalf("membership after the change: %+v, want 2 nodes in effect from turn id 3", changed)
+	}
+	if !membershipKnownFor(3) {
+		t.Fatal("the membership of turn id 3 is not known once turn id 1 is learnt")
+	}
+	if nodes := config.Members().NODES; len(nodes) != 1 {
+		t.Fatalf("nodes in use before turn id 3: %v, want the node alone", nodes)
+	}
+
+	// the change is in effect for the turn ids following turn id 2: turn id 3 is learnt by the new learner
+	submitWithin(t, "a", 5)
+	if nodes := config.Members().NODES; len(nodes) != 2 {
+		t.Fatalf("nodes in use once turn id 2 is learnt: %v, want the new node as well", nodes)
+	}
+	submitWithin(t, "b", 5)
+	if _, v := untagSubmit(peer.Learnt(3)); v != "b" {
+		t.Fatalf("value of turn id 3 learnt by the new node: '%s', want 'b'", v)
+	}
+}
+
+func TestInvalidMembershipChanges(t *testing.T) {
+	node := startNode(t, nil)
+	defer node.stop()
+
+	invalid := []messages.MembershipChange{
+		{Op: "add", URL: node.server.URL},
+		{Op: "add"},
+		{Op: "remove", URL: "http://not-a-node"},
+		{Op: "remove", ID: 1}, // the only node of the cluster
+		{Op: "rename", URL: "http://other"},
+	}
+	for _, change := range invalid {
+		if _, err := ProposeMembershipChange(change, time.Now().Add(time.Second)); !errors.Is(err, ErrInvalidMembershipChange) {
+			t.Fatalf("change %+v: err = %v, want ErrInvalidMembershipChange", change, err)
+		}
+	}
+}
+
+func TestStaleEpochIsRefused(t *testing.T) {
+	peer := startLearnerPeer(nil)
+	defer peer.Close()
+	defer startNode(t, func(c *config.Conf) { c.MEMBERSHIP_DELAY = 2 }).stop()
+
+	_, err := ProposeMembershipChange(messages.MembershipChange{Op: "add", ID: 2, URL: peer.URL, Roles: []string{"learner"}}, time.Now().Add(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the requests for turn id 3 have to be sent with the membership changed at turn id 1
+	if !isStaleEpoch(3, 0) || isStaleEpoch(3, 1) || isStaleEpoch(2, 0) {
+		t.Fatal("wrong epochs are considered outdated")
+	}
+	prepareRequest := messages.GenericMessage{TurnID: 3, Type: "prepare_request", Body: messages.Body{Proposal: proposal.Proposal{Pid: 2, Seq: 1}, Epoch: 0}}
+	if response := ReceivePrepare(prepareRequest); response.Body.Message != "stale membership" {
+		t.Fatalf("prepare request sent with an outdated membership: %+v, want it refused", response.Body)
+	}
+	prepareRequest.Body.Epoch = 1
+	if response := ReceivePrepare(prepareRequest); response.Body.Message != "promise" {
+		t.Fatalf("prepare request sent with the membership in effect: %+v, want a promise", response.Body)
+	}
+}
@@ -5,6 +5,7 @@ package messages
 
 import (
 	"go-paxos/paxos/proposal"
+	"time"
 )
 
 // Body is the body of the message being sent. It contains the main contents of the message and is the "wrappee" of the more general structure called GenericMessage.
@@ -12,6 +13,7 @@ type Body struct {
 	Message  string            `json:"message"`  // Message is an arbitrary string, in some messages is just used for debugging purposes, in other it is crucial.
 	Proposal proposal.Proposal `json:"proposal"` // Proposal is a Proposal instance.
 	Learnt   string            `json:"learnt"`   // Learnt is a field used to notify the receiver that a value has already been learnt for the current turn id. The value of the field is the value itself. If "" is found then no value has been learnt for this turn ID.
+	Epoch    int               `json:"epoch"`    // Epoch identifies, in prepare and accept requests, the membership the proposer counts the quorums of the turn id with: the turn id of the change it results from, 0 for the nodes of the '.yaml' file.
 }
 
 // GenericMessage is used as wrapper for the Body type. It adds two crucial fields: the TurnID field and the Type field.
@@ -21,11 +23,12 @@ type GenericMessage struct {
 	Body   Body   `json:"message_body"`
 }
 
-// ProposalWithTid is self explaining. It appends a turn id field to the proposal.
+// ProposalWithTid is self explaining. It appends a turn id field to the acceptor state of that turn.
 // This class is useful when printing all or multiple proposals values.
 type ProposalWithTid struct {
 	TurnID   int               `json:"turn_id"`
-	Proposal proposal.Proposal `json:"proposal"`
+	Proposal proposal.Proposal `json:"proposal"` // Proposal is the highest accepted proposal (number and value), null if nothing has been accepted.
+	Promised proposal.Proposal `json:"promised"` // Promised is the number of the highest promised prepare request, its value is always "".
 }
 
 // LearntWithTid is the representation of an entry of the 'learnt' table.
@@ -47,4 +50,163 @@ type NewValuesResponse struct {
 	// map having integers as keys and strings as values; the keys are the turn ids, the values are the learnt values for the respective key.
 	// See ComputeNewValueResponse in'seeker.go' to understand how this map is computed.
 	ToLearn map[int]string `json:"to_learn"`
+	// Snapshot is only sent to requesters whose last learnt turn id is lower than our checkpoint, the values it holds must be learnt as well.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+}
+
+// Snapshot folds the learnt values of every turn id up to LastTurnID (the checkpoint) into a single blob.
+// The entries of the 'learnt' and 'proposal' tables up to the checkpoint are deleted once the snapshot is stored.
+type Snapshot struct {
+	LastTurnID int            `json:"last_turn_id"` // LastTurnID is the checkpoint, every turn id from 1 to LastTurnID has a learnt value in Values.
+	Values     map[int]string `json:"values"`       // Values maps the turn ids to their learnt value.
+}
+
+// SubmitResponse is the response to a client submit.
+type SubmitResponse struct {
+	TurnID int    `json:"turn_id"` // TurnID is the turn id the submitted value has been learnt for.
+	Index  int    `json:"index"`   // Index is the position of the submitted value in the batch learnt for TurnID, 0 when it has not been batched.
+	V      string `json:"v"`       // V is the submitted value.
+}
+
+// AcceptedMessage is sent by an acceptor to every learner each time it accepts a proposal, when learn_from_accepted is enabled.
+type AcceptedMessage struct {
+	TurnID   int               `json:"turn_id"`
+	Acceptor string            `json:"acceptor"` // Acceptor is the url of the acceptor, as listed in the 'nodes' section of the '.yaml' file.
+	Proposal proposal.Proposal `json:"proposal"` // Proposal is the accepted proposal: its number (the ballot) and its value.
+}
+
+// GossipMessage carries a learnt value from a learner to a few other learners, when the dissemination is "gossip".
+type GossipMessage struct {
+	TurnID int    `json:"turn_id"`
+	Digest string `json:"digest"` // Digest is the hex encoded SHA-256 of V, the copies of a (TurnID, Digest) pair seen already are dropped.
+	V      string `json:"v"`
+}
+
+// MembershipChange is a change of the nodes of the cluster, it's agreed on as the value of a turn id (see ProposeMembershipChange).
+type MembershipChange struct {
+	Op     string   `json:"op"`     // Op is either "add" or "remove".
+	ID     int      `json:"id"`     // ID is the id of the node being added, or of the node being removed when URL is empty.
+	URL    string   `json:"url"`    // URL is the url of the node being added or removed.
+	Roles  []string `json:"roles"`  // Roles lists the roles of the node being added, all of them when empty.
+	Weight int      `json:"weight"` // Weight is the weight of the node being added, see the "weighted" quorum policy.
+	Zone   string   `json:"zone"`   // Zone is the zone of the node being added, see the "zones" quorum policy.
+}
+
+// MembershipNode is a node of the cluster, as listed in the 'nodes' section of the '.yaml' file.
+type MembershipNode struct {
+	ID     int      `json:"id"`
+	URL    string   `json:"url"`
+	Roles  []string `json:"roles"`
+	Weight int      `json:"weight"`
+	Zone   string   `json:"zone"`
+}
+
+// MembershipEpoch is a membership of the cluster and the turn ids it is in effect for.
+type MembershipEpoch struct {
+	FromTurnID int               `json:"from_turn_id"` // FromTurnID is the first turn id the membership is in effect for.
+	ChangedAt  int               `json:"changed_at"`   // ChangedAt is the turn id the change has been learnt for, 0 for the membership of the '.yaml' file.
+	Change     *MembershipChange `json:"change"`       // Change is the change leading to the membership, nil for the membership of the '.yaml' file.
+	Nodes      []MembershipNode  `json:"nodes"`
+	Active     bool              `json:"active"` // Active tells whether the membership is the one in use by this node.
+}
+
+// PipelineTurn describes a turn id in flight in the pipeline of the proposer.
+type PipelineTurn struct {
+	TurnID int       `json:"turn_id"`
+	V      string    `json:"v"`      // V is the value submitted for TurnID, it's the encoding of a batch when batching is enabled.
+	Phase  string    `json:"phase"`  // Phase is either "prepare", "accept" or "learn".
+	Seq    int       `json:"seq"`    // Seq is the sequence number of the current round.
+	Rounds int       `json:"rounds"` // Rounds counts the rounds run for TurnID so far, retries included.
+	Since  time.Time `json:"since"`  // Since is when the first round for TurnID started.
+}
+
+// PipelineState describes the pipeline of the proposer.
+type PipelineState struct {
+	Window   int            `json:"window"`    // Window is the maximum number of turn ids in flight.
+	Waiting  int            `json:"waiting"`   // Waiting counts the submits waiting for room in the pipeline.
+	InFlight []PipelineTurn `json:"in_flight"` // InFlight lists the turn ids in flight, lowest first.
+}
+
+// PendingLearn is a learn request a peer has not acknowledged yet, it's sent again until the peer does.
+type PendingLearn struct {
+	Peer      string    `json:"peer"`
+	TurnID    int       `json:"turn_id"`
+	V         string    `json:"v"`
+	Attempts  int       `json:"attempts"`   // Attempts counts the learn requests sent to Peer for TurnID so far.
+	NextRetry time.Time `json:"next_retry"` // NextRetry is when the learn request is sent again.
+}
+
+// PeerPendingLearns lists the learn requests a peer owes an acknowledgement for.
+type PeerPendingLearns struct {
+	Peer    string         `json:"peer"`
+	Name    string         `json:"name"`
+	Pending []PendingLearn `json:"pending"` // Pending lists the learn requests of the peer, lowest turn id first.
+}
+
+// LearntEntry is a single value of the replicated log, at position (TurnID, Index). A turn id holds several entries when its value is a batch.
+type LearntEntry struct {
+	TurnID int    `json:"turn_id"`
+	Index  int    `json:"index"`
+	V      string `json:"v"`
+}
+
+// RangePromise is the promise an acceptor makes to a leader: no proposal numbered lower than Promised will be promised or accepted
+// for any turn id higher than or equal to FromTurnID. A null Promised (pid = seq = 0) means no range has ever been promised.
+type RangePromise struct {
+	FromTurnID int               `json:"from_turn_id"` // FromTurnID is the first turn id covered by the promise.
+	Promised   proposal.Proposal `json:"promised"`     // Promised holds the number (pid, seq) of the leader, its V is always "".
+}
+
+// RangePrepareRequest asks the acceptors to promise Proposal for every turn id higher than or equal to FromTurnID, see RangePromise.
+type RangePrepareRequest struct {
+	FromTurnID int               `json:"from_turn_id"`
+	Proposal   proposal.Proposal `json:"proposal"` // Proposal holds the number (pid, seq) of the would-be leader, its V is ignored.
+	Epoch      int               `json:"epoch"`    // Epoch identifies the membership in effect for FromTurnID according to the would-be leader, see Body.Epoch.
+}
+
+// RangePrepareResponse is the response to a RangePrepareRequest.
+type RangePrepareResponse struct {
+	Message    string            `json:"message"`      // Message is either "promise" or "retry".
+	FromTurnID int               `json:"from_turn_id"` // FromTurnID is the first turn id covered by the request.
+	Promised   proposal.Proposal `json:"promised"`     // Promised is only set by a "retry", it's the number the leader has to exceed.
+	// Accepted lists the proposals accepted for the turn ids covered by the request which have no learnt value yet.
+	// The leader has to propose these values again before proposing its own on those turn ids.
+	Accepted []ProposalWithTid `json:"accepted"`
+	Learnt   map[int]string    `json:"learnt"` // Learnt maps the covered turn ids having a learnt value to that value.
+}
+
+// Heartbeat is sent periodically by the leader to every node, and returned by each node with the leader it believes in.
+type Heartbeat struct {
+	URL        string            `json:"url"`          // URL is where the leader can be reached, client submits are forwarded there.
+	Ballot     proposal.Proposal `json:"ballot"`       // Ballot is the number (pid, seq) of the leader's range promise, its V is always "".
+	FromTurnID int               `json:"from_turn_id"` // FromTurnID is the first turn id the leader is leading.
+}
+
+// LeaderInfo describes the leader as known by a node.
+type LeaderInfo struct {
+	Heartbeat
+	Name     string    `json:"name"`      // Name names the leader after its entry in the 'nodes' section, e.g. "node 2 (http://localhost:7772)"; empty when no leader is known.
+	IsSelf   bool      `json:"is_self"`   // IsSelf tells whether the node itself is the leader.
+	Alive    bool      `json:"alive"`     // Alive tells whether a heartbeat has been received within the leader timeout.
+	LastSeen time.Time `json:"last_seen"` // LastSeen is when the last heartbeat has been received, zero if none ever was.
+}
+
+// ReadRequest asks a learner for the value it has learnt for TurnID, or for its last learnt turn id when TurnID is 0.
+type ReadRequest struct {
+	TurnID int `json:"turn_id"`
+}
+
+// ReadResponse is the response to a ReadRequest, and to a client read.
+type ReadResponse struct {
+	TurnID      int    `json:"turn_id"`               // TurnID is the turn id that has been read.
+	V           string `json:"v"`                     // V is the value learnt for TurnID, "" if none has been learnt yet.
+	LastTurnID  int    `json:"last_turn_id"`          // LastTurnID is the highest turn id having a learnt value.
+	Consistency string `json:"consistency,omitempty"` // Consistency tells how the read has been served: "local", "quorum" or "lease".
+}
+
+// Info is the response to GET /info.
+type Info struct {
+	Message string `json:"message"` // Message is "<language>@<mode>@<pid>", kept for the clients reading the info of a node as a single string.
+	PID     int    `json:"pid"`     // PID is the pid the node numbers its proposals with, 0 when the node does not report it.
+	Mode    string `json:"mode"`    // Mode is "manual" or "automatic".
 }
@@ -0,0 +1,394 @@
+package paxos
+
+import (
+	"encoding/json"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// routes maps the routes the nodes call on each other to the function serving them, as main.go does.
+// Each function decodes the body of the request and returns the response to encode.
+var routes = map[string]func(body []byte) (interface{}, error){
+	"/acceptor/receive_prepare": func(body []byte) (interface{}, error) {
+		request := messages.GenericMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceivePrepare(request), nil
+	},
+	"/acceptor/receive_accept": func(body []byte) (interface{}, error) {
+		request := messages.GenericMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveAccept(request), nil
+	},
+	"/acceptor/receive_range_prepare": func(body []byte) (interface{}, error) {
+		request := messages.RangePrepareRequest{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveRangePrepare(request), nil
+	},
+	"/acceptor/confirm_accepted": func(body []byte) (interface{}, error) {
+		request := messages.AcceptedMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ConfirmAccepted(request), nil
+	},
+	"/learner/receive_learn": func(body []byte) (interface{}, error) {
+		request := messages.GenericMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveLearn(request), nil
+	},
+	"/learner/receive_accepted": func(body []byte) (interface{}, error) {
+		request := messages.AcceptedMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveAccepted(request), nil
+	},
+	"/learner/receive_gossip": func(body []byte) (interface{}, error) {
+		request := messages.GossipMessage{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveGossip(request), nil
+	},
+	"/learner/receive_read": func(body []byte) (interface{}, error) {
+		request := messages.ReadRequest{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveRead(request), nil
+	},
+	"/seeker/receive_seek": func(body []byte) (interface{}, error) {
+		request := messages.NewValuesRequest{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ComputeNewValuesResponse(request), nil
+	},
+	"/node/heartbeat": func(body []byte) (interface{}, error) {
+		request := messages.Heartbeat{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return ReceiveHeartbeat(request), nil
+	},
+}
+
+// testNode is the node run by a test: a node of its own cluster, serving the routes the nodes call on each other.
+// The other nodes of the cluster, if any, are httptest servers playing their part or dead peers (see deadPeer).
+type testNode struct {
+	server     *httptest.Server
+	goroutines int // goroutines counts the goroutines running before the node started.
+	mu         sync.Mutex
+	hits       map[string]int // hits counts the requests received on each route.
+}
+
+// startNode starts a node listed as node 1 in a cluster of its own, storing its state in memory.
+// @configure, when not nil, changes the config before the node starts, e.g. to add fake peers to NODE_ENTRIES.
+// The returned node has to be stopped by the caller.
+func startNode(t *testing.T, configure func(c *config.Conf)) *testNode {
+	t.Helper()
+
+	node := &testNode{hits: make(map[string]int), goroutines: runtime.NumGoroutine()}
+	node.server = httptest.NewServer(http.HandlerFunc(node.serve))
+
+	// the state is reset before the config is replaced, the work left in the background by the previous tests is over once it holds the locks
+	resetState()
+	config.CONF = config.Conf{
+		NODE_ENTRIES: []config.Node{{ID: 1, URL: node.server.URL}},
+		SELF_URL:     node.server.URL,
+		DB_TYPE:      "memory",
+		TIMEOUT:      1,
+	}
+	if configure != nil {
+		configure(&config.CONF)
+	}
+	config.CONF.FillEmptyFields()
+	config.SetMembers(&config.CONF)
+
+	err := config.CONF.CheckNodes()
+	if err == nil {
+		err = quorum.Init(&config.CONF)
+	}
+	if err == nil {
+		err = queries.PrepareDBConn("memory")
+	}
+	if err == nil {
+		err = queries.InitDatabase()
+	}
+	if err != nil {
+		node.server.Close()
+		t.Fatalf("could not start the node: %v", err)
+	}
+
+	err = InitBallots()
+	if err == nil {
+		InitMembership()
+		err = InitDeliveries()
+	}
+	if err != nil {
+		node.server.Close()
+		t.Fatalf("could not start the node: %v", err)
+	}
+	return node
+}
+
+// stop stops serving the requests of the other nodes, then waits a little for the work the node runs in the background (e.g. learn requests) to finish,
+// so that it does not see the config of the next test.
+func (n *testNode) stop() {
+	n.server.Close()
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > n.goroutines && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// serve counts the request, then answers it with the function of its route.
+func (n *testNode) serve(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	n.hits[r.URL.Path] += 1
+	n.mu.Unlock()
+
+	receive, exists := routes[r.URL.Path]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	response, err := receive(body)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	AddContentTypeJson(&w)
+	_, _ = w.Write([]byte(ToJson(response)))
+}
+
+// Hits returns the number of requests received on @path.
+func (n *testNode) Hits(path string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.hits[path]
+}
+
+// URL returns the url of the node.
+func (n *testNode) URL() string {
+	return n.server.URL
+}
+
+// deadPeer returns the url of a node which cannot be reached: nothing listens there anymore.
+func deadPeer() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+// acceptorPeer starts a node playing the acceptor role only, which promises and accepts every request.
+// Its promises for @turnID report @accepted as accepted earlier, as if another proposer had run an accept phase on it;
+// it confirms having accepted @accepted for @turnID to the learners checking its accepted messages, and nothing else.
+// The returned server has to be closed by the caller.
+func acceptorPeer(turnID int, accepted proposal.Proposal) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := messages.GenericMessage{}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &request)
+
+		response := messages.GenericMessage{TurnID: request.TurnID}
+		switch r.URL.Path {
+		case "/acceptor/receive_prepare":
+			response.Type, response.Body.Message = "prepare_response", "promise"
+			if request.TurnID == turnID {
+				response.Body.Proposal = accepted
+			}
+		case "/acceptor/receive_accept":
+			response.Type, response.Body.Message = "accept_response", "accept"
+		case "/acceptor/confirm_accepted":
+			acceptedMessage := messages.AcceptedMessage{}
+			_ = json.Unmarshal(body, &acceptedMessage)
+			response.Type, response.Body.Message = "confirm_accepted_response", "not accepted"
+			if acceptedMessage.TurnID == turnID && acceptedMessage.Proposal == accepted {
+				response.Body.Message = "accepted"
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(ToJson(response)))
+	}))
+}
+
+// learnerPeer is a node playing the learner role only, it answers the learn, gossip and read requests out of the values it holds.
+type learnerPeer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	learnt   map[int]string
+	requests int // requests counts the learn and gossip requests received.
+}
+
+// startLearnerPeer starts a learner peer holding the values of @learnt, keyed by turn id. The returned peer has to be closed by the caller.
+func startLearnerPeer(learnt map[int]string) *learnerPeer {
+	peer := &learnerPeer{learnt: make(map[int]string)}
+	for turnID, v := range learnt {
+		peer.learnt[turnID] = v
+	}
+	peer.Server = httptest.NewServer(http.HandlerFunc(peer.serve))
+	return peer
+}
+
+// serve answers a request the way a learner does, the values of the learn and gossip requests are learnt unless another value has been learnt already.
+func (p *learnerPeer) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var turnID int
+	var v string
+	switch r.URL.Path {
+	case "/learner/receive_learn":
+		request := messages.GenericMessage{}
+		_ = json.Unmarshal(body, &request)
+		turnID, v = request.TurnID, request.Body.Proposal.V
+	case "/learner/receive_gossip":
+		request := messages.GossipMessage{}
+		_ = json.Unmarshal(body, &request)
+		turnID, v = request.TurnID, request.V
+	case "/learner/receive_read":
+		request := messages.ReadRequest{}
+		_ = json.Unmarshal(body, &request)
+		lastTurnID := 0
+		for learntTurnID := range p.learnt {
+			if learntTurnID > lastTurnID {
+				lastTurnID = learntTurnID
+			}
+		}
+		if request.TurnID == 0 {
+			request.TurnID = lastTurnID
+		}
+		_, _ = w.Write([]byte(ToJson(messages.ReadResponse{TurnID: request.TurnID, V: p.learnt[request.TurnID], LastTurnID: lastTurnID})))
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	p.requests += 1
+	if p.learnt[turnID] == "" {
+		p.learnt[turnID] = v
+	}
+	response := messages.GenericMessage{TurnID: turnID, Type: "learn_response"}
+	response.Body.Message, response.Body.Learnt = "value stored", p.learnt[turnID]
+	_, _ = w.Write([]byte(ToJson(response)))
+}
+
+// Learnt returns the value the peer holds for @turnID.
+func (p *learnerPeer) Learnt(turnID int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.learnt[turnID]
+}
+
+// Requests returns the number of learn and gossip requests the peer received.
+func (p *learnerPeer) Requests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests
+}
+
+// resetState forgets the state the previous tests left in the package, as a restart of the node does.
+func resetState() {
+	tallies.Lock()
+	tallies.acceptors = make(map[int]map[proposal.Proposal][]string)
+	tallies.since = make(map[int]time.Time)
+	tallies.chosen = make(map[int]chan struct{})
+	tallies.waiters = make(map[int]int)
+	tallies.Unlock()
+
+	ballots.Lock()
+	ballots.highestSeq = 0
+	ballots.Unlock()
+
+	batcher.Lock()
+	if batcher.timer != nil {
+		batcher.timer.Stop()
+	}
+	batcher.pending, batcher.timer = nil, nil
+	batcher.Unlock()
+
+	submitIDs.Lock()
+	submitIDs.counter = 0
+	submitIDs.Unlock()
+
+	reserved.Lock()
+	reserved.turnID = 0
+	reserved.Unlock()
+
+	knownLeader.Lock()
+	knownLeader.heartbeat, knownLeader.lastSeen = messages.Heartbeat{}, time.Time{}
+	knownLeader.Unlock()
+
+	lease.Lock()
+	lease.ballot, lease.until = proposal.Proposal{}, time.Time{}
+	lease.Unlock()
+
+	gossip.Lock()
+	gossip.seen, gossip.highestTurnID = nil, 0
+	gossip.Unlock()
+
+	leadership.Lock()
+	leadership.active, leadership.fromTurnID, leadership.epoch, leadership.seq, leadership.pending = false, 0, 0, 0, nil
+	leadership.Unlock()
+
+	conflicts.Lock()
+	conflicts.list = nil
+	conflicts.Unlock()
+
+	pipeline.Lock()
+	pipeline.slots, pipeline.inFlight, pipeline.waiting = nil, nil, 0
+	pipeline.Unlock()
+
+	deliveries.Lock()
+	deliveries.pending, deliveries.dirty = nil, nil
+	deliveries.Unlock()
+}
+
+// submitWithin submits @v, giving up after @seconds seconds.
+func submitWithin(t *testing.T, v string, seconds int) messages.SubmitResponse {
+	t.Helper()
+
+	submitResponse, err := Submit(v, time.Now().Add(time.Duration(seconds)*time.Second))
+	if err != nil {
+		t.Fatalf("could not submit '%s': %v", v, err)
+	}
+	return submitResponse
+}
+
+// waitFor polls @condition until it holds, the test fails if it does not hold within 5 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
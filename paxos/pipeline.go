@@ -0,0 +1,121 @@
+/*
+
+# Pipeline:
+Client submits do not wait for each other: every submit reserves a turn id of its own (see nextFreeTurnID) and runs its rounds independently,
+retrying with a higher sequence number or moving on to the next free turn id without holding back the other ones.
+Up to PIPELINE_WINDOW turn ids are in flight at once, the submits beyond the window wait for a slot (or for their deadline).
+The phase of each turn id in flight is tracked and exposed through /proposer/pipeline:
+
+	prepare -> accept -> learn
+
+In leader mode the prepare phase is skipped, turn ids go straight to the accept phase.
+
+*/
+
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pipeline holds the turn ids in flight, keyed by turn id, and the slots limiting their number.
+var pipeline struct {
+	sync.Mutex
+	slots    chan struct{} // slots holds one element per turn id in flight, its capacity is PIPELINE_WINDOW.
+	inFlight map[int]*messages.PipelineTurn
+	waiting  int // waiting counts the submits waiting for a slot.
+}
+
+// pipelineSlots returns the slots of the pipeline, they are made on first use since the window is only known once the config is loaded.
+func pipelineSlots() chan struct{} {
+	pipeline.Lock()
+	defer pipeline.Unlock()
+
+	if pipeline.slots == nil {
+		pipeline.slots = make(chan struct{}, config.CONF.PIPELINE_WINDOW)
+		pipeline.inFlight = make(map[int]*messages.PipelineTurn)
+	}
+	return pipeline.slots
+}
+
+// enterPipeline waits for a slot in the pipeline, ErrSubmitDeadline is returned if none frees up before @deadline.
+func enterPipeline(deadline time.Time) error {
+	slots := pipelineSlots()
+
+	pipeline.Lock()
+	pipeline.waiting += 1
+	pipeline.Unlock()
+	defer func() {
+		pipeline.Lock()
+		pipeline.waiting -= 1
+		pipeline.Unlock()
+	}()
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return ErrSubmitDeadline
+	}
+}
+
+// leavePipeline frees the slot taken by enterPipeline, @turnID is the last turn id the submit has tried.
+func leavePipeline(turnID int) {
+	pipeline.Lock()
+	delete(pipeline.inFlight, turnID)
+	pipeline.Unlock()
+
+	<-pipelineSlots()
+}
+
+// trackTurn records that the submit of @v starts a round on @turnID with sequence number @seq, in @phase.
+// The turn id previously tried by the same submit, @previousTurnID, stops being tracked.
+func trackTurn(previousTurnID int, turnID int, seq int, v string, phase string) {
+	pipeline.Lock()
+	defer pipeline.Unlock()
+
+	if previousTurnID != turnID {
+		delete(pipeline.inFlight, previousTurnID)
+	}
+	turn, tracked := pipeline.inFlight[turnID]
+	if !tracked {
+		turn = &messages.PipelineTurn{TurnID: turnID, V: v, Since: time.Now()}
+		pipeline.inFlight[turnID] = turn
+	}
+	turn.Rounds += 1
+	turn.Seq = seq
+	turn.Phase = phase
+}
+
+// trackPhase moves the turn id @turnID to @phase, nothing happens if no submit runs on @turnID (e.g. a manual request).
+func trackPhase(turnID int, phase string) {
+	pipeline.Lock()
+	defer pipeline.Unlock()
+
+	if turn, tracked := pipeline.inFlight[turnID]; tracked {
+		turn.Phase = phase
+	}
+}
+
+// GetPipeline returns the turn ids in flight, lowest first, and how many submits are waiting for a slot.
+func GetPipeline() messages.PipelineState {
+	pipelineSlots()
+
+	pipeline.Lock()
+	defer pipeline.Unlock()
+
+	state := messages.PipelineState{
+		Window:   config.CONF.PIPELINE_WINDOW,
+		Waiting:  pipeline.waiting,
+		InFlight: []messages.PipelineTurn{},
+	}
+	for _, turn := range pipeline.inFlight {
+		state.InFlight = append(state.InFlight, *turn)
+	}
+	sort.Slice(state.InFlight, func(i, j int) bool { return state.InFlight[i].TurnID < state.InFlight[j].TurnID })
+	return state
+}
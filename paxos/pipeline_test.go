@@ -0,0 +1,100 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineWindow(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) { c.PIPELINE_WINDOW = 2 }).stop()
+
+	for i := 0; i < 2; i++ {
+		if err := enterPipeline(time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("submit %d could not enter a pipeline with room: %v", i+1, err)
+		}
+	}
+
+	// the window is full: the third submit waits until its deadline
+	entered := make(chan error)
+	go func() { entered <- enterPipeline(time.Now().Add(200 * time.Millisecond)) }()
+	waitFor(t, "the third submit to wait", func() bool { return GetPipeline().Waiting == 1 })
+	if err := <-entered; err != ErrSubmitDeadline {
+		t.Fatalf("submit entering a full pipeline: err = %v, want ErrSubmitDeadline", err)
+	}
+
+	// a slot frees up while the fourth submit waits
+	go func() { entered <- enterPipeline(time.Now().Add(5 * time.Second)) }()
+	waitFor(t, "the fourth submit to wait", func() bool { return GetPipeline().Waiting == 1 })
+	leavePipeline(0)
+	if err := <-entered; err != nil {
+		t.Fatalf("submit waiting for a slot: err = %v, want it to enter once a slot frees up", err)
+	}
+	if waiting := GetPipeline().Waiting; waiting != 0 {
+		t.Fatalf("%d submit(s) waiting, want none", waiting)
+	}
+}
+
+func TestPipelineTracksTurns(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	if err := enterPipeline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	trackTurn(1, 1, 1, "a", "prepare")
+	trackPhase(1, "accept")
+	trackTurn(1, 1, 2, "a", "prepare")
+
+	state := GetPipeline()
+	if len(state.InFlight) != 1 {
+		t.Fatalf("turn ids in flight: %+v, want turn id 1 only", state.InFlight)
+	}
+	if turn := state.InFlight[0]; turn.TurnID != 1 || turn.Seq != 2 || turn.Rounds != 2 || turn.Phase != "prepare" {
+		t.Fatalf("turn id in flight: %+v, want turn id 1 in its second round (seq 2, prepare)", turn)
+	}
+
+	// turn id 1 went to another value, the submit moves on to turn id 2
+	trackTurn(1, 2, 1, "a", "prepare")
+	state = GetPipeline()
+	if len(state.InFlight) != 1 || state.InFlight[0].TurnID != 2 || state.InFlight[0].Rounds != 1 {
+		t.Fatalf("turn ids in flight: %+v, want turn id 2 only, in its first round", state.InFlight)
+	}
+
+	leavePipeline(2)
+	if state = GetPipeline(); len(state.InFlight) != 0 {
+		t.Fatalf("turn ids in flight after the submit returned: %+v, want none", state.InFlight)
+	}
+}
+
+func TestConcurrentSubmits(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) { c.PIPELINE_WINDOW = 3 }).stop()
+
+	const submits = 8
+	turnIDs := make(chan int, submits)
+	var wg sync.WaitGroup
+	for i := 0; i < submits; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			submitResponse, err := Submit(string(rune('a'+i)), time.Now().Add(10*time.Second))
+			if err != nil {
+				t.Error(err)
+			}
+			turnIDs <- submitResponse.TurnID
+		}(i)
+	}
+	wg.Wait()
+	close(turnIDs)
+
+	seen := make(map[int]bool)
+	for turnID := range turnIDs {
+		if turnID < 1 || turnID > submits || seen[turnID] {
+			t.Fatalf("turn id %d handed out to a submit, want each of the turn ids 1 to %d once", turnID, submits)
+		}
+		seen[turnID] = true
+	}
+	if state := GetPipeline(); len(state.InFlight) != 0 || state.Waiting != 0 {
+		t.Fatalf("pipeline after every submit returned: %+v, want it empty", state)
+	}
+}
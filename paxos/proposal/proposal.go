@@ -36,3 +36,17 @@ func (p *Proposal) IsGEThan(other *Proposal) bool {
 func (p *Proposal) IsLEThan(other *Proposal) bool {
 	return p.IsLowerThan(other) || p.IsEqualTo(other)
 }
+
+// AcceptorState is what an acceptor has to remember for each turn id, as described in the header of 'acceptor.go':
+// the number of the highest-numbered prepare request it has promised and the highest-numbered proposal it has accepted.
+// The two are kept apart, the promised number moves forward with prepare requests while the accepted proposal keeps
+// the number under which its value was actually accepted.
+type AcceptorState struct {
+	Promised Proposal `json:"promised"` // Promised holds the number (pid, seq) of the highest promised prepare request, its V is always "".
+	Accepted Proposal `json:"accepted"` // Accepted is the highest-numbered accepted proposal together with its value. It's null (pid = seq = 0) until something is accepted.
+}
+
+// HasAccepted tells whether a proposal has ever been accepted.
+func (s *AcceptorState) HasAccepted() bool {
+	return s.Accepted.Pid != 0 || s.Accepted.Seq != 0
+}
@@ -28,6 +28,7 @@ import (
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
 	"log"
 	"math/rand"
 	"net/http"
@@ -38,19 +39,26 @@ import (
 // If the current learnt value (@currentV) is empty the learner learns the proposed value (@proposedV) and floods the network with it.
 // Else if the current learnt value (@currentV) is NOT empty, two things can happen:
 // 1. @currentV == @proposedV, in this case no further action is performed; we already knew that value.
-// 2. @currentV != @proposedV, a warning is printed; some node (or user) is not following the protocol.
+// 2. @currentV != @proposedV, the conflict is recorded (see learnValue); some node (or user) is not following the protocol.
 // This function is called whenever the field 'Learnt' on a response message during the prepare/accept phase is not empty.
 // As soon as such thing occurs the prepare/accept phase is dropped immediately and the proposed value is learnt.
-func learnAndFlood(responseMessage messages.GenericMessage) {
+// When learn_from_accepted is enabled the reported value is not learnt from the response itself: the value is waited for from the accepted messages instead.
+// The value learnt for the turn id is returned, an empty string if it's not known yet.
+func learnAndFlood(responseMessage messages.GenericMessage) string {
 	turnID := responseMessage.TurnID
 	currentV := queries.GetLearntValue(turnID)
 	proposedV := responseMessage.Body.Learnt
 
+	if config.CONF.LEARN_FROM_ACCEPTED {
+		// the learners are reached by the accepted messages of the acceptors, there is nothing to flood
+		return awaitChosen(turnID, time.Second*config.CONF.TIMEOUT/2)
+	}
+
 	if currentV == "" {
 		// i currently dont have a learnt  value for this turnID
 		// therefore i should store the value reported in 'learnt', and notify all the other nodes
 		// finally i should drop any further computation
-		err := queries.SetLearntValue(turnID, proposedV)
+		err := learnValue(turnID, proposedV, "proposer")
 		if err != nil {
 			// can this ever happen?, yes it can.
 			// could not store learnt, do nothing
@@ -64,25 +72,34 @@ func learnAndFlood(responseMessage messages.GenericMessage) {
 		if currentV != proposedV {
 			// this is supposed to be deadcode in production
 			// this can only happen if we forcefully try to make it happen using "debug" routes while not
-			// respecting the algorithm
-			log.Print("!!!WARNING!!! BEING PROPOSED TO LEARN A VALUE DIFFERENT FROM WHAT I ALREADY HAVE, are you following the algorithm?")
+			// respecting the algorithm; the write is refused and learnValue records the conflict
+			_ = learnValue(turnID, proposedV, "proposer")
 		}
 	}
+	return queries.GetLearntValue(turnID)
 }
 
-// countAgreements counts how many of the acceptors gave us a 'promise' to our prepare request. Based on the number of responses and their content different actions will be performed.
-func countAgreements(responseBuffer chan []byte, turnID int, seq int, proposedV string) (messageToUser string, err error) {
-	agreements := 0
-	responseCount := 0
-	highestPromise := proposal.Proposal{}
-	highestRetry := proposal.Proposal{}
-	messageToUser = ""
+// prepareTally holds what the acceptors answered to a prepare request.
+type prepareTally struct {
+	agreements     int                      // number of 'promise' responses
+	responseCount  int                      // number of nodes that responded
+	promisers      []string                 // nodes that sent a 'promise', they are checked against the phase-1 quorums
+	responders     []string                 // nodes that responded
+	highestPromise proposal.Proposal        // highest accepted proposal (with a value) reported by the promises
+	highestRetry   proposal.Proposal        // highest promised number reported by the 'retry' responses
+	learnt         *messages.GenericMessage // first response carrying a learnt value, nil if none did
+}
+
+// tallyPromises reads the responses to a prepare request from @responseBuffer and counts them.
+// As soon as a response carries a learnt value the counting stops, the response is returned in 'learnt'.
+func tallyPromises(responseBuffer chan nodeResponse) (tally prepareTally, err error) {
 
 	// for each response collected
 	for i := 0; i < cap(responseBuffer); i++ {
 
 		// popping one message from buffer --> previously called "resMsg"
-		responseData := <-responseBuffer
+		response := <-responseBuffer
+		responseData := response.data
 
 		// initializing data struct upon which the message will be unmarshalled --> previously called "res"
 		responseMessage := messages.GenericMessage{}
@@ -101,44 +118,68 @@ func countAgreements(responseBuffer chan []byte, turnID int, seq int, proposedV
 			err := json.Unmarshal(responseData, &responseMessage)
 			if err != nil {
 				log.Print(err.Error())
-				return "Errors while unmarshalling responses, someone is not respecting the protocol.", err
+				return prepareTally{}, err
 			}
 			// no errors during unmarshalling, counting non empty responses
-			responseCount++
+			tally.responseCount++
+			tally.responders = append(tally.responders, response.node)
 		}
 
 		// handling "learnt" response
 		if ResponseHasLearntValue(responseMessage) {
-			log.Printf("[PROPOSER] -> One of the responses has already learnt '%v' for turn id %d. Learn the value and drop any further computation.", responseMessage.Body.Learnt, turnID)
-			learnAndFlood(responseMessage)
-			return "One of the responses has a learnt value. Learning and flooding.", nil
+			tally.learnt = &responseMessage
+			return tally, nil
 		}
 
 		// counting promises and saving the highest messages with a value, and the highest retry pid and seq
 		if responseMessage.Body.Message == "promise" {
-			agreements += 1
+			tally.agreements += 1
+			tally.promisers = append(tally.promisers, response.node)
 
 			// highest holds the highest non null valued promise response
 			prop := responseMessage.Body.Proposal
-			if prop.IsGreaterThan(&highestPromise) && prop.V != "" {
-				highestPromise = prop
+			if prop.IsGreaterThan(&tally.highestPromise) && prop.V != "" {
+				tally.highestPromise = prop
 			}
 
 		} else if responseMessage.Body.Message == "retry" {
 			prop := responseMessage.Body.Proposal
-			if prop.IsGreaterThan(&highestRetry) {
-				highestRetry = prop
+			log.Printf("[PROPOSER] -> %s refused the prepare request, it promised %s (seq: %d).", config.Members().NodeName(response.node), config.Members().PIDName(prop.Pid), prop.Seq)
+			if prop.IsGreaterThan(&tally.highestRetry) {
+				tally.highestRetry = prop
 			}
 		}
 	}
+	return tally, nil
+}
+
+// countAgreements counts how many of the acceptors gave us a 'promise' to our prepare request. Based on the number of responses and their content different actions will be performed.
+func countAgreements(responseBuffer chan nodeResponse, turnID int, seq int, proposedV string) (messageToUser string, err error) {
+	tally, err := tallyPromises(responseBuffer)
+	if err != nil {
+		return "Errors while unmarshalling responses, someone is not respecting the protocol.", err
+	}
+	q := quorumsForTurn(turnID)
+
+	// handling "learnt" response
+	if tally.learnt != nil {
+		log.Printf("[PROPOSER] -> One of the responses has already learnt '%v' for turn id %d. Learn the value and drop any further computation.", tally.learnt.Body.Learnt, turnID)
+		learnAndFlood(*tally.learnt)
+		return "One of the responses has a learnt value. Learning and flooding.", nil
+	}
+
+	agreements := tally.agreements
+	responseCount := tally.responseCount
+	highestPromise := tally.highestPromise
+	highestRetry := tally.highestRetry
 
 	// after i checked ALL the proposals (looking for the highest)
 	// i check if QUORUM is reached
-	if agreements >= config.CONF.QUORUM {
+	if q.IsPhase1Quorum(tally.promisers) {
 
 		// QUORUM has been reached
-		log.Printf("[PROPOSER] -> Quorum has been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(config.CONF.NODES), turnID, seq, proposedV)
-		messageToUser = fmt.Sprintf("Quorum has been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(config.CONF.NODES), turnID, seq, proposedV)
+		log.Printf("[PROPOSER] -> Quorum has been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(q.Acceptors), turnID, seq, proposedV)
+		messageToUser = fmt.Sprintf("Quorum has been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(q.Acceptors), turnID, seq, proposedV)
 
 		// sanity check: has highest ever been updated?
 		if highestPromise.V == "" {
@@ -169,11 +210,11 @@ func countAgreements(responseBuffer chan []byte, turnID int, seq int, proposedV
 		}
 
 	} else {
-		messageToUser = fmt.Sprintf("Quorum has NOT been reached  (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(config.CONF.NODES), turnID, seq, proposedV)
-		if highestRetry.Pid != 0 && responseCount >= config.CONF.QUORUM {
+		messageToUser = fmt.Sprintf("Quorum has NOT been reached  (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}.", agreements, len(q.Acceptors), turnID, seq, proposedV)
+		if highestRetry.Pid != 0 && q.IsPhase1Quorum(tally.responders) {
 			// highestRetry.Pid != 0 is how i check if the highestRetry has ever been updated.
-			log.Printf("[PROPOSER] -> Quorum has NOT been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}, but a majority of nodes is up and running; increment 'seq' and retry.", agreements, len(config.CONF.NODES), turnID, seq, proposedV)
-			incrementedSeq := highestRetry.Seq + 1
+			log.Printf("[PROPOSER] -> Quorum has NOT been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}, but a quorum of nodes is up and running; increment 'seq' and retry.", agreements, len(q.Acceptors), turnID, seq, proposedV)
+			incrementedSeq := freshSeq(highestRetry.Seq + 1)
 			if !config.CONF.MANUAL_MODE {
 				// waiting a random amount before retrying to allow others to finish
 				// rand.float32() generates a number between 0 and 1, adding a flat 0.2 amount
@@ -207,26 +248,34 @@ func countAgreements(responseBuffer chan []byte, turnID int, seq int, proposedV
 					}
 				}
 			}*/
-			log.Printf("[PROPOSER] -> Quorum has NOT been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}; the algorithm suggests: do not proceed further, progress is not possible.", agreements, len(config.CONF.NODES), turnID, seq, proposedV)
-			messageToUser += fmt.Sprintf(" Only %d responded but %d are needed for progress.", responseCount, config.CONF.QUORUM)
+			log.Printf("[PROPOSER] -> Quorum has NOT been reached (%d/%d) for prepare request with proposal {turn_id: %d, seq: %d, v: %s}; the algorithm suggests: do not proceed further, progress is not possible.", agreements, len(q.Acceptors), turnID, seq, proposedV)
+			messageToUser += fmt.Sprintf(" Only %d responded, not enough for a quorum (%s).", responseCount, q)
 		}
 	}
 	// return agreements even if QUORUM was not reached, not required.
 	return messageToUser, nil
 }
 
-// countApprovals counts how many of the acceptors gave us an 'accept' to our accept request. Based on the number of responses and their content different actions will be performed.
-func countApprovals(responseBuffer chan []byte, turnID int, _ int, proposedV string) (messageToUser string, err error) {
-	approvals := 0
-	responseCount := 0
-	highestDecline := proposal.Proposal{}
-	messageToUser = ""
+// acceptTally holds what the acceptors answered to an accept request.
+type acceptTally struct {
+	approvals      int                      // number of 'accept' responses
+	responseCount  int                      // number of nodes that responded
+	approvers      []string                 // nodes that sent an 'accept', they are checked against the phase-2 quorums
+	responders     []string                 // nodes that responded
+	highestDecline proposal.Proposal        // highest promised number reported by the 'decline' responses
+	learnt         *messages.GenericMessage // first response carrying a learnt value, nil if none did
+}
+
+// tallyApprovals reads the responses to an accept request from @responseBuffer and counts them.
+// As soon as a response carries a learnt value the counting stops, the response is returned in 'learnt'.
+func tallyApprovals(responseBuffer chan nodeResponse) (tally acceptTally, err error) {
 
 	// for each response collected
 	for i := 0; i < cap(responseBuffer); i++ {
 
 		// popping one message from buffer
-		responseData := <-responseBuffer
+		response := <-responseBuffer
+		responseData := response.data
 
 		// initializing data struct upon which the message will be unmarshalled
 		responseMessage := messages.GenericMessage{}
@@ -239,40 +288,62 @@ func countApprovals(responseBuffer chan []byte, turnID int, _ int, proposedV str
 		//
 		// if @responseData is NOT nil, then we unmarshal @responseData over to @responseMessage
 		//
-		// if error occur unmarshalling responses an error message is returned
+		// if error occur unmarshalling responses an error is returned
 		if responseData != nil {
 			err := json.Unmarshal(responseData, &responseMessage)
 			if err != nil {
 				log.Print(err.Error())
-				return "Errors while unmarshalling responses", err
+				return acceptTally{}, err
 			}
-			responseCount++
+			tally.responseCount++
+			tally.responders = append(tally.responders, response.node)
 		}
 
 		if ResponseHasLearntValue(responseMessage) {
-			log.Printf("[PROPOSER] -> One of the responses has already learnt %v for turn id %d. Learn the value and drop any further computation.", responseMessage.Body.Learnt, turnID)
-			learnAndFlood(responseMessage)
-			return "One of the responses has a learnt value. Learning and flooding.", nil
+			tally.learnt = &responseMessage
+			return tally, nil
 		}
 
 		// counting approvals
 		if responseMessage.Body.Message == "accept" {
-			approvals += 1
+			tally.approvals += 1
+			tally.approvers = append(tally.approvers, response.node)
 		} else if responseMessage.Body.Message == "decline" {
 			prop := responseMessage.Body.Proposal
+			log.Printf("[PROPOSER] -> %s declined the accept request, it promised %s (seq: %d).", config.Members().NodeName(response.node), config.Members().PIDName(prop.Pid), prop.Seq)
 
-			if prop.IsGreaterThan(&highestDecline) {
-				highestDecline = prop
+			if prop.IsGreaterThan(&tally.highestDecline) {
+				tally.highestDecline = prop
 			}
 		}
 	}
+	return tally, nil
+}
+
+// countApprovals counts how many of the acceptors gave us an 'accept' to our accept request. Based on the number of responses and their content different actions will be performed.
+func countApprovals(responseBuffer chan nodeResponse, turnID int, _ int, proposedV string) (messageToUser string, err error) {
+	tally, err := tallyApprovals(responseBuffer)
+	if err != nil {
+		return "Errors while unmarshalling responses", err
+	}
+	q := quorumsForTurn(turnID)
+
+	if tally.learnt != nil {
+		log.Printf("[PROPOSER] -> One of the responses has already learnt %v for turn id %d. Learn the value and drop any further computation.", tally.learnt.Body.Learnt, turnID)
+		learnAndFlood(*tally.learnt)
+		return "One of the responses has a learnt value. Learning and flooding.", nil
+	}
+
+	approvals := tally.approvals
+	responseCount := tally.responseCount
+	highestDecline := tally.highestDecline
 
 	// i could put this right after the approval increment and break the loop
 	// when quorum is reached, but i prefer
 	// checking at the end for readability purposes
-	if approvals >= config.CONF.QUORUM {
-		log.Printf("[PROPOSER] -> Quorum for accept request reached: got %d/%d accepts.", approvals, len(config.CONF.NODES))
-		messageToUser = fmt.Sprintf("Quorum has been reached for accept request (%d/%d). ", approvals, len(config.CONF.NODES))
+	if q.IsPhase2Quorum(tally.approvers) {
+		log.Printf("[PROPOSER] -> Quorum for accept request reached: got %d/%d accepts.", approvals, len(q.Acceptors))
+		messageToUser = fmt.Sprintf("Quorum has been reached for accept request (%d/%d). ", approvals, len(q.Acceptors))
 		if !config.CONF.MANUAL_MODE {
 			time.Sleep(config.CONF.WAIT_BEFORE_AUTOMATIC_REQUEST * time.Second)
 			log.Printf("[PROPOSER] -> Sending learn request.")
@@ -285,10 +356,10 @@ func countApprovals(responseBuffer chan []byte, turnID int, _ int, proposedV str
 		}
 
 	} else {
-		messageToUser = fmt.Sprintf("Quorum has NOT been reached for accept request (%d/%d). ", approvals, len(config.CONF.NODES))
-		if highestDecline.Pid != 0 && responseCount >= config.CONF.QUORUM {
-			log.Print("[PROPOSER] -> Quorum has NOT been reached for accept request but a majority of nodes is up and running; increment 'seq' and try again.")
-			incrementedSeq := highestDecline.Seq + 1
+		messageToUser = fmt.Sprintf("Quorum has NOT been reached for accept request (%d/%d). ", approvals, len(q.Acceptors))
+		if highestDecline.Pid != 0 && q.IsPhase2Quorum(tally.responders) {
+			log.Print("[PROPOSER] -> Quorum has NOT been reached for accept request but a quorum of nodes is up and running; increment 'seq' and try again.")
+			incrementedSeq := freshSeq(highestDecline.Seq + 1)
 			log.Printf("[COUNTING ACCEPTS] -> highest decline has seq = to %d, incrementing it brings it to %d", highestDecline.Seq, incrementedSeq)
 			if !config.CONF.MANUAL_MODE {
 				// if we are not in manual mode then wait a random amount of seconds to allow the other proposer(s) to finish
@@ -309,41 +380,29 @@ func countApprovals(responseBuffer chan []byte, turnID int, _ int, proposedV str
 
 		} else {
 			log.Print("[PROPOSER] -> Quorum has NOT been reached for accept request; the algorithm suggests: do not proceed further, progress is not possible.")
-			messageToUser += fmt.Sprintf(" Only %d responded but %d are needed for progress.", responseCount, config.CONF.QUORUM)
+			messageToUser += fmt.Sprintf(" Only %d responded, not enough for a quorum (%s).", responseCount, q)
 		}
 
 	}
 	return messageToUser, nil
 }
 
-// SendPrepare sends a prepare request to all the acceptors in the network, the values of the prepare request are to be provided by the user (except @v which can remain empty).
-func SendPrepare(turnID int, seq int, v string, optimization bool) (messageToUser string) {
-
-	var NODES []string
-
-	if optimization {
-		idx := rand.Perm(len(config.CONF.NODES))
-		for i := 0; i < config.CONF.QUORUM; i++ {
-			NODES = append(NODES, config.CONF.NODES[idx[i]])
-		}
-	} else {
-		NODES = config.CONF.NODES
+// pickNodes returns the acceptors of @q a prepare/accept request is sent to: all of them, or just enough random ones to form a quorum according to @isQuorum when @optimization is true.
+func pickNodes(q *quorum.Quorums, optimization bool, isQuorum func(nodes []string) bool) []string {
+	if !optimization {
+		return q.Acceptors
 	}
+	return q.Pick(isQuorum)
+}
 
-	log.Printf("[PROPOSER] -> Starting prepare request; turn_id: %d, seq: %d, v: %s.", turnID, seq, v)
-	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
-	ch := make(chan []byte, len(NODES))
-
-	currentV := queries.GetLearntValue(turnID)
-	if currentV != "" {
-		log.Printf("[PROPOSER] -> Value '%s' has already been learnt for turn_id: %d. Dropping prepare request.", currentV, turnID)
-		return fmt.Sprintf("Value for turn_id: %d is already known: %s. Dropping prepare request.", turnID, currentV)
-	}
+// broadcastPrepare sends the prepare request (@turnID, @seq, @v) to each node in @NODES, the responses are collected in the returned channel.
+func broadcastPrepare(session *http.Client, NODES []string, turnID int, seq int, v string) chan nodeResponse {
+	ch := make(chan nodeResponse, len(NODES))
+	epochID := epochForTurn(turnID).changedAt
 
 	// send a request for each node
 	// responses are saved in ch
 	for _, node := range NODES {
-		url := node + "/acceptor/receive_prepare"
 
 		// building prepare message
 		prepareRequestMessage := messages.GenericMessage{
@@ -357,13 +416,78 @@ func SendPrepare(turnID int, seq int, v string, optimization bool) (messageToUse
 					V:   v,   // client will pass this param, might be empty string
 				},
 				Learnt: "",
+				Epoch:  epochID, // the acceptors refuse an outdated membership
 			},
 		}
-		go sendPartialRequest(session, url, ch, prepareRequestMessage)
+		go sendTaggedRequest(session, node, "/acceptor/receive_prepare", ch, prepareRequestMessage)
 	}
+	return ch
+}
+
+// broadcastAccept sends the accept request (@turnID, @seq, @v) to each node in @NODES, the responses are collected in the returned channel.
+func broadcastAccept(session *http.Client, NODES []string, turnID int, seq int, v string) chan nodeResponse {
+	ch := make(chan nodeResponse, len(NODES))
+	epochID := epochForTurn(turnID).changedAt
+
+	// send a request for each node
+	// responses are saved in ch
+	for _, node := range NODES {
+
+		// building accept message
+		acceptRequestMessage := messages.GenericMessage{
+			TurnID: turnID,
+			Type:   "accept_request",
+			Body: messages.Body{
+				Message: "sending accept request",
+				Proposal: proposal.Proposal{
+					Pid: config.CONF.PID,
+					Seq: seq,
+					V:   v,
+				},
+				Learnt: "",
+				Epoch:  epochID,
+			},
+		}
+
+		go sendTaggedRequest(session, node, "/acceptor/receive_accept", ch, acceptRequestMessage)
+	}
+	return ch
+}
+
+// SendPrepare sends a prepare request to all the acceptors in the network, the values of the prepare request are to be provided by the user (except @v which can remain empty).
+// @seq has to be higher than every sequence number used by this node, for any turn id (see 'ballot.go'): otherwise nothing is sent and the next usable one is returned to the user.
+func SendPrepare(turnID int, seq int, v string, optimization bool) (messageToUser string) {
+
+	q := quorumsForTurn(turnID)
+	NODES := pickNodes(q, optimization, q.IsPhase1Quorum)
+
+	log.Printf("[PROPOSER] -> Starting prepare request; turn_id: %d, seq: %d, v: %s.", turnID, seq, v)
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+
+	currentV := queries.GetLearntValue(turnID)
+	if currentV != "" {
+		log.Printf("[PROPOSER] -> Value '%s' has already been learnt for turn_id: %d. Dropping prepare request.", currentV, turnID)
+		return fmt.Sprintf("Value for turn_id: %d is already known: %s. Dropping prepare request.", turnID, currentV)
+	}
+	if !membershipKnownFor(turnID) {
+		log.Printf("[PROPOSER] -> The membership in effect for turn_id: %d is not known yet. Dropping prepare request.", turnID)
+		return fmt.Sprintf("The membership in effect for turn_id: %d is not known yet, every turn id up to %d turn ids before it has to be learnt first. Dropping prepare request.", turnID, config.CONF.MEMBERSHIP_DELAY)
+	}
+
+	// a sequence number is never used twice by this node, see 'ballot.go'
+	err := useSeq(seq)
+	if err == ErrSeqUsed {
+		log.Printf("[PROPOSER] -> Sequence number %d is not higher than the ones already used by this node. Dropping prepare request.", seq)
+		return fmt.Sprintf("Sequence number %d is not higher than %d, the highest one used by this node for any turn id. Please retry with a higher prepare request as follows:"+
+			" /proposer/send_prepare?turn_id=%d&seq=%d&v=%s", seq, HighestSeq(), turnID, freshSeq(seq), v)
+	} else if err != nil {
+		return fmt.Sprintf("Could not store the sequence number: %v", err)
+	}
+
+	ch := broadcastPrepare(session, NODES, turnID, seq, v)
 
 	// counting "promise" responses received in the channel
-	messageToUser, err := countAgreements(ch, turnID, seq, v)
+	messageToUser, err = countAgreements(ch, turnID, seq, v)
 	if err != nil {
 		log.Printf("Undexpected behavior in SendPrepare: %v", err)
 	}
@@ -379,51 +503,24 @@ func SendPrepare(turnID int, seq int, v string, optimization bool) (messageToUse
 // Note that when the node is working in AUTOMATIC mode, this function is called automatically after reaching the quorum for the prepare request.
 func SendAccept(turnID int, seq int, v string, optimization bool) (messageToUser string) {
 
-	var NODES []string
-
-	if optimization {
-		idx := rand.Perm(len(config.CONF.NODES))
-		for i := 0; i < config.CONF.QUORUM; i++ {
-			NODES = append(NODES, config.CONF.NODES[idx[i]])
-		}
-	} else {
-		NODES = config.CONF.NODES
-	}
-
+	q := quorumsForTurn(turnID)
+	NODES := pickNodes(q, optimization, q.IsPhase2Quorum)
 
 	log.Printf("[PROPOSER] -> Starting accept request; turn_id: %d, seq: %d, v: %s.", turnID, seq, v)
 	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
-	ch := make(chan []byte, len(NODES))
 
 	currentV := queries.GetLearntValue(turnID)
 	if currentV != "" {
 		log.Printf("[PROPOSER] -> Value '%s' has already been learnt for turn_id: %d. Dropping accept request.", currentV, turnID)
 		return fmt.Sprintf("Value for turn_id: %d is already known: %s. Dropping prepare request.", turnID, currentV)
 	}
-
-	// send a request for each node
-	// responses are saved in ch
-	for _, node := range NODES {
-		url := node + "/acceptor/receive_accept"
-
-		// building accept message
-		acceptRequestMessage := messages.GenericMessage{
-			TurnID: turnID,
-			Type:   "accept_request",
-			Body: messages.Body{
-				Message: "sending accept request",
-				Proposal: proposal.Proposal{
-					Pid: config.CONF.PID,
-					Seq: seq,
-					V:   v,
-				},
-				Learnt: "",
-			},
-		}
-
-		go sendPartialRequest(session, url, ch, acceptRequestMessage)
+	if !membershipKnownFor(turnID) {
+		log.Printf("[PROPOSER] -> The membership in effect for turn_id: %d is not known yet. Dropping accept request.", turnID)
+		return fmt.Sprintf("The membership in effect for turn_id: %d is not known yet, every turn id up to %d turn ids before it has to be learnt first. Dropping accept request.", turnID, config.CONF.MEMBERSHIP_DELAY)
 	}
 
+	ch := broadcastAccept(session, NODES, turnID, seq, v)
+
 	// counting "accept" responses received in the channel
 	messageToUser, err := countApprovals(ch, turnID, seq, v)
 	if err != nil {
@@ -433,8 +530,9 @@ func SendAccept(turnID int, seq int, v string, optimization bool) (messageToUser
 	return messageToUser
 }
 
-// SendLearn sends an learn request to all the acceptors in the network, the value of the learn request are the values agreed upon during the accept request.
+// SendLearn sends an learn request to all the learners in the network, the value of the learn request are the values agreed upon during the accept request.
 // Note that when the node is working in AUTOMATIC mode, this function is called automatically after reaching the quorum for the accept request.
+// The learners which do not acknowledge the request are sent it again later, see 'delivery.go'.
 func SendLearn(turnID int, v string) string {
 
 	/*
@@ -452,12 +550,23 @@ func SendLearn(turnID int, v string) string {
 
 	log.Printf("[PROPOSER] -> Starting learn request; turn_id: %d, v: %s.", turnID, v)
 	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
-	ch := make(chan []byte, len(config.CONF.NODES))
+	learners := learnersOf(turnID)
+	trackLearns(learners, turnID, v)
+	go collectLearnAcks(broadcastLearn(session, learners, turnID, v), turnID)
+
+	messageToUser := "Sending learn requests; the learners which do not acknowledge them will be retried, see /learner/pending."
+
+	return messageToUser
+
+}
+
+// broadcastLearn sends the learn request (@turnID, @v) to each node in @NODES, the responses are collected in the returned channel.
+func broadcastLearn(session *http.Client, NODES []string, turnID int, v string) chan nodeResponse {
+	ch := make(chan nodeResponse, len(NODES))
 
 	// send a request for each node
 	// responses are saved in ch
-	for _, node := range config.CONF.NODES {
-		url := node + fmt.Sprintf("/learner/receive_learn")
+	for _, node := range NODES {
 
 		// building learn message
 		learnRequestMessage := messages.GenericMessage{
@@ -474,11 +583,37 @@ func SendLearn(turnID int, v string) string {
 			},
 		}
 
-		go sendPartialRequest(session, url, ch, learnRequestMessage)
+		go sendTaggedRequest(session, node, "/learner/receive_learn", ch, learnRequestMessage)
 	}
+	return ch
+}
 
-	messageToUser := "Sending learn requests; ignoring responses."
-
-	return messageToUser
-
+// learnQuorum sends the learn request (@turnID, @v) to all the learners in the network and waits for their responses.
+// It returns the learners which have learnt @v, the ones which already had it included. The other learners are sent the request again later, see 'delivery.go'.
+func learnQuorum(session *http.Client, turnID int, v string) (learners []string) {
+	targets := learnersOf(turnID)
+	trackLearns(targets, turnID, v)
+	ch := broadcastLearn(session, targets, turnID, v)
+
+	var ackers []string
+	for i := 0; i < cap(ch); i++ {
+		response := <-ch
+		if response.data == nil {
+			continue
+		}
+		responseMessage := messages.GenericMessage{}
+		err := json.Unmarshal(response.data, &responseMessage)
+		if err != nil {
+			log.Print(err.Error())
+			continue
+		}
+		if ResponseHasLearntValue(responseMessage) {
+			ackers = append(ackers, response.node)
+		}
+		if responseMessage.Body.Learnt == v {
+			learners = append(learners, response.node)
+		}
+	}
+	ackLearns(ackers, turnID)
+	return learners
 }
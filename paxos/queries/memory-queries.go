@@ -0,0 +1,380 @@
+// Package queries implements all the queries needed by this specific implementation of the Paxos algorithm.
+package queries
+
+import (
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryStore is the Store keeping everything in memory. Its contents are lost when the node stops,
+// it's meant for tests and for running the protocol without a database file.
+type memoryStore struct {
+	mu        sync.Mutex
+	proposals map[int]proposal.AcceptorState
+	learnt    map[int]string
+	snapshot  messages.Snapshot
+	rp        messages.RangePromise
+	meta      map[string]string
+}
+
+func init() {
+	Register("memory", func() Store { return newMemoryStore() })
+}
+
+// newMemoryStore returns an empty memoryStore.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		proposals: make(map[int]proposal.AcceptorState),
+		learnt:    make(map[int]string),
+		snapshot:  messages.Snapshot{Values: make(map[int]string)},
+		meta:      make(map[string]string),
+	}
+}
+
+// PrepareDBConn does nothing, there is no connection to open.
+func (s *memoryStore) PrepareDBConn() error {
+	return nil
+}
+
+// InitDatabase does nothing, the maps are created together with the store.
+func (s *memoryStore) InitDatabase() error {
+	return nil
+}
+
+// sortedKeys returns the keys of @m in ascending order.
+func sortedKeys(m map[int]bool) []int {
+	var keys []int
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+/*
+# ========================================================= #
+#                     PROPOSAL QUERIES                      #
+# ========================================================= #
+*/
+
+// GetProposal returns the proposal stored for @turnID, see GetProposal in 'queries.go'.
+func (s *memoryStore) GetProposal(turnID int) (proposal.AcceptorState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.proposals[turnID]
+	if !ok {
+		log.Printf("[QUERIES] -> No proposal found for turn id: %d; returning an empty proposal.", turnID)
+	}
+	return state, ok
+}
+
+// GetAllProposals returns all the stored proposals ordered by turn id.
+func (s *memoryStore) GetAllProposals() []messages.ProposalWithTid {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m []messages.ProposalWithTid
+	for _, turnID := range sortedKeys(s.proposalsTurnID()) {
+		state := s.proposals[turnID]
+		m = append(m, messages.ProposalWithTid{TurnID: turnID, Proposal: state.Accepted, Promised: state.Promised})
+	}
+	return m
+}
+
+// SetProposal inserts/updates the proposal stored for @turnID, see SetProposal in 'queries.go'.
+func (s *memoryStore) SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.proposals[turnID] = applyProposal(s.proposals[turnID], p, isAcceptRequest)
+	return nil
+}
+
+// ResetProposal deletes the proposal stored for @turnID.
+func (s *memoryStore) ResetProposal(turnID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.proposals, turnID)
+	return nil
+}
+
+// ResetAllProposals deletes all the stored proposals.
+func (s *memoryStore) ResetAllProposals() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.proposals = make(map[int]proposal.AcceptorState)
+	return nil
+}
+
+// proposalsTurnID returns the set of the turn ids having a proposal. The caller must hold s.mu.
+func (s *memoryStore) proposalsTurnID() map[int]bool {
+	proposalsTurnID := make(map[int]bool)
+	for turnID := range s.proposals {
+		proposalsTurnID[turnID] = true
+	}
+	return proposalsTurnID
+}
+
+// GetProposalsTurnID returns the set of the turn ids having a proposal.
+func (s *memoryStore) GetProposalsTurnID() *map[int]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposalsTurnID := s.proposalsTurnID()
+	return &proposalsTurnID
+}
+
+// GetDanglingProposals returns the proposals whose turn id has no learnt value.
+func (s *memoryStore) GetDanglingProposals() *map[int]proposal.AcceptorState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	danglingProposals := make(map[int]proposal.AcceptorState)
+	for turnID, state := range s.proposals {
+		if _, ok := s.learnt[turnID]; !ok {
+			danglingProposals[turnID] = state
+		}
+	}
+	return &danglingProposals
+}
+
+// PromiseIfHigher promises @p for @turnID only if it is strictly higher than the promised number, holding s.mu for the whole operation.
+func (s *memoryStore) PromiseIfHigher(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, false)
+}
+
+// AcceptIfNotLower accepts @p for @turnID only if it is higher than or equal to the promised number, holding s.mu for the whole operation.
+func (s *memoryStore) AcceptIfNotLower(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, true)
+}
+
+// compareAndSetProposal implements both PromiseIfHigher and AcceptIfNotLower.
+func (s *memoryStore) compareAndSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (proposal.AcceptorState, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldState, ok := s.proposals[turnID]
+	if ok && !proposalWins(p, oldState.Promised, isAcceptRequest) {
+		return oldState, ok, false, nil
+	}
+
+	s.proposals[turnID] = applyProposal(oldState, p, isAcceptRequest)
+	return oldState, ok, true, nil
+}
+
+/*
+# ========================================================= #
+#                   LEARNT VALUE QUERIES                    #
+# ========================================================= #
+*/
+
+// GetLearntValue returns the value learnt for @turnID, or "" when nothing has been learnt.
+func (s *memoryStore) GetLearntValue(turnID int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.learnt[turnID]
+	if !ok {
+		log.Printf("[QUERIES] -> No learnt value found for turn_id: %d; keep going.", turnID)
+	}
+	return v
+}
+
+// SetLearntValue inserts the value learnt for @turnID, see SetLearntValue in 'queries.go'.
+func (s *memoryStore) SetLearntValue(turnID int, v string) error {
+	s.mu.Lock()
+	currentV, ok := s.learnt[turnID]
+	if ok {
+		s.mu.Unlock()
+		if currentV != v {
+			return &LearntConflictError{TurnID: turnID, Current: currentV, Proposed: v}
+		}
+		return nil
+	}
+	s.learnt[turnID] = v
+	howMany := len(s.learnt)
+	s.mu.Unlock()
+
+	notifyListener(howMany)
+	return nil
+}
+
+// OverrideLearntValue inserts/updates the value learnt for @turnID.
+func (s *memoryStore) OverrideLearntValue(turnID int, v string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.learnt[turnID] = v
+	return nil
+}
+
+// ResetLearntValue deletes the value learnt for @turnID.
+func (s *memoryStore) ResetLearntValue(turnID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.learnt, turnID)
+	return nil
+}
+
+// ResetAllLearntValues deletes all the learnt values.
+func (s *memoryStore) ResetAllLearntValues() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.learnt = make(map[int]string)
+	return nil
+}
+
+// learntValuesTurnID returns the set of the turn ids having a learnt value. The caller must hold s.mu.
+func (s *memoryStore) learntValuesTurnID() map[int]bool {
+	learntValuesTurnID := make(map[int]bool)
+	for turnID := range s.learnt {
+		learntValuesTurnID[turnID] = true
+	}
+	return learntValuesTurnID
+}
+
+// GetAllLearntValues returns all the learnt values ordered by turn id.
+func (s *memoryStore) GetAllLearntValues() []messages.LearntWithTid {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m []messages.LearntWithTid
+	for _, turnID := range sortedKeys(s.learntValuesTurnID()) {
+		m = append(m, messages.LearntWithTid{TurnID: turnID, Learnt: s.learnt[turnID]})
+	}
+	return m
+}
+
+// GetLastTurnID returns the highest turn id having a learnt value, 0 if none.
+func (s *memoryStore) GetLastTurnID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastID int
+	for turnID := range s.learnt {
+		if turnID > lastID {
+			lastID = turnID
+		}
+	}
+	return lastID
+}
+
+// GetLearntValuesTurnID returns the set of the turn ids having a learnt value.
+func (s *memoryStore) GetLearntValuesTurnID() *map[int]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	learntValuesTurnID := s.learntValuesTurnID()
+	return &learntValuesTurnID
+}
+
+/*
+# ========================================================= #
+#                     SNAPSHOT QUERIES                      #
+# ========================================================= #
+*/
+
+// GetSnapshot returns the stored snapshot.
+func (s *memoryStore) GetSnapshot() (messages.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshot, nil
+}
+
+// Compact stores @snap and deletes the learnt values and the proposals up to its checkpoint.
+func (s *memoryStore) Compact(snap messages.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot = snap
+	for turnID := range s.learnt {
+		if turnID <= snap.LastTurnID {
+			delete(s.learnt, turnID)
+		}
+	}
+	for turnID := range s.proposals {
+		if turnID <= snap.LastTurnID {
+			delete(s.proposals, turnID)
+		}
+	}
+	return nil
+}
+
+/*
+# ========================================================= #
+#                   RANGE PROMISE QUERIES                   #
+# ========================================================= #
+*/
+
+// GetRangePromise returns the stored range promise.
+func (s *memoryStore) GetRangePromise() (messages.RangePromise, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rp, nil
+}
+
+// SetRangePromise stores @rp, replacing the previous one.
+func (s *memoryStore) SetRangePromise(rp messages.RangePromise) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rp = rp
+	return nil
+}
+
+/*
+# ========================================================= #
+#                       META QUERIES                        #
+# ========================================================= #
+*/
+
+// GetMeta returns the value stored under @key, an empty string if none has been stored.
+func (s *memoryStore) GetMeta(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.meta[key], nil
+}
+
+// SetMeta stores @value under @key, replacing the previous one.
+func (s *memoryStore) SetMeta(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.meta[key] = value
+	return nil
+}
+
+// DeleteMeta deletes the value stored under @key.
+func (s *memoryStore) DeleteMeta(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.meta, key)
+	return nil
+}
+
+// ListMeta returns the values stored under the keys starting with @prefix.
+func (s *memoryStore) ListMeta(prefix string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string]string)
+	for key, value := range s.meta {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
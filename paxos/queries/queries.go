@@ -1,24 +1,176 @@
 // Package queries implements all the queries needed by this specific implementation of the Paxos algorithm.
+// Queries are served by a storage backend (a Store); the backend is chosen at startup through the 'db_type' field of the '.yaml' file.
 package queries
 
 import (
+	"fmt"
 	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// PrepareDBConn initializes the DB
-func PrepareDBConn() {
-	if config.CONF.DB_TYPE == "sqlite" {
-		SQLitePrepareDBConn()
-	} else {
-		RedisPrepareDBConn()
+// Store describes the operations that a storage backend must provide to the acceptor, the learner and the seeker.
+// Every backend has to honour the semantics documented on the package level functions wrapping these methods.
+type Store interface {
+	PrepareDBConn() error // PrepareDBConn opens the connection to the underlying storage.
+	InitDatabase() error  // InitDatabase creates tables (or whatever the backend needs) when they are missing.
+
+	GetProposal(turnID int) (proposal.AcceptorState, bool)
+	GetAllProposals() []messages.ProposalWithTid
+	SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) error
+	ResetProposal(turnID int) error
+	ResetAllProposals() error
+	GetProposalsTurnID() *map[int]bool
+	GetDanglingProposals() *map[int]proposal.AcceptorState
+	PromiseIfHigher(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error)
+	AcceptIfNotLower(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error)
+
+	GetLearntValue(turnID int) string
+	SetLearntValue(turnID int, v string) error
+	OverrideLearntValue(turnID int, v string) error // OverrideLearntValue writes @v even if a different value has been learnt, see OverrideLearntValue in 'queries.go'.
+	ResetLearntValue(turnID int) error
+	ResetAllLearntValues() error
+	GetAllLearntValues() []messages.LearntWithTid
+	GetLastTurnID() int
+	GetLearntValuesTurnID() *map[int]bool
+
+	GetSnapshot() (messages.Snapshot, error)
+	Compact(snap messages.Snapshot) error // Compact stores @snap and deletes the learnt values and the proposals up to its checkpoint, atomically.
+
+	GetRangePromise() (messages.RangePromise, error)
+	SetRangePromise(rp messages.RangePromise) error // SetRangePromise replaces the stored range promise, see PromiseRange in 'queries.go'.
+
+	GetMeta(key string) (string, error)                // GetMeta returns the value stored under @key, an empty string if none has been stored.
+	SetMeta(key string, value string) error            // SetMeta stores @value under @key, replacing the previous one. It must be durable when it returns.
+	DeleteMeta(key string) error                       // DeleteMeta deletes the value stored under @key, if any. It must be durable when it returns.
+	ListMeta(prefix string) (map[string]string, error) // ListMeta returns the values stored under the keys starting with @prefix.
+}
+
+// Checker is implemented by the backends able to check, and repair, the consistency of their own data structures.
+// It's optional: backends whose structures cannot go out of step (e.g. thanks to transactions) do not implement it.
+type Checker interface {
+	Fsck(repair bool) (FsckReport, error)
+}
+
+// FsckProblem describes a single inconsistency found by a Checker.
+type FsckProblem struct {
+	Kind     string `json:"kind"` // Kind is a short identifier of the problem, e.g. "orphaned_key".
+	Key      string `json:"key"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"` // Repaired is false when repairing was not requested or when the problem needs a human being.
+}
+
+// FsckReport is the outcome of a consistency check.
+type FsckReport struct {
+	DBType   string        `json:"db_type"`
+	Checked  int           `json:"checked"` // Checked is the number of keys (or rows) inspected.
+	Problems []FsckProblem `json:"problems"`
+}
+
+// registry maps every known 'db_type' to the function building the respective Store.
+var registry = make(map[string]func() Store)
+
+// store is the backend currently serving the queries, it is set by PrepareDBConn.
+var store Store
+
+// Register makes a storage backend available under the name @dbType.
+// Backends register themselves in their own init function; registering the same name twice is a programming error.
+func Register(dbType string, newStore func() Store) {
+	if _, exists := registry[dbType]; exists {
+		panic(fmt.Sprintf("queries: storage backend %q registered twice", dbType))
+	}
+	registry[dbType] = newStore
+}
+
+// RegisteredTypes returns the sorted list of the names under which a storage backend has been registered.
+func RegisteredTypes() []string {
+	var dbTypes []string
+	for dbType := range registry {
+		dbTypes = append(dbTypes, dbType)
+	}
+	sort.Strings(dbTypes)
+	return dbTypes
+}
+
+// NewStore builds a new, not yet connected, Store for the backend named @dbType.
+// An error is returned if no backend has been registered under that name.
+func NewStore(dbType string) (Store, error) {
+	newStore, ok := registry[dbType]
+	if !ok {
+		return nil, fmt.Errorf("unknown db_type %q, valid values are: %s", dbType, strings.Join(RegisteredTypes(), ", "))
+	}
+	return newStore(), nil
+}
+
+// PrepareDBConn selects the backend named @dbType and opens its connection.
+// Unknown db types are rejected, no backend is picked by default.
+func PrepareDBConn(dbType string) error {
+	s, err := NewStore(dbType)
+	if err != nil {
+		return err
+	}
+
+	err = s.PrepareDBConn()
+	if err != nil {
+		return err
+	}
+
+	store = s
+	return nil
+}
+
+// InitDatabase creates tables and columns when the selected backend needs them, then loads the snapshot.
+func InitDatabase() error {
+	err := store.InitDatabase()
+	if err != nil {
+		return err
+	}
+
+	snap, err := store.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	setSnapshot(snap)
+
+	rp, err := store.GetRangePromise()
+	if err != nil {
+		return err
+	}
+	rangePromise.Lock()
+	rangePromise.rp = rp
+	rangePromise.Unlock()
+	return nil
+}
+
+// Fsck checks the consistency of the selected backend and, if @repair is true, fixes what can be fixed safely.
+// An error is returned if the backend has no consistency checker.
+func Fsck(repair bool) (FsckReport, error) {
+	checker, ok := store.(Checker)
+	if !ok {
+		return FsckReport{}, fmt.Errorf("the %s backend has no consistency checker", config.CONF.DB_TYPE)
 	}
+	return checker.Fsck(repair)
 }
 
-// InitDatabase creates tables and columns. Only used for SQLite
-func InitDatabase() {
-	SQLiteInitDatabase()
+// notifyListener lets the listener know that all the expected turn ids (NUMBER_OF_TIDS) have been learnt.
+// It is needed for testing and benchmarking purposes, @howMany is the current number of learnt values.
+func notifyListener(howMany int) {
+	if howMany == config.CONF.NUMBER_OF_TIDS {
+		now := time.Now()
+		sec := now.Unix()
+		go func() {
+			_, err := http.Get(fmt.Sprintf("%s/timer?nid=%d&timestamp=%d&how_many=%d", config.CONF.LISTENER_IP, config.CONF.PID, sec, howMany))
+			if err != nil {
+				log.Printf("Errore nella richiesta di salvataggio del timer: %v", err.Error())
+			}
+		}()
+	}
 }
 
 /*
@@ -28,77 +180,167 @@ func InitDatabase() {
 */
 
 // GetProposal returns the entry of the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If the wanted proposal does not exist OR is not valid (i.e. pid and seq are 0)
-// an empty proposal is returned together with a false boolean value.
+// If the wanted entry does not exist OR is not valid (i.e. the promised pid and seq are 0)
+// an empty state is returned together with a false boolean value.
 // Please note that proposals with @pid or @seq = 0 should not exist, the user should not issue such values.
-// If the wanted proposal exists AND is valid, it will be returned together with a positive boolean value.
-// If the field 'v' is NULL, @v will be assigned the empty string "".
-// The entry will be mapped onto a proposal.Proposal object.
-func GetProposal(turnID int) (proposal.Proposal, bool) {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetProposal(turnID)
-	} else {
-		return RedisGetProposal(turnID)
-	}
+// If the wanted entry exists AND is valid, it will be returned together with a positive boolean value.
+// If nothing has been accepted yet, the 'Accepted' proposal of the state is null.
+// The entry will be mapped onto a proposal.AcceptorState object.
+func GetProposal(turnID int) (proposal.AcceptorState, bool) {
+	return store.GetProposal(turnID)
 }
 
 // GetAllProposals returns a list of all the entries stored in the 'proposal' table.
 // Each entry is mapped onto a messages.ProposalWithTid object.
 func GetAllProposals() []messages.ProposalWithTid {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetAllProposals()
-	} else {
-		return RedisGetAllProposals()
-	}
+	return store.GetAllProposals()
 }
 
 // SetProposal inserts/updates an entry in the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If isAcceptRequest is false, only the value "n" (i.e. Pid and Seq) will be overwritten, while "v" will be left untouched.
-// If isAcceptRequest is true, both "v" and "n" will be overwritten by the value requested.
+// If isAcceptRequest is false, only the promised number "n" (i.e. Pid and Seq) will be overwritten, while the accepted proposal will be left untouched.
+// If isAcceptRequest is true, both the promised number and the accepted proposal ("n" and "v") will be overwritten by the value requested.
+// No comparison is performed, see PromiseIfHigher and AcceptIfNotLower for that.
 func SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteSetProposal(turnID, p, isAcceptRequest)
-	} else {
-		return RedisSetProposal(turnID, p, isAcceptRequest)
-	}
+	return store.SetProposal(turnID, p, isAcceptRequest)
 }
 
 // ResetProposal deletes the entry from the 'proposal' table where the field 'turn_id' is equal to @turnID.
 func ResetProposal(turnID int) error {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteResetProposal(turnID)
-	} else {
-		return RedisResetProposal(turnID)
-	}
+	return store.ResetProposal(turnID)
 }
 
 // ResetAllProposals empties the `proposal` table.
 func ResetAllProposals() error {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteResetAllProposals()
-	} else {
-		return RedisResetAllProposals()
-	}
+	return store.ResetAllProposals()
 }
 
 // GetProposalsTurnID is a map used as a set, the keys are the turnIDs of the proposals we know.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
 func GetProposalsTurnID() *map[int]bool {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetProposalsTurnID()
-	} else {
-		return RedisGetProposalsTurnID()
-	}
+	return store.GetProposalsTurnID()
 }
 
 // GetDanglingProposals returns a map of the proposals found in the 'proposal' table whose turn ID does not have an entry 'learnt' table.
-// The map uses the turn ID as the key and a Proposal object as the value.
-func GetDanglingProposals() *map[int]proposal.Proposal {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetDanglingProposals()
-	} else {
-		return RedisGetDanglingProposals()
+// The map uses the turn ID as the key and an AcceptorState object as the value.
+func GetDanglingProposals() *map[int]proposal.AcceptorState {
+	return store.GetDanglingProposals()
+}
+
+// PromiseIfHigher atomically compares @p against the promised number stored for @turnID and, only when @p is STRICTLY higher
+// (or nothing is stored), stores it as the new promised number the same way SetProposal does for prepare requests.
+// The accepted proposal is never touched by this function.
+// The state as it was before the call is returned together with its validity (see GetProposal)
+// and with a boolean telling whether @p has been promised.
+// Concurrent calls for the same turn id are linearizable, no other promise or accept can happen between the comparison and the write.
+// Turn ids covered by the range promise (see PromiseRange) also need @p to be strictly higher than the range promise.
+func PromiseIfHigher(turnID int, p proposal.Proposal) (oldState proposal.AcceptorState, ok bool, promised bool, err error) {
+	rangePromise.RLock()
+	defer rangePromise.RUnlock()
+
+	if floor, covered := rangeFloor(turnID); covered && !p.IsGreaterThan(&floor) {
+		oldState, ok = store.GetProposal(turnID)
+		oldState.Promised = floor
+		return oldState, ok, false, nil
 	}
+	return store.PromiseIfHigher(turnID, p)
+}
+
+// AcceptIfNotLower atomically compares @p against the promised number stored for @turnID and, only when @p is higher than
+// or equal to it (or nothing is stored), stores it as both the promised number and the accepted proposal, the same way SetProposal does for accept requests.
+// The state as it was before the call is returned together with its validity (see GetProposal)
+// and with a boolean telling whether @p has been accepted.
+// Concurrent calls for the same turn id are linearizable, no other promise or accept can happen between the comparison and the write.
+// Turn ids covered by the range promise (see PromiseRange) also need @p to be higher than or equal to the range promise.
+func AcceptIfNotLower(turnID int, p proposal.Proposal) (oldState proposal.AcceptorState, ok bool, accepted bool, err error) {
+	rangePromise.RLock()
+	defer rangePromise.RUnlock()
+
+	if floor, covered := rangeFloor(turnID); covered && p.IsLowerThan(&floor) {
+		oldState, ok = store.GetProposal(turnID)
+		oldState.Promised = floor
+		return oldState, ok, false, nil
+	}
+	return store.AcceptIfNotLower(turnID, p)
+}
+
+// proposalWins tells whether @newP can be promised (or accepted) by an acceptor that has already promised @promised.
+// Prepare requests need @newP to be strictly higher, accept requests are also fine with an equal proposal number.
+func proposalWins(newP proposal.Proposal, promised proposal.Proposal, isAcceptRequest bool) bool {
+	if isAcceptRequest {
+		return newP.IsGEThan(&promised)
+	}
+	return newP.IsGreaterThan(&promised)
+}
+
+// applyProposal returns the state obtained by storing @p on top of @state the way SetProposal does.
+func applyProposal(state proposal.AcceptorState, p proposal.Proposal, isAcceptRequest bool) proposal.AcceptorState {
+	state.Promised = proposal.Proposal{Pid: p.Pid, Seq: p.Seq}
+	if isAcceptRequest {
+		state.Accepted = p
+	}
+	return state
+}
+
+/*
+# ========================================================= #
+#                   RANGE PROMISE QUERIES                   #
+# ========================================================= #
+*/
+
+// rangePromise caches the range promise stored by the backend, it's loaded by InitDatabase and replaced by PromiseRange.
+// PromiseIfHigher and AcceptIfNotLower hold the read lock, so that no promise or accept can slip in while a range is being promised.
+var rangePromise struct {
+	sync.RWMutex
+	rp messages.RangePromise
+}
+
+// rangeFloor returns the range promise when it covers @turnID, i.e. the lowest proposal number that can still be accepted for @turnID.
+// The caller must hold rangePromise (read) lock.
+func rangeFloor(turnID int) (proposal.Proposal, bool) {
+	rp := rangePromise.rp
+	if rp.Promised.Pid == 0 && rp.Promised.Seq == 0 {
+		return proposal.Proposal{}, false
+	}
+	return rp.Promised, turnID >= rp.FromTurnID
+}
+
+// GetRangePromise returns the current range promise, its Promised field is null when no range has ever been promised.
+func GetRangePromise() messages.RangePromise {
+	rangePromise.RLock()
+	defer rangePromise.RUnlock()
+	return rangePromise.rp
+}
+
+// PromiseRange promises @p for every turn id higher than or equal to @fromTurnID, as requested by a would-be leader.
+// @p is promised only when it's STRICTLY higher than the current range promise and than every promise already made for the covered turn ids,
+// otherwise the highest of those is returned as the number to exceed. A range never shrinks: the new range starts at the lowest of the two first turn ids.
+// The returned range promise is the one in use when the function returns.
+func PromiseRange(fromTurnID int, p proposal.Proposal) (rp messages.RangePromise, promised bool, err error) {
+	rangePromise.Lock()
+	defer rangePromise.Unlock()
+
+	rp = rangePromise.rp
+	if !p.IsGreaterThan(&rp.Promised) {
+		return rp, false, nil
+	}
+
+	for _, prop := range store.GetAllProposals() {
+		if prop.TurnID >= fromTurnID && prop.Promised.IsGreaterThan(&p) {
+			return messages.RangePromise{FromTurnID: prop.TurnID, Promised: prop.Promised}, false, nil
+		}
+	}
+
+	newRP := messages.RangePromise{FromTurnID: fromTurnID, Promised: proposal.Proposal{Pid: p.Pid, Seq: p.Seq}}
+	if (rp.Promised.Pid != 0 || rp.Promised.Seq != 0) && rp.FromTurnID < fromTurnID {
+		newRP.FromTurnID = rp.FromTurnID
+	}
+
+	err = store.SetRangePromise(newRP)
+	if err != nil {
+		return rp, false, err
+	}
+	rangePromise.rp = newRP
+	return newRP, true, nil
 }
 
 /*
@@ -108,70 +350,221 @@ func GetDanglingProposals() *map[int]proposal.Proposal {
 */
 
 // GetLearntValue returns the 'v' field of the 'learnt' table where the field 'turn_id' is equal to @turnID.
+// Turn ids up to the checkpoint are served by the snapshot.
 // If no value has been learnt for the requested @turnID, an empty string is returned.
 func GetLearntValue(turnID int) string {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetLearntValue(turnID)
-	} else {
-		return RedisGetLearntValue(turnID)
+	if v, compacted := snapshotValue(turnID); compacted {
+		return v
 	}
+	return store.GetLearntValue(turnID)
 }
 
-// SetLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
-// If the requested @turnID does not exist, a new entry is created.
-// If the learnt value for the requested @turnID is already present, it will be overwritten. (why?)
+// LearntConflictError is returned when trying to learn, for a turn id, a value different from the one already learnt.
+// Learnt values are chosen by the network and can never change, such an error means some node (or user) is not following the algorithm.
+type LearntConflictError struct {
+	TurnID   int    `json:"turn_id"`
+	Current  string `json:"current"`  // Current is the value already learnt for TurnID.
+	Proposed string `json:"proposed"` // Proposed is the value that has been refused.
+}
+
+func (e *LearntConflictError) Error() string {
+	return fmt.Sprintf("turn id %d has already learnt '%s', refusing to learn '%s'", e.TurnID, e.Current, e.Proposed)
+}
+
+// SetLearntValue inserts an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
+// Learnt values are written once: if the requested @turnID does not exist a new entry is created,
+// if the same value has already been learnt nothing happens and no error is returned,
+// if a different value has already been learnt nothing is written and a *LearntConflictError is returned.
+// Checking and writing happen atomically. See OverrideLearntValue to replace a learnt value anyway.
 func SetLearntValue(turnID int, v string) (err error) {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteSetLearntValue(turnID, v)
-	} else {
-		return RedisSetLearntValue(turnID, v)
+	// holding the read lock, a checkpoint cannot compact @turnID while it's being written
+	snapshot.RLock()
+	defer snapshot.RUnlock()
+
+	if turnID <= snapshot.snap.LastTurnID {
+		currentV := snapshot.snap.Values[turnID]
+		if currentV != v {
+			return &LearntConflictError{TurnID: turnID, Current: currentV, Proposed: v}
+		}
+		return nil
 	}
+	return store.SetLearntValue(turnID, v)
+}
+
+// OverrideLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID,
+// overwriting any value already learnt. It is meant for administrators repairing a node by hand, never for the algorithm itself.
+// A @reason must be given, every override is logged with the replaced value so that it can be audited later on.
+func OverrideLearntValue(turnID int, v string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("a reason is needed to override the learnt value of turn id %d", turnID)
+	}
+	if _, compacted := snapshotValue(turnID); compacted {
+		return fmt.Errorf("turn id %d has been compacted into the snapshot and cannot be overridden", turnID)
+	}
+
+	oldV := store.GetLearntValue(turnID)
+	err := store.OverrideLearntValue(turnID, v)
+	if err != nil {
+		log.Printf("[AUDIT] -> Override of turn id %d from '%s' to '%s' failed (reason: %s): %v", turnID, oldV, v, reason, err)
+		return err
+	}
+	log.Printf("[AUDIT] -> Learnt value of turn id %d overridden from '%s' to '%s' (reason: %s).", turnID, oldV, v, reason)
+	return nil
 }
 
 // ResetLearntValue deletes the entry from the 'learnt' table where the field 'turn_id' is equal to @turnID.
 func ResetLearntValue(turnID int) error {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteResetLearntValue(turnID)
-	} else {
-		return RedisResetLearntValue(turnID)
-	}
+	return store.ResetLearntValue(turnID)
 }
 
 // ResetAllLearntValues empties the `learnt` table.
 func ResetAllLearntValues() error {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteResetAllLearntValues()
-	} else {
-		return RedisResetAllLearntValues()
-	}
+	return store.ResetAllLearntValues()
 }
 
 // GetAllLearntValues returns a list of all the entries stored in the 'learnt' table.
-// Each entry is mapped onto a LearntWithTid object.
+// Each entry is mapped onto a LearntWithTid object. Values compacted into the snapshot are not listed, see GetSnapshot.
 func GetAllLearntValues() []messages.LearntWithTid {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetAllLearntValues()
-	} else {
-		return RedisGetAllLearntValues()
-	}
+	return store.GetAllLearntValues()
 }
 
-// GetLastTurnID returns the highest turn ID found in the `learnt` table.
-// 0 is returned if table is empty.
+// GetLastTurnID returns the highest turn ID found in the `learnt` table or in the snapshot.
+// 0 is returned if both are empty.
 func GetLastTurnID() int {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetLastTurnID()
-	} else {
-		return RedisGetLastTurnID()
+	lastID := store.GetLastTurnID()
+	if checkpoint := GetCheckpoint(); checkpoint > lastID {
+		return checkpoint
 	}
+	return lastID
 }
 
-// GetLearntValuesTurnID is a map used as a set, the keys are the turnIDs of the learnt values.
+// GetLearntValuesTurnID is a map used as a set, the keys are the turnIDs of the learnt values, the ones in the snapshot included.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
 func GetLearntValuesTurnID() *map[int]bool {
-	if config.CONF.DB_TYPE == "sqlite" {
-		return SQLiteGetLearntValuesTurnID()
-	} else {
-		return RedisGetLearntValuesTurnID()
+	learntValuesTurnID := store.GetLearntValuesTurnID()
+	for turnID := 1; turnID <= GetCheckpoint(); turnID++ {
+		(*learntValuesTurnID)[turnID] = true
 	}
+	return learntValuesTurnID
+}
+
+/*
+# ========================================================= #
+#                     SNAPSHOT QUERIES                      #
+# ========================================================= #
+*/
+
+// snapshot caches the snapshot stored by the backend, it's loaded by InitDatabase and replaced by Checkpoint.
+var snapshot struct {
+	sync.RWMutex
+	snap messages.Snapshot
+}
+
+// setSnapshot replaces the cached snapshot.
+func setSnapshot(snap messages.Snapshot) {
+	snapshot.Lock()
+	defer snapshot.Unlock()
+	snapshot.snap = snap
+}
+
+// snapshotValue returns the value of @turnID found in the snapshot, the boolean is false when @turnID is above the checkpoint.
+func snapshotValue(turnID int) (string, bool) {
+	snapshot.RLock()
+	defer snapshot.RUnlock()
+
+	if turnID > snapshot.snap.LastTurnID {
+		return "", false
+	}
+	return snapshot.snap.Values[turnID], true
+}
+
+// GetCheckpoint returns the highest turn id compacted into the snapshot, 0 if nothing has been compacted.
+func GetCheckpoint() int {
+	snapshot.RLock()
+	defer snapshot.RUnlock()
+	return snapshot.snap.LastTurnID
+}
+
+// GetSnapshot returns a copy of the current snapshot.
+func GetSnapshot() messages.Snapshot {
+	snapshot.RLock()
+	defer snapshot.RUnlock()
+
+	values := make(map[int]string, len(snapshot.snap.Values))
+	for turnID, v := range snapshot.snap.Values {
+		values[turnID] = v
+	}
+	return messages.Snapshot{LastTurnID: snapshot.snap.LastTurnID, Values: values}
+}
+
+// checkpointMu serializes Checkpoint calls, two concurrent checkpoints could otherwise store snapshots in the wrong order.
+var checkpointMu sync.Mutex
+
+// Checkpoint folds the learnt values following the current checkpoint into a new snapshot, then deletes their learnt and proposal entries.
+// Only the contiguous learnt turn ids are folded (a turn id without a learnt value stops the checkpoint) and the last @retain of them are kept out of the snapshot,
+// so that peers slightly behind keep receiving single values. The returned snapshot is the one in use when the function returns.
+func Checkpoint(retain int) (messages.Snapshot, error) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+
+	learntValuesTurnID := *store.GetLearntValuesTurnID()
+	checkpoint := GetCheckpoint()
+
+	lastContiguous := checkpoint
+	for learntValuesTurnID[lastContiguous+1] {
+		lastContiguous++
+	}
+
+	upTo := lastContiguous - retain
+	if upTo <= checkpoint {
+		return GetSnapshot(), nil
+	}
+
+	snap := GetSnapshot()
+	for turnID := checkpoint + 1; turnID <= upTo; turnID++ {
+		snap.Values[turnID] = store.GetLearntValue(turnID)
+	}
+	snap.LastTurnID = upTo
+
+	// the snapshot and the backend change together, see SetLearntValue
+	snapshot.Lock()
+	err := store.Compact(snap)
+	if err == nil {
+		snapshot.snap = snap
+	}
+	snapshot.Unlock()
+	if err != nil {
+		return GetSnapshot(), err
+	}
+
+	log.Printf("[QUERIES] -> Checkpoint moved from turn id %d to %d.", checkpoint, upTo)
+	return GetSnapshot(), nil
+}
+
+/*
+# ========================================================= #
+#                       META QUERIES                        #
+# ========================================================= #
+*/
+
+// GetMeta returns the value stored under @key by SetMeta, an empty string if none has been stored.
+// Meta values describe the node itself (e.g. the highest sequence number it has used), not the replicated log.
+func GetMeta(key string) (string, error) {
+	return store.GetMeta(key)
+}
+
+// SetMeta stores @value under @key, replacing the previous one. The value is on disk when the function returns, the "memory" backend excepted.
+func SetMeta(key string, value string) error {
+	return store.SetMeta(key, value)
+}
+
+// DeleteMeta deletes the value stored under @key, deleting a key which has no value is not an error.
+func DeleteMeta(key string) error {
+	return store.DeleteMeta(key)
+}
+
+// ListMeta returns the values stored under the keys starting with @prefix, keyed by their whole key.
+// Together with SetMeta and DeleteMeta it lets a collection be stored one entry per key, e.g. "pending_learn:<turn_id>:<peer>".
+func ListMeta(prefix string) (map[string]string, error) {
+	return store.ListMeta(prefix)
 }
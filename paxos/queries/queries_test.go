@@ -0,0 +1,401 @@
+package queries
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/proposal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// localStores are the backends which need no server, the tests run against each of them (see forEachStore).
+var localStores = []string{"memory", "sqlite", "wal"}
+
+// openStore selects a new, empty backend named @dbType, its files live in a temporary directory.
+// The returned function closes the backend and removes the directory.
+func openStore(t *testing.T, dbType string) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "go-paxos-queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CONF.DB_PATH = filepath.Join(dir, "database.db")
+	config.CONF.DB_JOURNAL_MODE = "WAL"
+	config.CONF.DB_BUSY_TIMEOUT = 5000
+	config.CONF.WAL_PATH = filepath.Join(dir, "paxos.wal")
+
+	err = PrepareDBConn(dbType)
+	if err == nil {
+		err = InitDatabase()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("could not open the %s store: %v", dbType, err)
+	}
+
+	return func() {
+		closeStore()
+		os.RemoveAll(dir)
+	}
+}
+
+// closeStore closes the files of the backend in use, as a shutdown of the node does.
+func closeStore() {
+	switch s := store.(type) {
+	case *walStore:
+		s.file.Close()
+	case *sqliteStore:
+		s.db.Close()
+	}
+}
+
+// forEachStore runs @test against a new, empty store of each of the localStores.
+func forEachStore(t *testing.T, test func(t *testing.T)) {
+	for _, dbType := range localStores {
+		t.Run(dbType, func(t *testing.T) {
+			defer openStore(t, dbType)()
+			test(t)
+		})
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	for _, dbType := range []string{"memory", "redis", "sqlite", "wal"} {
+		if _, err := NewStore(dbType); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+	}
+	if _, err := NewStore("mysql"); err == nil {
+		t.Error("an unknown db_type has been accepted")
+	}
+	if err := PrepareDBConn(""); err == nil {
+		t.Error("an empty db_type has been accepted, no backend is picked by default")
+	}
+}
+
+func TestProposalRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		if _, ok := GetProposal(1); ok {
+			t.Fatal("an empty store holds a proposal")
+		}
+
+		err := SetProposal(1, proposal.Proposal{Pid: 1, Seq: 1, V: "a"}, true)
+		if err == nil {
+			err = SetProposal(2, proposal.Proposal{Pid: 2, Seq: 1}, false)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		state, ok := GetProposal(1)
+		if !ok || state.Accepted.V != "a" || !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 1}) {
+			t.Fatalf("state of turn id 1: %+v (ok = %v), want (1, 1) 'a' accepted", state, ok)
+		}
+		if ids := *GetProposalsTurnID(); len(ids) != 2 || !ids[1] || !ids[2] {
+			t.Fatalf("turn ids of the proposals: %v, want 1 and 2", ids)
+		}
+		if all := GetAllProposals(); len(all) != 2 {
+			t.Fatalf("%d proposals listed, want 2", len(all))
+		}
+
+		err = ResetProposal(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := GetProposal(1); ok {
+			t.Fatal("a reset proposal is still stored")
+		}
+		err = ResetAllProposals()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if all := GetAllProposals(); len(all) != 0 {
+			t.Fatalf("%d proposals listed after resetting them all, want 0", len(all))
+		}
+	})
+}
+
+func TestLearntRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		if GetLastTurnID() != 0 || GetLearntValue(1) != "" {
+			t.Fatal("an empty store holds learnt values")
+		}
+
+		for turnID, v := range map[int]string{1: "a", 3: "c"} {
+			err := SetLearntValue(turnID, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := SetProposal(2, proposal.Proposal{Pid: 1, Seq: 1, V: "b"}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := GetLearntValue(3); v != "c" {
+			t.Fatalf("learnt value of turn id 3: '%s', want 'c'", v)
+		}
+		if last := GetLastTurnID(); last != 3 {
+			t.Fatalf("last turn id: %d, want 3", last)
+		}
+		if dangling := *GetDanglingProposals(); len(dangling) != 1 || dangling[2].Accepted.V != "b" {
+			t.Fatalf("dangling proposals: %v, want the one of turn id 2", dangling)
+		}
+
+		err = ResetLearntValue(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := GetLastTurnID(); last != 1 {
+			t.Fatalf("last turn id after a reset: %d, want 1", last)
+		}
+	})
+}
+
+func TestPromiseIfHigher(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, ok, promised, err := PromiseIfHigher(1, proposal.Proposal{Pid: 1, Seq: 2})
+		if err != nil || ok || !promised {
+			t.Fatalf("first prepare request: ok = %v, promised = %v, err = %v; want a promise on an empty turn id", ok, promised, err)
+		}
+
+		for _, p := range []proposal.Proposal{{Pid: 1, Seq: 2}, {Pid: 2, Seq: 1}, {Pid: 0, Seq: 2}} {
+			oldState, ok, promised, err := PromiseIfHigher(1, p)
+			if err != nil || !ok || promised {
+				t.Fatalf("prepare request %+v: ok = %v, promised = %v, err = %v; want a refusal", p, ok, promised, err)
+			}
+			if !oldState.Promised.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 2}) {
+				t.Fatalf("prepare request %+v: returned promise %+v, want (1, 2)", p, oldState.Promised)
+			}
+		}
+
+		_, _, promised, err = PromiseIfHigher(1, proposal.Proposal{Pid: 2, Seq: 2})
+		if err != nil || !promised {
+			t.Fatalf("higher prepare request: promised = %v, err = %v; want a promise", promised, err)
+		}
+		state, _ := GetProposal(1)
+		if !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 2, Seq: 2}) || state.HasAccepted() {
+			t.Fatalf("stored state %+v, want promised (2, 2) and nothing accepted", state)
+		}
+	})
+}
+
+func TestAcceptIfNotLower(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, _, promised, err := PromiseIfHigher(1, proposal.Proposal{Pid: 2, Seq: 3})
+		if err != nil || !promised {
+			t.Fatalf("prepare request: promised = %v, err = %v", promised, err)
+		}
+
+		_, ok, accepted, err := AcceptIfNotLower(1, proposal.Proposal{Pid: 1, Seq: 3, V: "lower"})
+		if err != nil || !ok || accepted {
+			t.Fatalf("lower accept request: ok = %v, accepted = %v, err = %v; want a refusal", ok, accepted, err)
+		}
+
+		_, _, accepted, err = AcceptIfNotLower(1, proposal.Proposal{Pid: 2, Seq: 3, V: "equal"})
+		if err != nil || !accepted {
+			t.Fatalf("accept request equal to the promise: accepted = %v, err = %v; want an accept", accepted, err)
+		}
+		state, _ := GetProposal(1)
+		if state.Accepted.V != "equal" || !state.Accepted.IsEqualTo(&proposal.Proposal{Pid: 2, Seq: 3}) {
+			t.Fatalf("stored accepted proposal %+v, want (2, 3) 'equal'", state.Accepted)
+		}
+
+		// an accept without a prepare is fine on an empty turn id
+		_, ok, accepted, err = AcceptIfNotLower(2, proposal.Proposal{Pid: 1, Seq: 1, V: "fresh"})
+		if err != nil || ok || !accepted {
+			t.Fatalf("accept request on an empty turn id: ok = %v, accepted = %v, err = %v; want an accept", ok, accepted, err)
+		}
+	})
+}
+
+func TestCompareAndSetIsLinearizable(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		// every proposer sends the same proposal number, exactly one of them can be promised
+		const proposers = 16
+		var wg sync.WaitGroup
+		promises := make(chan bool, proposers)
+		for i := 0; i < proposers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, promised, err := PromiseIfHigher(1, proposal.Proposal{Pid: 1, Seq: 1})
+				if err != nil {
+					t.Error(err)
+				}
+				promises <- promised
+			}()
+		}
+		wg.Wait()
+		close(promises)
+
+		count := 0
+		for promised := range promises {
+			if promised {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Fatalf("%d concurrent prepare requests with the same number have been promised, want 1", count)
+		}
+	})
+}
+
+func TestPromiseKeepsAccepted(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, _, accepted, err := AcceptIfNotLower(1, proposal.Proposal{Pid: 1, Seq: 1, V: "a"})
+		if err != nil || !accepted {
+			t.Fatalf("accept request: accepted = %v, err = %v", accepted, err)
+		}
+
+		// a later prepare request moves the promise forward, the value stays accepted under its own number
+		oldState, _, promised, err := PromiseIfHigher(1, proposal.Proposal{Pid: 2, Seq: 4})
+		if err != nil || !promised {
+			t.Fatalf("higher prepare request: promised = %v, err = %v", promised, err)
+		}
+		if oldState.Accepted.V != "a" {
+			t.Fatalf("promise returned the accepted proposal %+v, want 'a'", oldState.Accepted)
+		}
+		state, _ := GetProposal(1)
+		if !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 2, Seq: 4}) || !state.Accepted.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 1}) || state.Accepted.V != "a" {
+			t.Fatalf("stored state %+v, want promised (2, 4) and 'a' accepted under (1, 1)", state)
+		}
+	})
+}
+
+func TestLearntValuesAreWrittenOnce(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		err := SetLearntValue(1, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = SetLearntValue(1, "a"); err != nil {
+			t.Fatalf("learning the same value again: %v, want no error", err)
+		}
+
+		err = SetLearntValue(1, "b")
+		conflict, isConflict := err.(*LearntConflictError)
+		if !isConflict || conflict.Current != "a" || conflict.Proposed != "b" {
+			t.Fatalf("learning a different value: %v, want a conflict between 'a' and 'b'", err)
+		}
+		if v := GetLearntValue(1); v != "a" {
+			t.Fatalf("learnt value after a conflict: '%s', want 'a'", v)
+		}
+
+		if err = OverrideLearntValue(1, "b", ""); err == nil {
+			t.Fatal("a learnt value has been overridden without a reason")
+		}
+		if err = OverrideLearntValue(1, "b", "repair"); err != nil {
+			t.Fatal(err)
+		}
+		if v := GetLearntValue(1); v != "b" {
+			t.Fatalf("learnt value after an override: '%s', want 'b'", v)
+		}
+	})
+}
+
+func TestConcurrentLearnsKeepOneValue(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		const learners = 16
+		var wg sync.WaitGroup
+		learnt := make(chan string, learners)
+		for i := 0; i < learners; i++ {
+			wg.Add(1)
+			go func(v string) {
+				defer wg.Done()
+				if SetLearntValue(1, v) == nil {
+					learnt <- v
+				}
+			}(fmt.Sprintf("v%d", i))
+		}
+		wg.Wait()
+		close(learnt)
+
+		var values []string
+		for v := range learnt {
+			values = append(values, v)
+		}
+		if len(values) != 1 || GetLearntValue(1) != values[0] {
+			t.Fatalf("values learnt concurrently: %v, stored '%s'; want exactly one, the stored one", values, GetLearntValue(1))
+		}
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		for _, turnID := range []int{1, 2, 3, 4, 5, 7} {
+			err := SetProposal(turnID, proposal.Proposal{Pid: 1, Seq: 1, V: fmt.Sprintf("v%d", turnID)}, true)
+			if err == nil {
+				err = SetLearntValue(turnID, fmt.Sprintf("v%d", turnID))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// turn id 6 is missing, the last contiguous one is 5 and the last one of them is retained
+		snap, err := Checkpoint(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.LastTurnID != 4 || GetCheckpoint() != 4 {
+			t.Fatalf("checkpoint at turn id %d, want 4", snap.LastTurnID)
+		}
+
+		if v := GetLearntValue(2); v != "v2" {
+			t.Fatalf("compacted value of turn id 2: '%s', want 'v2'", v)
+		}
+		if _, ok := GetProposal(2); ok {
+			t.Fatal("the proposal of a compacted turn id is still stored")
+		}
+		if all := GetAllLearntValues(); len(all) != 2 {
+			t.Fatalf("%d learnt values listed after the checkpoint, want the 2 above it", len(all))
+		}
+		if last := GetLastTurnID(); last != 7 {
+			t.Fatalf("last turn id: %d, want 7", last)
+		}
+		if ids := *GetLearntValuesTurnID(); !ids[1] || !ids[4] || !ids[5] || ids[6] {
+			t.Fatalf("learnt turn ids after the checkpoint: %v", ids)
+		}
+
+		// compacted values are written once as well
+		if err = SetLearntValue(2, "other"); err == nil {
+			t.Fatal("a compacted value has been replaced")
+		}
+		if err = SetLearntValue(2, "v2"); err != nil {
+			t.Fatalf("learning a compacted value again: %v, want no error", err)
+		}
+
+		// nothing new to fold, the checkpoint stays where it is
+		snap, err = Checkpoint(1)
+		if err != nil || snap.LastTurnID != 4 {
+			t.Fatalf("second checkpoint at turn id %d (err = %v), want 4", snap.LastTurnID, err)
+		}
+	})
+}
+
+func TestRangePromiseFloor(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, promised, err := PromiseRange(5, proposal.Proposal{Pid: 1, Seq: 3})
+		if err != nil || !promised {
+			t.Fatalf("range prepare request: promised = %v, err = %v", promised, err)
+		}
+
+		if _, _, promised, _ := PromiseIfHigher(5, proposal.Proposal{Pid: 1, Seq: 3}); promised {
+			t.Fatal("prepare request equal to the range promise has been promised")
+		}
+		if _, _, accepted, _ := AcceptIfNotLower(6, proposal.Proposal{Pid: 2, Seq: 2, V: "v"}); accepted {
+			t.Fatal("accept request lower than the range promise has been accepted")
+		}
+		if _, _, accepted, _ := AcceptIfNotLower(6, proposal.Proposal{Pid: 1, Seq: 3, V: "v"}); !accepted {
+			t.Fatal("accept request equal to the range promise has been refused")
+		}
+		if _, _, promised, _ := PromiseIfHigher(4, proposal.Proposal{Pid: 1, Seq: 1}); !promised {
+			t.Fatal("prepare request below the range has been refused")
+		}
+	})
+}
@@ -2,38 +2,50 @@
 package queries
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/go-redis/redis/v7"
 	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"log"
-	"net/http"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
-// util
-func proposalStringToProposal(pS string) (int, proposal.Proposal) {
-	components := strings.Split(pS, ":")
+// proposalStringToProposal parses the legacy "turn_id:pid:seq:v" strings, proposals are now stored as hashes (see stateToHash).
+// Only the first three ':' are separators, the value itself may contain more of them.
+// An error is returned when the string is malformed.
+func proposalStringToProposal(pS string) (int, proposal.Proposal, error) {
+	components := strings.SplitN(pS, ":", 4)
+	if len(components) != 4 {
+		return 0, proposal.Proposal{}, fmt.Errorf("malformed proposal string '%s'", pS)
+	}
 
-	turn_id, _ := strconv.Atoi(components[0])
-	pid, _ := strconv.Atoi(components[1])
-	seq, _ := strconv.Atoi(components[2])
+	turn_id, errTid := strconv.Atoi(components[0])
+	pid, errPid := strconv.Atoi(components[1])
+	seq, errSeq := strconv.Atoi(components[2])
+	if errTid != nil || errPid != nil || errSeq != nil {
+		return 0, proposal.Proposal{}, fmt.Errorf("malformed proposal string '%s'", pS)
+	}
 	v := components[3]
 
 	return turn_id, proposal.Proposal{
 		Pid: pid,
 		Seq: seq,
 		V:   v,
-	}
+	}, nil
 }
 
-// util
+// learntStringToLearnt parses the "turn_id:v" strings holding learnt values.
+// Only the first ':' is a separator, the value itself may contain more of them and has to be compared as a whole.
+// Malformed strings are returned with a turn id equal to 0, see checkLearntKey.
 func learntStringToLearnt(pS string) (int, string) {
-	components := strings.Split(pS, ":")
+	components := strings.SplitN(pS, ":", 2)
+	if len(components) != 2 {
+		return 0, pS
+	}
 
 	turn_id, _ := strconv.Atoi(components[0])
 	v := components[1]
@@ -41,18 +53,158 @@ func learntStringToLearnt(pS string) (int, string) {
 	return turn_id, v
 }
 
-var client *redis.Client
+// redisStore is the Store backed by a Redis server.
+// Every key is prepended with prefix (redis_key_prefix), so that several nodes can share the same Redis server.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func init() {
+	Register("redis", func() Store { return &redisStore{} })
+}
 
-func RedisPrepareDBConn() {
-	client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
+// PrepareDBConn connects to the Redis server described by the redis_* fields of the '.yaml' file.
+func (s *redisStore) PrepareDBConn() error {
+	s.prefix = config.CONF.REDIS_KEY_PREFIX
+	s.client = redis.NewClient(&redis.Options{
+		Addr:     config.CONF.REDIS_ADDR,
+		Password: config.CONF.REDIS_PASSWORD,
+		DB:       config.CONF.REDIS_DB,
 	})
-	_, err := client.Ping().Result()
+	_, err := s.client.Ping().Result()
 	if err != nil {
-		log.Fatal("Redis server did not PONG back to our PING")
+		return fmt.Errorf("could not reach redis at %s (db %d): %v", config.CONF.REDIS_ADDR, config.CONF.REDIS_DB, err)
 	}
+	return nil
+}
+
+// key returns @name namespaced with the key prefix of the node.
+func (s *redisStore) key(name string) string {
+	return s.prefix + name
+}
+
+// proposalsKey returns the key of the set holding the turn ids having a proposal.
+func (s *redisStore) proposalsKey() string {
+	return s.key("proposals")
+}
+
+// proposalKey returns the key of the hash holding the proposal for @turnID.
+func (s *redisStore) proposalKey(turnID int) string {
+	return s.key(fmt.Sprintf("proposal:%d", turnID))
+}
+
+// learntKey returns the key of the set holding the turn ids having a learnt value.
+func (s *redisStore) learntKey() string {
+	return s.key("learnt")
+}
+
+// snapshotKey returns the key holding the snapshot, encoded as json.
+func (s *redisStore) snapshotKey() string {
+	return s.key("snapshot")
+}
+
+// rangePromiseKey returns the key holding the range promise, encoded as json.
+func (s *redisStore) rangePromiseKey() string {
+	return s.key("range_promise")
+}
+
+// metaKey returns the key of the hash holding the meta values, see GetMeta.
+func (s *redisStore) metaKey() string {
+	return s.key("meta")
+}
+
+// learntValueKey returns the key holding the value learnt for @turnID.
+func (s *redisStore) learntValueKey(turnID int) string {
+	return s.key(fmt.Sprintf("learnt:%d", turnID))
+}
+
+// InitDatabase migrates the proposals stored with the legacy "turn_id:pid:seq:v" format to hashes.
+// Redis does not need any table.
+func (s *redisStore) InitDatabase() error {
+	tids, err := s.client.SMembers(s.proposalsKey()).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, tid := range tids {
+		rKey := s.key("proposal:" + tid)
+		keyType, err := s.client.Type(rKey).Result()
+		if err != nil {
+			return err
+		}
+		if keyType != "string" {
+			continue
+		}
+
+		log.Printf("[QUERIES] -> Migrating legacy proposal %s to a hash.", rKey)
+		err = s.migrateLegacyProposal(rKey)
+		if err != nil {
+			// malformed strings are left in place, fsck reports them
+			log.Printf("[QUERIES] -> Could not migrate %s: %v", rKey, err)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyProposal converts the "turn_id:pid:seq:v" string stored at @rKey to a hash.
+// The legacy format kept a single number, the promised one: it becomes the promised number and, when a value was stored, also the accepted one,
+// see splitPromisedAndAccepted in 'sqlite-queries.go'.
+func (s *redisStore) migrateLegacyProposal(rKey string) error {
+	proposalString, err := s.client.Get(rKey).Result()
+	if err != nil {
+		return err
+	}
+	_, p, err := proposalStringToProposal(proposalString)
+	if err != nil {
+		return err
+	}
+
+	state := proposal.AcceptorState{Promised: proposal.Proposal{Pid: p.Pid, Seq: p.Seq}}
+	if p.V != "" {
+		state.Accepted = p
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(rKey)
+	pipe.HMSet(rKey, stateToHash(state))
+	_, err = pipe.Exec()
+	return err
+}
+
+// stateToHash maps an AcceptorState onto the fields of the 'proposal:<turn_id>' hash.
+// The accepted fields are left out when nothing has been accepted.
+func stateToHash(state proposal.AcceptorState) map[string]interface{} {
+	fields := map[string]interface{}{
+		"promised_pid": state.Promised.Pid,
+		"promised_seq": state.Promised.Seq,
+	}
+	if state.HasAccepted() {
+		fields["accepted_pid"] = state.Accepted.Pid
+		fields["accepted_seq"] = state.Accepted.Seq
+		fields["accepted_value"] = state.Accepted.V
+	}
+	return fields
+}
+
+// hashToState maps the fields of a 'proposal:<turn_id>' hash onto an AcceptorState.
+// The returned boolean is false when the promised number is missing, i.e. when the state is not valid.
+func hashToState(fields map[string]string) (proposal.AcceptorState, bool) {
+	state := proposal.AcceptorState{}
+
+	promisedPid, errPid := strconv.Atoi(fields["promised_pid"])
+	promisedSeq, errSeq := strconv.Atoi(fields["promised_seq"])
+	if errPid != nil || errSeq != nil {
+		return state, false
+	}
+	state.Promised = proposal.Proposal{Pid: promisedPid, Seq: promisedSeq}
+
+	acceptedPid, errPid := strconv.Atoi(fields["accepted_pid"])
+	acceptedSeq, errSeq := strconv.Atoi(fields["accepted_seq"])
+	if errPid == nil && errSeq == nil {
+		state.Accepted = proposal.Proposal{Pid: acceptedPid, Seq: acceptedSeq, V: fields["accepted_value"]}
+	}
+	return state, true
 }
 
 /*
@@ -61,48 +213,40 @@ func RedisPrepareDBConn() {
 # ========================================================= #
 */
 
-// GetProposal returns the entry of the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If the wanted proposal does not exist OR is not valid (i.e. pid and seq are 0)
-// an empty proposal is returned together with a false boolean value.
-// Please note that proposals with @pid or @seq = 0 should not exist, the user should not issue such values.
-// If the wanted proposal exists AND is valid, it will be returned together with a positive boolean value.
-// If the field 'v' is NULL, @v will be assigned the empty string "".
-// The entry will be mapped onto a proposal.Proposal object.
-func RedisGetProposal(turnID int) (proposal.Proposal, bool) {
+// GetProposal returns the acceptor state stored for @turnID, see GetProposal in 'queries.go'.
+func (s *redisStore) GetProposal(turnID int) (proposal.AcceptorState, bool) {
 
-	rKey := fmt.Sprintf("proposal:%d", turnID)
-	proposalString, err := client.Get(rKey).Result()
+	rKey := s.proposalKey(turnID)
+	fields, err := s.client.HGetAll(rKey).Result()
 
-	p := proposal.Proposal{}
+	state := proposal.AcceptorState{}
 	ok := false
 
-	if err != nil || err == redis.Nil {
+	if err != nil || len(fields) == 0 {
 		// an error occurred when reading or no proposal found
 		log.Printf("[QUERIES] -> No proposal found for turn id: %d; returning an empty proposal.", turnID)
 
 	} else {
-		// assert rkey is memeber of proposals set
-		// redis: this is debug info
-		res := client.SIsMember("proposals", turnID).Val()
+		// the proposal should be a member of the proposals set, see Fsck
+		res := s.client.SIsMember(s.proposalsKey(), turnID).Val()
 		if res != true {
-			panic("false assertion, proposal was found on proposal table but not on proposal set. only the opposite can be true")
+			log.Printf("[QUERIES] -> Proposal %s is not a member of the proposals set; run /node/fsck?repair=true to fix it.", rKey)
 		}
 
-		_, p = proposalStringToProposal(proposalString)
-		ok = true
+		state, ok = hashToState(fields)
 	}
 
-	return p, ok
+	return state, ok
 
 }
 
 // GetAllProposals returns a list of all the entries stored in the 'proposal' table.
 // Each entry is mapped onto a messages.ProposalWithTid object.
-func RedisGetAllProposals() []messages.ProposalWithTid {
+func (s *redisStore) GetAllProposals() []messages.ProposalWithTid {
 
 	var m []messages.ProposalWithTid
 
-	tids, err := client.SMembers("proposals").Result()
+	tids, err := s.client.SMembers(s.proposalsKey()).Result()
 	if err != nil {
 		log.Print("ERR rilevato in client.SMembers - ", err.Error())
 	} else {
@@ -116,9 +260,9 @@ func RedisGetAllProposals() []messages.ProposalWithTid {
 				log.Print("Error when converting keys: ", err.Error())
 			} else {
 				// successfully extracted and converted turn id, now get the proposal
-				p, ok := RedisGetProposal(tid)
+				state, ok := s.GetProposal(tid)
 				if ok {
-					m = append(m, messages.ProposalWithTid{TurnID: tid, Proposal: p})
+					m = append(m, messages.ProposalWithTid{TurnID: tid, Proposal: state.Accepted, Promised: state.Promised})
 				}
 			}
 		}
@@ -127,57 +271,108 @@ func RedisGetAllProposals() []messages.ProposalWithTid {
 	return m
 }
 
-// SetProposal inserts/updates an entry in the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If isAcceptRequest is false, only the value "n" (i.e. Pid and Seq) will be overwritten, while "v" will be left untouched.
-// If isAcceptRequest is true, both "v" and "n" will be overwritten by the value requested.
-func RedisSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
-
-	// adding proposals to set
-	rKey := fmt.Sprintf("proposal:%d", turnID)
-	client.SAdd("proposals", turnID)
-	// if node fails now it does not matter, the proposal will be empty and everything will work as intended
+// SetProposal inserts/updates an entry in the 'proposal' table, see SetProposal in 'queries.go'.
+// Prepare requests only write the promised fields of the hash, the accepted ones are left untouched.
+func (s *redisStore) SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
 
+	rKey := s.proposalKey(turnID)
+	fields := map[string]interface{}{
+		"promised_pid": p.Pid,
+		"promised_seq": p.Seq,
+	}
 	if isAcceptRequest {
 		// is accept request, overwrite everything
-		rVal := fmt.Sprintf("%d:%d:%d:%s", turnID, p.Pid, p.Seq, p.V)
-		_, err := client.Set(rKey, rVal, 0).Result()
+		fields = stateToHash(proposal.AcceptorState{Promised: proposal.Proposal{Pid: p.Pid, Seq: p.Seq}, Accepted: p})
+	}
 
-		return err
+	// adding proposals to set
+	pipe := s.client.TxPipeline()
+	pipe.SAdd(s.proposalsKey(), turnID)
+	pipe.HMSet(rKey, fields)
+	_, err = pipe.Exec()
 
-	} else {
-		// is prepare request with non empty V. If the stored value is not NULL it will not be overwritten.
-		err := client.Watch(func(tx *redis.Tx) error {
-			proposalString, err := tx.Get(rKey).Result()
-
-			// if the proposal actually exists
-			if proposalString != "" {
-				_, currentP := proposalStringToProposal(proposalString)
-				if currentP.V != "" {
-					// se il V attuale non è nullo, non lo sovrascrivo (i.e. lo sovrascrivo con se stesso)
-					p.V = currentP.V
-				}
-			}
+	return err
+}
 
-			rVal := fmt.Sprintf("%d:%d:%d:%s", turnID, p.Pid, p.Seq, p.V)
-			_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
-				pipe.Set(rKey, rVal, 0)
-				return nil
-			})
-			return err
-		}, rKey)
+// compareAndSetScript implements both PromiseIfHigher and AcceptIfNotLower on the server side, Redis runs scripts atomically.
+// KEYS[1] is the proposal hash and KEYS[2] the proposals set; ARGV holds turn id, pid, seq, v and "1" for accept requests.
+// The script replies with {1 if the proposal has been stored else 0, followed by the promised pid and seq and the
+// accepted pid, seq and value stored before the call}. Missing fields are replied as "".
+var compareAndSetScript = redis.NewScript(`
+local old = redis.call('HMGET', KEYS[1], 'promised_pid', 'promised_seq', 'accepted_pid', 'accepted_seq', 'accepted_value')
+for i = 1, 5 do
+	if not old[i] then
+		old[i] = ''
+	end
+end
+
+local isAccept = ARGV[5] == '1'
+local newPid = tonumber(ARGV[2])
+local newSeq = tonumber(ARGV[3])
+local pid = tonumber(old[1])
+local seq = tonumber(old[2])
+
+if pid and seq then
+	local greater = newSeq > seq or (newSeq == seq and newPid > pid)
+	local equal = newSeq == seq and newPid == pid
+	if not (greater or (isAccept and equal)) then
+		return {0, old[1], old[2], old[3], old[4], old[5]}
+	end
+end
+
+redis.call('SADD', KEYS[2], ARGV[1])
+if isAccept then
+	redis.call('HMSET', KEYS[1], 'promised_pid', ARGV[2], 'promised_seq', ARGV[3], 'accepted_pid', ARGV[2], 'accepted_seq', ARGV[3], 'accepted_value', ARGV[4])
+else
+	redis.call('HMSET', KEYS[1], 'promised_pid', ARGV[2], 'promised_seq', ARGV[3])
+end
+return {1, old[1], old[2], old[3], old[4], old[5]}
+`)
+
+// PromiseIfHigher promises @p for @turnID only if it is strictly higher than the promised number, see compareAndSetScript.
+func (s *redisStore) PromiseIfHigher(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, false)
+}
 
-		return err
+// AcceptIfNotLower accepts @p for @turnID only if it is higher than or equal to the promised number, see compareAndSetScript.
+func (s *redisStore) AcceptIfNotLower(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, true)
+}
+
+// compareAndSetProposal runs compareAndSetScript and decodes its reply.
+func (s *redisStore) compareAndSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (oldState proposal.AcceptorState, ok bool, done bool, err error) {
+	rKey := s.proposalKey(turnID)
+	isAccept := "0"
+	if isAcceptRequest {
+		isAccept = "1"
+	}
+
+	res, err := compareAndSetScript.Run(s.client, []string{rKey, s.proposalsKey()}, turnID, p.Pid, p.Seq, p.V, isAccept).Result()
+	if err != nil {
+		return oldState, false, false, err
+	}
+
+	reply, isList := res.([]interface{})
+	if !isList || len(reply) != 6 {
+		return oldState, false, false, fmt.Errorf("unexpected reply from compare and set script: %v", res)
 	}
 
+	stored, _ := reply[0].(int64)
+	fields := make(map[string]string)
+	for i, field := range []string{"promised_pid", "promised_seq", "accepted_pid", "accepted_seq", "accepted_value"} {
+		fields[field], _ = reply[i+1].(string)
+	}
+	oldState, ok = hashToState(fields)
+	return oldState, ok, stored == 1, nil
 }
 
 // ResetProposal deletes the entry from the 'proposal' table where the field 'turn_id' is equal to @turnID.
-func RedisResetProposal(turnID int) error {
-	rKey := fmt.Sprintf("proposal:%d", turnID)
+func (s *redisStore) ResetProposal(turnID int) error {
+	rKey := s.proposalKey(turnID)
 
 	// pipeline
-	pipe := client.TxPipeline()
-	pipe.SRem("proposals", strconv.Itoa(turnID))
+	pipe := s.client.TxPipeline()
+	pipe.SRem(s.proposalsKey(), strconv.Itoa(turnID))
 	pipe.Del(rKey)
 	_, err := pipe.Exec()
 
@@ -186,8 +381,8 @@ func RedisResetProposal(turnID int) error {
 }
 
 // ResetAllProposals empties the `proposal` table.
-func RedisResetAllProposals() error {
-	tids, err := client.SMembers("proposals").Result()
+func (s *redisStore) ResetAllProposals() error {
+	tids, err := s.client.SMembers(s.proposalsKey()).Result()
 	if err != nil {
 		log.Print("ERR rilevato in client.SMembers - ", err.Error())
 	} else {
@@ -199,7 +394,7 @@ func RedisResetAllProposals() error {
 			if err != nil {
 				log.Print("Error when converting keys: ", err.Error())
 			} else {
-				err = RedisResetProposal(tid)
+				err = s.ResetProposal(tid)
 				if err != nil {
 					return err
 				}
@@ -211,11 +406,11 @@ func RedisResetAllProposals() error {
 
 // GetProposalsTurnID is a map used as a set, the keys are the turnIDs of the proposals we know.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
-func RedisGetProposalsTurnID() *map[int]bool {
+func (s *redisStore) GetProposalsTurnID() *map[int]bool {
 	proposalsTurnID := make(map[int]bool)
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	tids, err := client.SMembers("proposals").Result()
+	tids, err := s.client.SMembers(s.proposalsKey()).Result()
 	if err != nil {
 		log.Print("ERR rilevato in client.SMembers - ", err.Error())
 	} else {
@@ -235,24 +430,24 @@ func RedisGetProposalsTurnID() *map[int]bool {
 }
 
 // GetDanglingProposals returns a map of the proposals found in the 'proposal' table whose turn ID does not have an entry 'learnt' table.
-// The map uses the turn ID as the key and a Proposal object as the value.
-func RedisGetDanglingProposals() *map[int]proposal.Proposal {
+// The map uses the turn ID as the key and an AcceptorState object as the value.
+func (s *redisStore) GetDanglingProposals() *map[int]proposal.AcceptorState {
 
-	danglingProposals := make(map[int]proposal.Proposal)
+	danglingProposals := make(map[int]proposal.AcceptorState)
 
-	danglingTids, err := client.SDiff("proposals", "learnt").Result()
+	danglingTids, err := s.client.SDiff(s.proposalsKey(), s.learntKey()).Result()
 	if err != nil {
 		fmt.Printf("Error when computing SDiff between proposals and learnt")
 	}
 
 	for _, dTid := range danglingTids {
 		tid, err := strconv.Atoi(dTid)
-		p, ok := GetProposal(tid)
+		state, ok := s.GetProposal(tid)
 
 		if err != nil || !ok {
 			fmt.Printf("Error when converting tid or when retrieving proposal by tid")
 		} else {
-			danglingProposals[tid] = p
+			danglingProposals[tid] = state
 		}
 	}
 
@@ -268,11 +463,11 @@ func RedisGetDanglingProposals() *map[int]proposal.Proposal {
 
 // GetLearntValue returns the 'v' field of the 'learnt' table where the field 'turn_id' is equal to @turnID.
 // If no value has been learnt for the requested @turnID, an empty string is returned.
-func RedisGetLearntValue(turnID int) string {
+func (s *redisStore) GetLearntValue(turnID int) string {
 
 	var vString string
-	rKey := fmt.Sprintf("learnt:%d", turnID)
-	learntString, err := client.Get(rKey).Result()
+	rKey := s.learntValueKey(turnID)
+	learntString, err := s.client.Get(rKey).Result()
 
 	if err != nil || err == redis.Nil {
 		log.Printf("[QUERIES] -> No learnt value found for turn_id: %d.", turnID)
@@ -282,53 +477,65 @@ func RedisGetLearntValue(turnID int) string {
 	return vString
 }
 
-// SetLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
-// If the requested @turnID does not exist, a new entry is created.
-// If the learnt value for the requested @turnID is already present, it will be overwritten. (why?)
-func RedisSetLearntValue(turnID int, v string) (err error) {
+// SetLearntValue inserts an entry in the 'learnt' table, see SetLearntValue in 'queries.go'.
+// The value is written with SETNX so that an existing entry is never updated.
+func (s *redisStore) SetLearntValue(turnID int, v string) (err error) {
 
-	rKey := fmt.Sprintf("learnt:%d", turnID)
+	rKey := s.learntValueKey(turnID)
 	rVal := fmt.Sprintf("%d:%s", turnID, v)
-	_, err = client.Set(rKey, rVal, 0).Result()
 
+	// pipeline, SADD is idempotent so it can run even when the value already exists
+	pipe := s.client.TxPipeline()
+	setNX := pipe.SetNX(rKey, rVal, 0)
+	pipe.SAdd(s.learntKey(), turnID)
+	_, err = pipe.Exec()
 	if err != nil {
 		return err
 	}
-	// pipeline
-	pipe := client.TxPipeline()
-	pipe.SAdd("learnt", turnID)
-	pipe.Set(rKey, rVal, 0)
-	_, err = pipe.Exec()
+
+	if !setNX.Val() {
+		// learnt values are never updated (but by OverrideLearntValue) so the value read here is the one that prevented the write
+		learntString, err := s.client.Get(rKey).Result()
+		if err != nil {
+			return err
+		}
+		_, currentV := learntStringToLearnt(learntString)
+		if currentV != v {
+			return &LearntConflictError{TurnID: turnID, Current: currentV, Proposed: v}
+		}
+		return nil
+	}
 
 	// counting how many rows in learnt table so i can notify some listener that i learnt all turn_ids
 	// it is needed for testing and benchmarking purposes
-	card, err := client.SCard("learnt").Result()
-	howMany := int(card)
-	if err != nil {
-		// do nothing
-	} else {
-		if howMany == config.CONF.NUMBER_OF_TIDS {
-			now := time.Now()
-			sec := now.Unix()
-			go func() {
-				_, err := http.Get(fmt.Sprintf("%s/timer?nid=%d&timestamp=%d&how_many=%d", config.CONF.LISTENER_IP, config.CONF.PID, sec, howMany))
-				if err != nil {
-					log.Printf("Errore nella richiesta di salvataggio del timer: %v", err.Error())
-				}
-			}()
-		}
+	card, err := s.client.SCard(s.learntKey()).Result()
+	if err == nil {
+		notifyListener(int(card))
 	}
 
+	return nil
+}
+
+// OverrideLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
+func (s *redisStore) OverrideLearntValue(turnID int, v string) error {
+	rKey := s.learntValueKey(turnID)
+	rVal := fmt.Sprintf("%d:%s", turnID, v)
+
+	// pipeline
+	pipe := s.client.TxPipeline()
+	pipe.SAdd(s.learntKey(), turnID)
+	pipe.Set(rKey, rVal, 0)
+	_, err := pipe.Exec()
 	return err
 }
 
 // ResetLearntValue deletes the entry from the 'learnt' table where the field 'turn_id' is equal to @turnID.
-func RedisResetLearntValue(turnID int) error {
-	rKey := fmt.Sprintf("learnt:%d", turnID)
+func (s *redisStore) ResetLearntValue(turnID int) error {
+	rKey := s.learntValueKey(turnID)
 
 	// pipeline
-	pipe := client.TxPipeline()
-	pipe.SRem("learnt", strconv.Itoa(turnID))
+	pipe := s.client.TxPipeline()
+	pipe.SRem(s.learntKey(), strconv.Itoa(turnID))
 	pipe.Del(rKey)
 	_, err := pipe.Exec()
 
@@ -337,8 +544,8 @@ func RedisResetLearntValue(turnID int) error {
 }
 
 // ResetAllLearntValues empties the `learnt` table.
-func RedisResetAllLearntValues() error {
-	tids, err := client.SMembers("learnt").Result()
+func (s *redisStore) ResetAllLearntValues() error {
+	tids, err := s.client.SMembers(s.learntKey()).Result()
 	if err != nil {
 		log.Print("ERR rilevato in SMembers - ", err.Error())
 	} else {
@@ -348,7 +555,7 @@ func RedisResetAllLearntValues() error {
 				log.Print("Error when converting keys: ", err.Error())
 			} else {
 				// successfully extracted and converted turn id
-				err = RedisResetLearntValue(tid)
+				err = s.ResetLearntValue(tid)
 				if err != nil {
 					return err
 				}
@@ -360,11 +567,11 @@ func RedisResetAllLearntValues() error {
 
 // GetAllLearntValues returns a list of all the entries stored in the 'learnt' table.
 // Each entry is mapped onto a LearntWithTid object.
-func RedisGetAllLearntValues() []messages.LearntWithTid {
+func (s *redisStore) GetAllLearntValues() []messages.LearntWithTid {
 
 	var m []messages.LearntWithTid
 
-	tids, err := client.SMembers("learnt").Result()
+	tids, err := s.client.SMembers(s.learntKey()).Result()
 	if err != nil {
 		log.Print("ERR rilevato in db.Query - ", err.Error())
 	} else {
@@ -376,7 +583,7 @@ func RedisGetAllLearntValues() []messages.LearntWithTid {
 				log.Print("Error when converting keys: ", err.Error())
 			} else {
 				// successfully extracted and converted turn id
-				v := RedisGetLearntValue(tid)
+				v := s.GetLearntValue(tid)
 				m = append(m, messages.LearntWithTid{TurnID: tid, Learnt: v})
 			}
 		}
@@ -386,17 +593,19 @@ func RedisGetAllLearntValues() []messages.LearntWithTid {
 
 // GetLastTurnID returns the highest turn ID found in the `learnt` table.
 // 0 is returned if table is empty.
-func RedisGetLastTurnID() int {
+func (s *redisStore) GetLastTurnID() int {
 	//row := db.QueryRow("SELECT turn_id FROM learnt ORDER BY turn_id DESC")
 
-	tids := client.SMembers("learnt").Val()
+	tids := s.client.SMembers(s.learntKey()).Val()
 
 	var lastID int
 
 	for _, v := range tids {
 		tid, err := strconv.Atoi(v)
 		if err != nil {
-			panic(err)
+			// a malformed member is skipped, fsck reports it
+			log.Printf("[QUERIES] -> Skipping malformed member '%s' of the '%s' set; run /node/fsck to report it.", v, s.learntKey())
+			continue
 		}
 		if tid > lastID {
 			lastID = tid
@@ -407,11 +616,11 @@ func RedisGetLastTurnID() int {
 
 // GetLearntValuesTurnID is a map used as a set, the keys are the turnIDs of the learnt values.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
-func RedisGetLearntValuesTurnID() *map[int]bool {
+func (s *redisStore) GetLearntValuesTurnID() *map[int]bool {
 
 	learntValuesTurnID := make(map[int]bool)
 
-	tids, err := client.SMembers("learnt").Result()
+	tids, err := s.client.SMembers(s.learntKey()).Result()
 
 	if err != nil {
 		log.Print("ERR rilevato in SMembers - ", err.Error())
@@ -429,3 +638,265 @@ func RedisGetLearntValuesTurnID() *map[int]bool {
 	}
 	return &learntValuesTurnID
 }
+
+/*
+# ========================================================= #
+#                     SNAPSHOT QUERIES                      #
+# ========================================================= #
+*/
+
+// GetSnapshot returns the snapshot stored under the 'snapshot' key, an empty snapshot if none has been stored.
+func (s *redisStore) GetSnapshot() (messages.Snapshot, error) {
+	snap := messages.Snapshot{Values: make(map[int]string)}
+
+	data, err := s.client.Get(s.snapshotKey()).Result()
+	if err == redis.Nil {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+
+	err = json.Unmarshal([]byte(data), &snap)
+	return snap, err
+}
+
+// Compact stores @snap under the 'snapshot' key and deletes the learnt values and the proposals up to its checkpoint, in a single transaction.
+func (s *redisStore) Compact(snap messages.Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// pipeline
+	pipe := s.client.TxPipeline()
+	pipe.Set(s.snapshotKey(), data, 0)
+	for turnID := range *s.GetLearntValuesTurnID() {
+		if turnID <= snap.LastTurnID {
+			pipe.SRem(s.learntKey(), strconv.Itoa(turnID))
+			pipe.Del(s.learntValueKey(turnID))
+		}
+	}
+	for turnID := range *s.GetProposalsTurnID() {
+		if turnID <= snap.LastTurnID {
+			pipe.SRem(s.proposalsKey(), strconv.Itoa(turnID))
+			pipe.Del(s.proposalKey(turnID))
+		}
+	}
+	_, err = pipe.Exec()
+
+	// executes multiple actions atomically
+	return err
+}
+
+/*
+# ========================================================= #
+#                   RANGE PROMISE QUERIES                   #
+# ========================================================= #
+*/
+
+// GetRangePromise returns the range promise stored under the 'range_promise' key, a null range promise if none has been stored.
+func (s *redisStore) GetRangePromise() (messages.RangePromise, error) {
+	rp := messages.RangePromise{}
+
+	data, err := s.client.Get(s.rangePromiseKey()).Result()
+	if err == redis.Nil {
+		return rp, nil
+	}
+	if err != nil {
+		return rp, err
+	}
+
+	err = json.Unmarshal([]byte(data), &rp)
+	return rp, err
+}
+
+// SetRangePromise stores @rp under the 'range_promise' key, replacing the previous one.
+func (s *redisStore) SetRangePromise(rp messages.RangePromise) error {
+	data, err := json.Marshal(rp)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(s.rangePromiseKey(), data, 0).Err()
+}
+
+/*
+# ========================================================= #
+#                       META QUERIES                        #
+# ========================================================= #
+*/
+
+// GetMeta returns the field @key of the 'meta' hash, an empty string if none has been stored.
+func (s *redisStore) GetMeta(key string) (string, error) {
+	value, err := s.client.HGet(s.metaKey(), key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetMeta sets the field @key of the 'meta' hash to @value.
+func (s *redisStore) SetMeta(key string, value string) error {
+	return s.client.HSet(s.metaKey(), key, value).Err()
+}
+
+// DeleteMeta deletes the field @key of the 'meta' hash.
+func (s *redisStore) DeleteMeta(key string) error {
+	return s.client.HDel(s.metaKey(), key).Err()
+}
+
+// ListMeta returns the fields of the 'meta' hash starting with @prefix.
+func (s *redisStore) ListMeta(prefix string) (map[string]string, error) {
+	fields, err := s.client.HGetAll(s.metaKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for key, value := range fields {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+/*
+# ========================================================= #
+#                     CONSISTENCY CHECK                     #
+# ========================================================= #
+*/
+
+// globEscaper escapes the characters having a special meaning in the patterns of SCAN.
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// scanKeys returns all the keys matching the prefix of the node followed by @pattern.
+func (s *redisStore) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := s.client.Scan(0, globEscaper.Replace(s.prefix)+pattern, 1000).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// Fsck compares the 'proposals' and 'learnt' sets against the 'proposal:<turn_id>' and 'learnt:<turn_id>' keys and checks the contents of the keys.
+// Problems found and, with @repair, the safe repairs are:
+//   - orphaned_key: the key exists but its turn id is not in the set; the turn id is added to the set (the key holds acceptor or learner state, it's never deleted).
+//   - missing_key: the turn id is in the set but the key does not exist; the turn id is removed from the set.
+//   - legacy_string: the proposal is still a "turn_id:pid:seq:v" string; it's converted to a hash.
+//   - malformed: the key cannot be parsed or has the wrong type; it's reported only, a human being has to decide what it held.
+func (s *redisStore) Fsck(repair bool) (FsckReport, error) {
+	report := FsckReport{DBType: "redis"}
+
+	for _, kind := range []struct {
+		setKey   string
+		keyName  string
+		checkKey func(rKey string, turnID int, repair bool) *FsckProblem
+	}{
+		{s.proposalsKey(), "proposal", s.checkProposalKey},
+		{s.learntKey(), "learnt", s.checkLearntKey},
+	} {
+		members, err := s.client.SMembers(kind.setKey).Result()
+		if err != nil {
+			return report, err
+		}
+		keys, err := s.scanKeys(kind.keyName + ":*")
+		if err != nil {
+			return report, err
+		}
+
+		inSet := make(map[string]bool)
+		for _, member := range members {
+			inSet[member] = true
+		}
+		hasKey := make(map[string]bool)
+
+		for _, rKey := range keys {
+			report.Checked++
+			tid := strings.TrimPrefix(rKey, s.key(kind.keyName+":"))
+			hasKey[tid] = true
+
+			turnID, err := strconv.Atoi(tid)
+			if err != nil {
+				report.Problems = append(report.Problems, FsckProblem{Kind: "malformed", Key: rKey, Detail: "the key does not end with a turn id"})
+				continue
+			}
+
+			if !inSet[tid] {
+				problem := FsckProblem{Kind: "orphaned_key", Key: rKey, Detail: fmt.Sprintf("turn id %d is missing from the '%s' set", turnID, kind.setKey)}
+				if repair {
+					problem.Repaired = s.client.SAdd(kind.setKey, turnID).Err() == nil
+				}
+				report.Problems = append(report.Problems, problem)
+			}
+
+			if problem := kind.checkKey(rKey, turnID, repair); problem != nil {
+				report.Problems = append(report.Problems, *problem)
+			}
+		}
+
+		for _, member := range members {
+			if hasKey[member] {
+				continue
+			}
+			problem := FsckProblem{Kind: "missing_key", Key: s.key(kind.keyName + ":" + member), Detail: fmt.Sprintf("'%s' is a member of the '%s' set but the key does not exist", member, kind.setKey)}
+			if repair {
+				problem.Repaired = s.client.SRem(kind.setKey, member).Err() == nil
+			}
+			report.Problems = append(report.Problems, problem)
+		}
+	}
+
+	log.Printf("[QUERIES] -> Fsck checked %d key(s) and found %d problem(s).", report.Checked, len(report.Problems))
+	return report, nil
+}
+
+// checkProposalKey checks the contents of the proposal stored at @rKey, nil is returned when they are fine.
+func (s *redisStore) checkProposalKey(rKey string, turnID int, repair bool) *FsckProblem {
+	keyType, err := s.client.Type(rKey).Result()
+	if err != nil {
+		return &FsckProblem{Kind: "malformed", Key: rKey, Detail: err.Error()}
+	}
+
+	switch keyType {
+	case "hash":
+		fields, err := s.client.HGetAll(rKey).Result()
+		if err != nil {
+			return &FsckProblem{Kind: "malformed", Key: rKey, Detail: err.Error()}
+		}
+		if _, ok := hashToState(fields); !ok {
+			return &FsckProblem{Kind: "malformed", Key: rKey, Detail: fmt.Sprintf("invalid promised number in %v", fields)}
+		}
+		return nil
+
+	case "string":
+		proposalString, err := s.client.Get(rKey).Result()
+		if err != nil {
+			return &FsckProblem{Kind: "malformed", Key: rKey, Detail: err.Error()}
+		}
+		tid, _, err := proposalStringToProposal(proposalString)
+		if err != nil || tid != turnID {
+			return &FsckProblem{Kind: "malformed", Key: rKey, Detail: fmt.Sprintf("'%s' is not a valid \"turn_id:pid:seq:v\" string for turn id %d", proposalString, turnID)}
+		}
+		problem := &FsckProblem{Kind: "legacy_string", Key: rKey, Detail: fmt.Sprintf("'%s' has not been migrated to a hash", proposalString)}
+		if repair {
+			problem.Repaired = s.migrateLegacyProposal(rKey) == nil
+		}
+		return problem
+	}
+
+	return &FsckProblem{Kind: "malformed", Key: rKey, Detail: fmt.Sprintf("unexpected key type '%s'", keyType)}
+}
+
+// checkLearntKey checks the contents of the learnt value stored at @rKey, nil is returned when they are fine.
+func (s *redisStore) checkLearntKey(rKey string, turnID int, _ bool) *FsckProblem {
+	learntString, err := s.client.Get(rKey).Result()
+	if err != nil {
+		return &FsckProblem{Kind: "malformed", Key: rKey, Detail: err.Error()}
+	}
+	if tid, _ := learntStringToLearnt(learntString); tid != turnID {
+		return &FsckProblem{Kind: "malformed", Key: rKey, Detail: fmt.Sprintf("'%s' is not a valid \"turn_id:v\" string for turn id %d", learntString, turnID)}
+	}
+	return nil
+}
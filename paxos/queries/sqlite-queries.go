@@ -3,33 +3,140 @@ package queries
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3" // blank import because of no explicit use, only side effects needed.
 	"go-paxos/paxos/config"
 	"go-paxos/paxos/messages"
 	"go-paxos/paxos/proposal"
 	"log"
-	"net/http"
-	"time"
+	"os"
 )
 
 const (
 	sqlDriver = "sqlite3"
 )
 
-var db *sql.DB
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx, it lets the same query run inside or outside a transaction.
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqliteStore is the Store backed by a SQLite database file.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func init() {
+	Register("sqlite", func() Store { return &sqliteStore{} })
+}
+
+// PrepareDBConn opens the SQLite database located at DB_PATH, the file is created when missing.
+// The journal mode and the busy timeout are taken from the '.yaml' file (db_journal_mode, db_busy_timeout).
+func (s *sqliteStore) PrepareDBConn() (err error) {
+
+	info, err := os.Stat(config.CONF.DB_PATH)
+	if err == nil && info.IsDir() {
+		// file does exist but it's a folder, ask the user to change the filename.
+		return fmt.Errorf("%s is a folder, change db_path and retry", config.CONF.DB_PATH)
+	}
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=%s", config.CONF.DB_PATH, config.CONF.DB_BUSY_TIMEOUT, config.CONF.DB_JOURNAL_MODE)
+	s.db, err = sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return err
+	}
+	s.db.SetMaxOpenConns(1)
+
+	// sql.Open does not connect, pinging makes a wrong path or journal mode fail here rather than on the first query.
+	return s.db.Ping()
+}
+
+// sqliteMigration is a single step bringing the database schema from version-1 to version.
+type sqliteMigration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// sqliteMigrations lists the schema changes in the order they have to be applied.
+// Migrations are never edited once released: a schema change is a new entry appended to this list.
+var sqliteMigrations = []sqliteMigration{
+	{1, "create the 'learnt' and 'proposal' tables", createTables},
+	{2, "store promised and accepted numbers separately", splitPromisedAndAccepted},
+	{3, "create the 'snapshot' table", createSnapshotTable},
+	{4, "create the 'range_promise' table", createRangePromiseTable},
+	{5, "create the 'meta' table", createMetaTable},
+}
+
+// InitDatabase brings the database schema up to date by applying the migrations found in sqliteMigrations
+// whose version is higher than the one stored in the 'schema_version' table.
+// Each migration runs in its own transaction together with the update of 'schema_version'.
+// A database whose version is higher than the last known migration (i.e. written by a newer node) is rejected.
+func (s *sqliteStore) InitDatabase() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS "schema_version" (
+		"version"	INTEGER NOT NULL
+	);`)
+	if err != nil {
+		return err
+	}
+
+	version, err := s.schemaVersion()
+	if err != nil {
+		return err
+	}
 
-func SQLitePrepareDBConn() {
+	lastVersion := sqliteMigrations[len(sqliteMigrations)-1].version
+	if version > lastVersion {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d", version, lastVersion)
+	}
+
+	for _, m := range sqliteMigrations {
+		if m.version <= version {
+			continue
+		}
+
+		log.Printf("[QUERIES] -> Migrating database schema to version %d: %s.", m.version, m.description)
+		err = s.applyMigration(m)
+		if err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %v", m.version, err)
+		}
+	}
+	return nil
+}
 
-	// _busy_timeout=5000&
-	db, _ = sql.Open(sqlDriver, "file:database.db")
-	db.SetMaxOpenConns(1)
-	//_, _ = db.Exec("PRAGMA journal_mode=WAL")
+// schemaVersion returns the version stored in the 'schema_version' table, 0 if the table is empty.
+func (s *sqliteStore) schemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRow(`SELECT MAX(version) FROM "schema_version"`).Scan(&version)
+	return int(version.Int64), err
 }
 
-// InitDatabase executes the command needed to initialize the database.
-func SQLiteInitDatabase() {
-	_, _ = db.Exec(`BEGIN TRANSACTION;
+// applyMigration runs @m and records its version in a single transaction.
+func (s *sqliteStore) applyMigration(m sqliteMigration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = m.apply(tx)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM "schema_version"`)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO "schema_version" (version) VALUES (?)`, m.version)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// createTables creates the tables as they were laid out before any versioning, databases created back then already have them.
+func createTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 	CREATE TABLE IF NOT EXISTS "learnt" (
 		"turn_id"	INTEGER UNIQUE,
 		"value"	TEXT,
@@ -41,8 +148,106 @@ func SQLiteInitDatabase() {
 		"seq"	INTEGER,
 		"value"	TEXT,
 		PRIMARY KEY("turn_id")
+	);`)
+	return err
+}
+
+// splitPromisedAndAccepted converts the 'proposal' table from the (pid, seq, value) columns to the current layout.
+// The old table kept a single number, the promised one: it becomes the promised number and, when a value was stored, also the accepted one.
+// The number under which the value was really accepted is lost, it's lower than or equal to the promised one: reporting the value under the promised number
+// never drops it, so that the turn ids in flight keep their values and a running cluster can be upgraded one node at a time.
+// Tables already having the current layout (created before schema versioning was introduced) are left untouched.
+func splitPromisedAndAccepted(tx *sql.Tx) error {
+	var legacyColumns int
+	err := tx.QueryRow("SELECT count(*) FROM pragma_table_info('proposal') WHERE name = 'pid'").Scan(&legacyColumns)
+	if err != nil || legacyColumns == 0 {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	ALTER TABLE "proposal" RENAME TO "proposal_legacy";
+	CREATE TABLE "proposal" (
+		"turn_id"	INTEGER UNIQUE,
+		"promised_pid"	INTEGER,
+		"promised_seq"	INTEGER,
+		"accepted_pid"	INTEGER,
+		"accepted_seq"	INTEGER,
+		"accepted_value"	TEXT,
+		PRIMARY KEY("turn_id")
 	);
-	COMMIT;`)
+	INSERT INTO "proposal" SELECT turn_id, pid, seq,
+		CASE WHEN value IS NULL THEN NULL ELSE pid END,
+		CASE WHEN value IS NULL THEN NULL ELSE seq END,
+		value
+	FROM "proposal_legacy";
+	DROP TABLE "proposal_legacy";`)
+	return err
+}
+
+// createSnapshotTable creates the 'snapshot' table, it holds at most one row: the current snapshot.
+func createSnapshotTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS "snapshot" (
+		"id"	INTEGER CHECK ("id" = 1),
+		"last_turn_id"	INTEGER NOT NULL,
+		"data"	TEXT NOT NULL,
+		PRIMARY KEY("id")
+	);`)
+	return err
+}
+
+// createRangePromiseTable creates the 'range_promise' table, it holds at most one row: the current range promise.
+func createRangePromiseTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS "range_promise" (
+		"id"	INTEGER CHECK ("id" = 1),
+		"from_turn_id"	INTEGER NOT NULL,
+		"promised_pid"	INTEGER NOT NULL,
+		"promised_seq"	INTEGER NOT NULL,
+		PRIMARY KEY("id")
+	);`)
+	return err
+}
+
+// createMetaTable creates the 'meta' table, it maps keys to the values describing the node itself.
+func createMetaTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS "meta" (
+		"key"	TEXT NOT NULL,
+		"value"	TEXT NOT NULL,
+		PRIMARY KEY("key")
+	);`)
+	return err
+}
+
+// proposalColumns lists the columns describing an acceptor state, in the order expected by scanAcceptorState.
+const proposalColumns = "promised_pid, promised_seq, accepted_pid, accepted_seq, accepted_value"
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAcceptorState scans a row made of the columns in @dest followed by proposalColumns and maps it onto an AcceptorState.
+// The returned boolean is true iff the promised number is valid (i.e. promised pid and seq are not NULL).
+func scanAcceptorState(row rowScanner, dest ...interface{}) (proposal.AcceptorState, bool, error) {
+	// sql.NullInt64, sql.NullString are "NULL-accepting" types
+	var promisedPid, promisedSeq, acceptedPid, acceptedSeq sql.NullInt64
+	var acceptedV sql.NullString
+
+	dest = append(dest, &promisedPid, &promisedSeq, &acceptedPid, &acceptedSeq, &acceptedV)
+	err := row.Scan(dest...)
+
+	state := proposal.AcceptorState{}
+	if err != nil || !promisedPid.Valid || !promisedSeq.Valid {
+		return state, false, err
+	}
+
+	state.Promised = proposal.Proposal{Pid: int(promisedPid.Int64), Seq: int(promisedSeq.Int64)}
+	if acceptedPid.Valid && acceptedSeq.Valid {
+		state.Accepted = proposal.Proposal{Pid: int(acceptedPid.Int64), Seq: int(acceptedSeq.Int64), V: acceptedV.String}
+	}
+	return state, true, nil
 }
 
 /*
@@ -51,51 +256,40 @@ func SQLiteInitDatabase() {
 # ========================================================= #
 */
 
-// GetProposal returns the entry of the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If the wanted proposal does not exist OR is not valid (i.e. pid and seq are 0)
-// an empty proposal is returned together with a false boolean value.
-// Please note that proposals with @pid or @seq = 0 should not exist, the user should not issue such values.
-// If the wanted proposal exists AND is valid, it will be returned together with a positive boolean value.
-// If the field 'v' is NULL, @v will be assigned the empty string "".
-// The entry will be mapped onto a proposal.Proposal object.
-func SQLiteGetProposal(turnID int) (proposal.Proposal, bool) {
-
-	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	row := db.QueryRow("SELECT pid, seq, value FROM proposal WHERE turn_id = ?", turnID)
-
-	// sql.NullInt64, sql.NullString are "NULL-accepting" types
-	var pid sql.NullInt64
-	var seq sql.NullInt64
-	var v sql.NullString
-
-	err := row.Scan(&pid, &seq, &v)
+// GetProposal returns the acceptor state stored for @turnID, see GetProposal in 'queries.go'.
+func (s *sqliteStore) GetProposal(turnID int) (proposal.AcceptorState, bool) {
+	state, ok, err := sqliteGetProposal(s.db, turnID)
 	if err != nil {
-		// sql.ErrNoRows
-		log.Printf("[QUERIES] -> No proposal found for turn id: %d; returning an empty proposal.", turnID)
+		log.Printf("[QUERIES] -> Could not read the proposal for turn id: %d; returning an empty proposal. Here's the error: %v", turnID, err)
 	}
+	return state, ok
+}
 
-	ok := false
-	p := proposal.Proposal{}
+// sqliteGetProposal reads the acceptor state for @turnID through @q, which is either the database or an open transaction.
+// Errors other than sql.ErrNoRows are returned so that transactions can be rolled back.
+func sqliteGetProposal(q sqlQuerier, turnID int) (proposal.AcceptorState, bool, error) {
 
-	if pid.Valid && seq.Valid {
-		// both pid and seq are not 0
-		ok = true
-		p = proposal.Proposal{Pid: int(pid.Int64), Seq: int(seq.Int64), V: v.String}
-	}
+	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
+	row := q.QueryRow("SELECT "+proposalColumns+" FROM proposal WHERE turn_id = ?", turnID)
 
-	// if saved proposal is invalid then p is empty and ok is false
-	// otherwise p is the saved proposal and ok is true
-	return p, ok
+	// if saved state is invalid then it is empty and ok is false
+	// otherwise it is the saved state and ok is true
+	state, ok, err := scanAcceptorState(row)
+	if err == sql.ErrNoRows {
+		log.Printf("[QUERIES] -> No proposal found for turn id: %d; returning an empty proposal.", turnID)
+		err = nil
+	}
+	return state, ok, err
 }
 
 // GetAllProposals returns a list of all the entries stored in the 'proposal' table.
 // Each entry is mapped onto a messages.ProposalWithTid object.
-func SQLiteGetAllProposals() []messages.ProposalWithTid {
+func (s *sqliteStore) GetAllProposals() []messages.ProposalWithTid {
 
 	var m []messages.ProposalWithTid
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	rows, err := db.Query("SELECT * FROM proposal ORDER BY turn_id")
+	rows, err := s.db.Query("SELECT turn_id, " + proposalColumns + " FROM proposal ORDER BY turn_id")
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -105,18 +299,13 @@ func SQLiteGetAllProposals() []messages.ProposalWithTid {
 		for rows.Next() {
 
 			var turnID int
-			var pid sql.NullInt64
-			var seq sql.NullInt64
-			var v sql.NullString
-
-			err := rows.Scan(&turnID, &pid, &seq, &v)
+			state, _, err := scanAcceptorState(rows, &turnID)
 
 			if err != nil {
 				log.Print("Error while scanning values: ", err.Error())
 			}
 
-			p := proposal.Proposal{Pid: int(pid.Int64), Seq: int(seq.Int64), V: v.String}
-			m = append(m, messages.ProposalWithTid{TurnID: turnID, Proposal: p})
+			m = append(m, messages.ProposalWithTid{TurnID: turnID, Proposal: state.Accepted, Promised: state.Promised})
 
 		}
 	}
@@ -124,53 +313,88 @@ func SQLiteGetAllProposals() []messages.ProposalWithTid {
 	return m
 }
 
-// SetProposal inserts/updates an entry in the 'proposal' table where the field 'turn_id' is equal to @turnID.
-// If isAcceptRequest is false, only the value "n" (i.e. Pid and Seq) will be overwritten, while "v" will be left untouched.
-// If isAcceptRequest is true, both "v" and "n" will be overwritten by the value requested.
-func SQLiteSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
+// SetProposal inserts/updates an entry in the 'proposal' table, see SetProposal in 'queries.go'.
+func (s *sqliteStore) SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
+	return sqliteSetProposal(s.db, turnID, p, isAcceptRequest)
+}
 
-	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	if p.V != "" {
-		if isAcceptRequest {
-			// is accept request
-			_, err = db.Exec("INSERT INTO proposal VALUES(?, ?, ?, ?) ON CONFLICT (turn_id) DO UPDATE SET pid = excluded.pid, seq = excluded.seq, value = excluded.value", turnID, p.Pid, p.Seq, p.V)
-		} else {
-			// is prepare request with non empty V. If the stored value is not NULL it will not be overwritten.
-			// coalesce returns the first non null argument passed to it.
-			_, err = db.Exec("INSERT INTO proposal VALUES(?, ?, ?, ?) ON CONFLICT (turn_id) DO UPDATE SET pid = excluded.pid, seq = excluded.seq, value = coalesce(value, excluded.value)", turnID, p.Pid, p.Seq, p.V)
-		}
+// sqliteSetProposal writes the proposal for @turnID through @q, which is either the database or an open transaction.
+func sqliteSetProposal(q sqlQuerier, turnID int, p proposal.Proposal, isAcceptRequest bool) (err error) {
 
+	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
+	if isAcceptRequest {
+		// is accept request, both the promised number and the accepted proposal are overwritten
+		_, err = q.Exec("INSERT INTO proposal VALUES(?, ?, ?, ?, ?, ?) ON CONFLICT (turn_id) DO UPDATE SET promised_pid = excluded.promised_pid, promised_seq = excluded.promised_seq, accepted_pid = excluded.accepted_pid, accepted_seq = excluded.accepted_seq, accepted_value = excluded.accepted_value", turnID, p.Pid, p.Seq, p.Pid, p.Seq, p.V)
 	} else {
-		// this can only be a prepare request, V is always non empty in accept requests
-		// this query prevents emptystring to be saved as V
-		_, err = db.Exec("INSERT INTO proposal VALUES(?, ?, ?, NULL) ON CONFLICT (turn_id) DO UPDATE SET pid = excluded.pid, seq = excluded.seq", turnID, p.Pid, p.Seq)
-
+		// is prepare request, only the promised number is overwritten. The accepted proposal (if any) is left untouched.
+		_, err = q.Exec("INSERT INTO proposal VALUES(?, ?, ?, NULL, NULL, NULL) ON CONFLICT (turn_id) DO UPDATE SET promised_pid = excluded.promised_pid, promised_seq = excluded.promised_seq", turnID, p.Pid, p.Seq)
 	}
 	return err
 }
 
+// PromiseIfHigher promises @p for @turnID only if it is strictly higher than the promised number.
+// The read and the write happen inside the same transaction.
+func (s *sqliteStore) PromiseIfHigher(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, false)
+}
+
+// AcceptIfNotLower accepts @p for @turnID only if it is higher than or equal to the promised number.
+// The read and the write happen inside the same transaction.
+func (s *sqliteStore) AcceptIfNotLower(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, true)
+}
+
+// compareAndSetProposal implements both PromiseIfHigher and AcceptIfNotLower.
+// Since the connection pool holds a single connection, concurrent transactions are serialized.
+func (s *sqliteStore) compareAndSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (oldState proposal.AcceptorState, ok bool, done bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return oldState, false, false, err
+	}
+
+	oldState, ok, err = sqliteGetProposal(tx, turnID)
+	if err != nil {
+		_ = tx.Rollback()
+		return oldState, ok, false, err
+	}
+
+	if ok && !proposalWins(p, oldState.Promised, isAcceptRequest) {
+		// nothing to write, the promised number wins
+		return oldState, ok, false, tx.Rollback()
+	}
+
+	err = sqliteSetProposal(tx, turnID, p, isAcceptRequest)
+	if err != nil {
+		_ = tx.Rollback()
+		return oldState, ok, false, err
+	}
+
+	err = tx.Commit()
+	return oldState, ok, err == nil, err
+}
+
 // ResetProposal deletes the entry from the 'proposal' table where the field 'turn_id' is equal to @turnID.
-func SQLiteResetProposal(turnID int) error {
+func (s *sqliteStore) ResetProposal(turnID int) error {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	_, err := db.Exec("DELETE FROM proposal WHERE turn_id = ?", turnID)
+	_, err := s.db.Exec("DELETE FROM proposal WHERE turn_id = ?", turnID)
 	return err
 }
 
 // ResetAllProposals empties the `proposal` table.
-func SQLiteResetAllProposals() error {
+func (s *sqliteStore) ResetAllProposals() error {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	_, err := db.Exec("DELETE FROM proposal")
+	_, err := s.db.Exec("DELETE FROM proposal")
 	return err
 }
 
 // GetProposalsTurnID is a map used as a set, the keys are the turnIDs of the proposals we know.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
-func SQLiteGetProposalsTurnID() *map[int]bool {
+func (s *sqliteStore) GetProposalsTurnID() *map[int]bool {
 
 	proposalsTurnID := make(map[int]bool)
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	rows, err := db.Query("SELECT turn_id FROM proposal ORDER BY turn_id ASC")
+	rows, err := s.db.Query("SELECT turn_id FROM proposal ORDER BY turn_id ASC")
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -192,13 +416,13 @@ func SQLiteGetProposalsTurnID() *map[int]bool {
 }
 
 // GetDanglingProposals returns a map of the proposals found in the 'proposal' table whose turn ID does not have an entry 'learnt' table.
-// The map uses the turn ID as the key and a Proposal object as the value.
-func SQLiteGetDanglingProposals() *map[int]proposal.Proposal {
+// The map uses the turn ID as the key and an AcceptorState object as the value.
+func (s *sqliteStore) GetDanglingProposals() *map[int]proposal.AcceptorState {
 
-	danglingProposals := make(map[int]proposal.Proposal)
+	danglingProposals := make(map[int]proposal.AcceptorState)
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	rows, err := db.Query("SELECT p.turn_id, p.pid, p.seq, p.value FROM proposal as p LEFT JOIN learnt as l ON p.turn_id = l.turn_id WHERE l.turn_id is NULL")
+	rows, err := s.db.Query("SELECT p.turn_id, p.promised_pid, p.promised_seq, p.accepted_pid, p.accepted_seq, p.accepted_value FROM proposal as p LEFT JOIN learnt as l ON p.turn_id = l.turn_id WHERE l.turn_id is NULL")
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -207,14 +431,11 @@ func SQLiteGetDanglingProposals() *map[int]proposal.Proposal {
 	} else {
 		for rows.Next() {
 			var turnID int
-			var pid int
-			var seq int
-			var v sql.NullString
-			err := rows.Scan(&turnID, &pid, &seq, &v)
+			state, _, err := scanAcceptorState(rows, &turnID)
 			if err != nil {
 				log.Print("scanning into  turn_id failed: ", err.Error())
 			} else {
-				danglingProposals[turnID] = proposal.Proposal{Pid: pid, Seq: seq, V: v.String}
+				danglingProposals[turnID] = state
 			}
 
 		}
@@ -231,9 +452,9 @@ func SQLiteGetDanglingProposals() *map[int]proposal.Proposal {
 
 // GetLearntValue returns the 'v' field of the 'learnt' table where the field 'turn_id' is equal to @turnID.
 // If no value has been learnt for the requested @turnID, an empty string is returned.
-func SQLiteGetLearntValue(turnID int) string {
+func (s *sqliteStore) GetLearntValue(turnID int) string {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	row := db.QueryRow("SELECT value FROM learnt WHERE turn_id = ?", turnID)
+	row := s.db.QueryRow("SELECT value FROM learnt WHERE turn_id = ?", turnID)
 
 	var v sql.NullString
 	err := row.Scan(&v)
@@ -244,58 +465,70 @@ func SQLiteGetLearntValue(turnID int) string {
 	return v.String
 }
 
-// SetLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
-// If the requested @turnID does not exist, a new entry is created.
-// If the learnt value for the requested @turnID is already present, it will be overwritten. (why?)
-func SQLiteSetLearntValue(turnID int, v string) (err error) {
-	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	_, err = db.Exec("INSERT INTO learnt VALUES(?, ?) ON CONFLICT (turn_id) DO UPDATE SET value = excluded.value", turnID, v)
+// SetLearntValue inserts an entry in the 'learnt' table, see SetLearntValue in 'queries.go'.
+// An existing entry is never updated, when the insert is ignored the stored value is compared against @v.
+func (s *sqliteStore) SetLearntValue(turnID int, v string) (err error) {
+	res, err := s.db.Exec("INSERT INTO learnt VALUES(?, ?) ON CONFLICT (turn_id) DO NOTHING", turnID, v)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		// learnt values are never updated (but by OverrideLearntValue) so the value read here is the one that prevented the insert
+		var currentV sql.NullString
+		err = s.db.QueryRow("SELECT value FROM learnt WHERE turn_id = ?", turnID).Scan(&currentV)
+		if err != nil {
+			return err
+		}
+		if currentV.String != v {
+			return &LearntConflictError{TurnID: turnID, Current: currentV.String, Proposed: v}
+		}
+		return nil
+	}
 
 	// counting how many rows in learnt table so i can notify some listener that i learnt all turn_ids
 	// it is needed for testing and benchmarking purposes
 	var howMany int
-	res := db.QueryRow("SELECT count(*) as count FROM learnt")
-	err = res.Scan(&howMany)
-	if err != nil {
-		// do nothing
-	} else {
-		if howMany == config.CONF.NUMBER_OF_TIDS {
-			now := time.Now()
-			sec := now.Unix()
-			go func() {
-				_, err := http.Get(fmt.Sprintf("%s/timer?nid=%d&timestamp=%d&how_many=%d", config.CONF.LISTENER_IP, config.CONF.PID, sec, howMany))
-				if err != nil {
-					log.Printf("Errore nella richiesta di salvataggio del timer: %v", err.Error())
-				}
-			}()
-		}
+	row := s.db.QueryRow("SELECT count(*) as count FROM learnt")
+	if row.Scan(&howMany) == nil {
+		notifyListener(howMany)
 	}
 
+	return nil
+}
+
+// OverrideLearntValue inserts/updates an entry in the 'learnt' table where the field 'turn_id' is equal to @turnID.
+func (s *sqliteStore) OverrideLearntValue(turnID int, v string) error {
+	_, err := s.db.Exec("INSERT INTO learnt VALUES(?, ?) ON CONFLICT (turn_id) DO UPDATE SET value = excluded.value", turnID, v)
 	return err
 }
 
 // ResetLearntValue deletes the entry from the 'learnt' table where the field 'turn_id' is equal to @turnID.
-func SQLiteResetLearntValue(turnID int) error {
+func (s *sqliteStore) ResetLearntValue(turnID int) error {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	_, err := db.Exec("DELETE FROM learnt WHERE turn_id = ?", turnID)
+	_, err := s.db.Exec("DELETE FROM learnt WHERE turn_id = ?", turnID)
 	return err
 }
 
 // ResetAllLearntValues empties the `learnt` table.
-func SQLiteResetAllLearntValues() error {
+func (s *sqliteStore) ResetAllLearntValues() error {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	_, err := db.Exec("DELETE FROM learnt")
+	_, err := s.db.Exec("DELETE FROM learnt")
 	return err
 }
 
 // GetAllLearntValues returns a list of all the entries stored in the 'learnt' table.
 // Each entry is mapped onto a LearntWithTid object.
-func SQLiteGetAllLearntValues() []messages.LearntWithTid {
+func (s *sqliteStore) GetAllLearntValues() []messages.LearntWithTid {
 
 	var m []messages.LearntWithTid
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	rows, err := db.Query("SELECT * FROM learnt ORDER BY turn_id")
+	rows, err := s.db.Query("SELECT * FROM learnt ORDER BY turn_id")
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -346,9 +579,9 @@ func SQLiteGetMissingTurnIDs() []int {
 
 // GetLastTurnID returns the highest turn ID found in the `learnt` table.
 // 0 is returned if table is empty.
-func SQLiteGetLastTurnID() int {
+func (s *sqliteStore) GetLastTurnID() int {
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	row := db.QueryRow("SELECT turn_id FROM learnt ORDER BY turn_id DESC")
+	row := s.db.QueryRow("SELECT turn_id FROM learnt ORDER BY turn_id DESC")
 
 	var lastID int
 
@@ -361,12 +594,12 @@ func SQLiteGetLastTurnID() int {
 
 // GetLearntValuesTurnID is a map used as a set, the keys are the turnIDs of the learnt values.
 // map[int]interface{} is said to be more efficient than map[int]bool, doesn't really matter.
-func SQLiteGetLearntValuesTurnID() *map[int]bool {
+func (s *sqliteStore) GetLearntValuesTurnID() *map[int]bool {
 
 	learntValuesTurnID := make(map[int]bool)
 
 	//db, _ := sql.Open(sqlDriver, config.CONF.DB_PATH)
-	rows, err := db.Query("SELECT turn_id FROM learnt ORDER BY turn_id ASC")
+	rows, err := s.db.Query("SELECT turn_id FROM learnt ORDER BY turn_id ASC")
 	if rows != nil {
 		defer rows.Close()
 	}
@@ -386,3 +619,126 @@ func SQLiteGetLearntValuesTurnID() *map[int]bool {
 	}
 	return &learntValuesTurnID
 }
+
+/*
+# ========================================================= #
+#                     SNAPSHOT QUERIES                      #
+# ========================================================= #
+*/
+
+// GetSnapshot returns the snapshot stored in the 'snapshot' table, an empty snapshot if none has been stored.
+func (s *sqliteStore) GetSnapshot() (messages.Snapshot, error) {
+	snap := messages.Snapshot{Values: make(map[int]string)}
+
+	var data string
+	err := s.db.QueryRow("SELECT last_turn_id, data FROM snapshot WHERE id = 1").Scan(&snap.LastTurnID, &data)
+	if err == sql.ErrNoRows {
+		return snap, nil
+	}
+	if err != nil {
+		return snap, err
+	}
+
+	err = json.Unmarshal([]byte(data), &snap.Values)
+	return snap, err
+}
+
+// Compact stores @snap in the 'snapshot' table and deletes the entries of the 'learnt' and 'proposal' tables up to its checkpoint, in a single transaction.
+func (s *sqliteStore) Compact(snap messages.Snapshot) error {
+	data, err := json.Marshal(snap.Values)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO snapshot VALUES(1, ?, ?) ON CONFLICT (id) DO UPDATE SET last_turn_id = excluded.last_turn_id, data = excluded.data", snap.LastTurnID, string(data))
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM learnt WHERE turn_id <= ?", snap.LastTurnID)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM proposal WHERE turn_id <= ?", snap.LastTurnID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+/*
+# ========================================================= #
+#                   RANGE PROMISE QUERIES                   #
+# ========================================================= #
+*/
+
+// GetRangePromise returns the range promise stored in the 'range_promise' table, a null range promise if none has been stored.
+func (s *sqliteStore) GetRangePromise() (messages.RangePromise, error) {
+	rp := messages.RangePromise{}
+
+	err := s.db.QueryRow("SELECT from_turn_id, promised_pid, promised_seq FROM range_promise WHERE id = 1").Scan(&rp.FromTurnID, &rp.Promised.Pid, &rp.Promised.Seq)
+	if err == sql.ErrNoRows {
+		return rp, nil
+	}
+	return rp, err
+}
+
+// SetRangePromise stores @rp in the 'range_promise' table, replacing the previous one.
+func (s *sqliteStore) SetRangePromise(rp messages.RangePromise) error {
+	_, err := s.db.Exec("INSERT INTO range_promise VALUES(1, ?, ?, ?) ON CONFLICT (id) DO UPDATE SET from_turn_id = excluded.from_turn_id, promised_pid = excluded.promised_pid, promised_seq = excluded.promised_seq",
+		rp.FromTurnID, rp.Promised.Pid, rp.Promised.Seq)
+	return err
+}
+
+/*
+# ========================================================= #
+#                       META QUERIES                        #
+# ========================================================= #
+*/
+
+// GetMeta returns the value stored under @key in the 'meta' table, an empty string if none has been stored.
+func (s *sqliteStore) GetMeta(key string) (string, error) {
+	var value string
+
+	err := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetMeta stores @value under @key in the 'meta' table, replacing the previous one.
+func (s *sqliteStore) SetMeta(key string, value string) error {
+	_, err := s.db.Exec("INSERT INTO meta VALUES(?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
+// DeleteMeta deletes the row of @key from the 'meta' table.
+func (s *sqliteStore) DeleteMeta(key string) error {
+	_, err := s.db.Exec("DELETE FROM meta WHERE key = ?", key)
+	return err
+}
+
+// ListMeta returns the rows of the 'meta' table whose key starts with @prefix.
+func (s *sqliteStore) ListMeta(prefix string) (map[string]string, error) {
+	// substr instead of LIKE, the prefix might hold wildcards
+	rows, err := s.db.Query("SELECT key, value FROM meta WHERE substr(key, 1, length(?)) = ?", prefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, rows.Err()
+}
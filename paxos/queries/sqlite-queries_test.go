@@ -0,0 +1,58 @@
+package queries
+
+import (
+	"database/sql"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/proposal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteMigratesLegacyProposals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-paxos-queries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.CONF.DB_PATH = filepath.Join(dir, "database.db")
+	config.CONF.DB_JOURNAL_MODE = "WAL"
+	config.CONF.DB_BUSY_TIMEOUT = 5000
+
+	// a database written before schema versioning: a single number per turn id, and a value in flight for turn id 1
+	db, err := sql.Open(sqlDriver, config.CONF.DB_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`
+	CREATE TABLE "learnt" ("turn_id" INTEGER UNIQUE, "value" TEXT, PRIMARY KEY("turn_id"));
+	CREATE TABLE "proposal" ("turn_id" INTEGER UNIQUE, "pid" INTEGER, "seq" INTEGER, "value" TEXT, PRIMARY KEY("turn_id"));
+	INSERT INTO "proposal" VALUES (1, 1, 2, 'in flight'), (2, 2, 3, NULL), (3, 1, 1, 'learnt');
+	INSERT INTO "learnt" VALUES (3, 'learnt');`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PrepareDBConn("sqlite")
+	if err == nil {
+		err = InitDatabase()
+	}
+	if err != nil {
+		t.Fatalf("a database with a value in flight could not be migrated: %v", err)
+	}
+	defer closeStore()
+
+	state, ok := GetProposal(1)
+	if !ok || !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 2}) || state.Accepted.V != "in flight" || !state.Accepted.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 2}) {
+		t.Fatalf("migrated state of turn id 1: %+v (ok = %v), want (1, 2) promised and 'in flight' accepted under (1, 2)", state, ok)
+	}
+	state, ok = GetProposal(2)
+	if !ok || !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 2, Seq: 3}) || state.HasAccepted() {
+		t.Fatalf("migrated state of turn id 2: %+v (ok = %v), want (2, 3) promised and nothing accepted", state, ok)
+	}
+	if v := GetLearntValue(3); v != "learnt" {
+		t.Fatalf("learnt value of turn id 3: '%s', want 'learnt'", v)
+	}
+}
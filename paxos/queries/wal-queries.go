@@ -0,0 +1,519 @@
+// Package queries implements all the queries needed by this specific implementation of the Paxos algorithm.
+package queries
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walStore is the Store backed by an append-only log file (write-ahead log).
+// Every write is appended to the file as a walRecord and fsync'ed before being applied to an in-memory index (a memoryStore),
+// reads are served by the index only. When the node starts the index is rebuilt by replaying the whole file.
+// Since promises and accepts are on disk before the acceptor replies, the acceptor state survives crashes.
+type walStore struct {
+	mu     sync.Mutex // mu serializes the writes, so that records are appended in the same order they are applied.
+	file   *os.File
+	mem    *memoryStore
+	failed error // failed is set when a failed write could not be rolled back, nothing is appended to the file anymore.
+}
+
+func init() {
+	Register("wal", func() Store { return &walStore{mem: newMemoryStore()} })
+}
+
+// walRecord is a single line of the log, encoded as json.
+type walRecord struct {
+	Op           string                 `json:"op"` // Op is one of the wal* constants below.
+	TurnID       int                    `json:"turn_id,omitempty"`
+	Proposal     *proposal.Proposal     `json:"proposal,omitempty"`  // Proposal is only set by walSetProposal records.
+	IsAccept     bool                   `json:"is_accept,omitempty"` // IsAccept tells whether a walSetProposal record comes from an accept request.
+	V            string                 `json:"v,omitempty"`
+	Snapshot     *messages.Snapshot     `json:"snapshot,omitempty"`      // Snapshot is only set by walCompact records.
+	RangePromise *messages.RangePromise `json:"range_promise,omitempty"` // RangePromise is only set by walSetRangePromise records.
+	Key          string                 `json:"key,omitempty"`           // Key is only set by walSetMeta and walDeleteMeta records, the value is in V.
+}
+
+const (
+	walSetProposal       = "set_proposal"
+	walResetProposal     = "reset_proposal"
+	walResetAllProposals = "reset_all_proposals"
+	walSetLearnt         = "set_learnt"
+	walResetLearnt       = "reset_learnt"
+	walResetAllLearnt    = "reset_all_learnt"
+	walCompact           = "compact"
+	walSetRangePromise   = "set_range_promise"
+	walSetMeta           = "set_meta"
+	walDeleteMeta        = "delete_meta"
+)
+
+// PrepareDBConn opens (or creates) the log file located at WAL_PATH and rebuilds the in-memory index from it.
+func (s *walStore) PrepareDBConn() (err error) {
+	_, statErr := os.Stat(config.CONF.WAL_PATH)
+	isNew := os.IsNotExist(statErr)
+
+	s.file, err = os.OpenFile(config.CONF.WAL_PATH, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if isNew {
+		// the new directory entry has to be durable as well, otherwise the whole file might disappear after a crash
+		err = syncDir(filepath.Dir(config.CONF.WAL_PATH))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.replay()
+}
+
+// InitDatabase does nothing, the log file is created by PrepareDBConn.
+func (s *walStore) InitDatabase() error {
+	return nil
+}
+
+// syncDir fsyncs the directory @dir.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// replay applies every record of the log file to the in-memory index and leaves the file offset at its end.
+// A last record without the trailing newline has been torn by a crash while being written: it was never fsync'ed,
+// so no reply depended on it, and it is truncated. Any other record which cannot be decoded is an error.
+func (s *walStore) replay() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+	var records int
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				log.Printf("[QUERIES] -> Truncating a torn record at offset %d of %s.", offset, config.CONF.WAL_PATH)
+				err = s.file.Truncate(offset)
+				if err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := walRecord{}
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return fmt.Errorf("corrupted record at offset %d of %s: %v", offset, config.CONF.WAL_PATH, err)
+		}
+		err = s.apply(record)
+		if err != nil {
+			return fmt.Errorf("invalid record at offset %d of %s: %v", offset, config.CONF.WAL_PATH, err)
+		}
+
+		offset += int64(len(line))
+		records++
+	}
+
+	log.Printf("[QUERIES] -> Replayed %d record(s) from %s.", records, config.CONF.WAL_PATH)
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// apply applies @record to the in-memory index, learnt values are applied without notifying the listener.
+func (s *walStore) apply(record walRecord) error {
+	switch record.Op {
+	case walSetProposal:
+		if record.Proposal == nil {
+			return fmt.Errorf("%s record without a proposal", record.Op)
+		}
+		return s.mem.SetProposal(record.TurnID, *record.Proposal, record.IsAccept)
+	case walResetProposal:
+		return s.mem.ResetProposal(record.TurnID)
+	case walResetAllProposals:
+		return s.mem.ResetAllProposals()
+	case walSetLearnt:
+		return s.mem.OverrideLearntValue(record.TurnID, record.V)
+	case walResetLearnt:
+		return s.mem.ResetLearntValue(record.TurnID)
+	case walResetAllLearnt:
+		return s.mem.ResetAllLearntValues()
+	case walCompact:
+		if record.Snapshot == nil {
+			return fmt.Errorf("%s record without a snapshot", record.Op)
+		}
+		return s.mem.Compact(*record.Snapshot)
+	case walSetRangePromise:
+		if record.RangePromise == nil {
+			return fmt.Errorf("%s record without a range promise", record.Op)
+		}
+		return s.mem.SetRangePromise(*record.RangePromise)
+	case walSetMeta:
+		return s.mem.SetMeta(record.Key, record.V)
+	case walDeleteMeta:
+		return s.mem.DeleteMeta(record.Key)
+	}
+	return fmt.Errorf("unknown op %q", record.Op)
+}
+
+// write appends @record to the log file, fsyncs it and only then applies it to the in-memory index.
+// When the write or the fsync fails the file is truncated back to its size before the write, so that no partial record
+// is left in the middle of the log; if even that fails the store refuses any further write. The caller must hold s.mu.
+func (s *walStore) write(record walRecord) error {
+	if s.failed != nil {
+		return fmt.Errorf("the log file %s is not writable anymore: %v", config.CONF.WAL_PATH, s.failed)
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.rollback(offset)
+		return err
+	}
+
+	return s.apply(record)
+}
+
+// rollback truncates the log file back to @offset after a failed write, and moves the file offset there.
+// When the file cannot be truncated the store is marked as failed. The caller must hold s.mu.
+func (s *walStore) rollback(offset int64) {
+	err := s.file.Truncate(offset)
+	if err == nil {
+		_, err = s.file.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		log.Printf("[QUERIES] -> Could not roll back a failed write at offset %d of %s, refusing any further write: %v", offset, config.CONF.WAL_PATH, err)
+		s.failed = err
+	}
+}
+
+/*
+# ========================================================= #
+#                     PROPOSAL QUERIES                      #
+# ========================================================= #
+*/
+
+// GetProposal returns the proposal stored for @turnID, see GetProposal in 'queries.go'.
+func (s *walStore) GetProposal(turnID int) (proposal.AcceptorState, bool) {
+	return s.mem.GetProposal(turnID)
+}
+
+// GetAllProposals returns all the stored proposals ordered by turn id.
+func (s *walStore) GetAllProposals() []messages.ProposalWithTid {
+	return s.mem.GetAllProposals()
+}
+
+// SetProposal inserts/updates the proposal stored for @turnID, see SetProposal in 'queries.go'.
+func (s *walStore) SetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walSetProposal, TurnID: turnID, Proposal: &p, IsAccept: isAcceptRequest})
+}
+
+// ResetProposal deletes the proposal stored for @turnID.
+func (s *walStore) ResetProposal(turnID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walResetProposal, TurnID: turnID})
+}
+
+// ResetAllProposals deletes all the stored proposals.
+func (s *walStore) ResetAllProposals() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walResetAllProposals})
+}
+
+// GetProposalsTurnID returns the set of the turn ids having a proposal.
+func (s *walStore) GetProposalsTurnID() *map[int]bool {
+	return s.mem.GetProposalsTurnID()
+}
+
+// GetDanglingProposals returns the proposals whose turn id has no learnt value.
+func (s *walStore) GetDanglingProposals() *map[int]proposal.AcceptorState {
+	return s.mem.GetDanglingProposals()
+}
+
+// PromiseIfHigher promises @p for @turnID only if it is strictly higher than the promised number.
+// The promise is on disk when this function returns.
+func (s *walStore) PromiseIfHigher(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, false)
+}
+
+// AcceptIfNotLower accepts @p for @turnID only if it is higher than or equal to the promised number.
+// The accepted proposal is on disk when this function returns.
+func (s *walStore) AcceptIfNotLower(turnID int, p proposal.Proposal) (proposal.AcceptorState, bool, bool, error) {
+	return s.compareAndSetProposal(turnID, p, true)
+}
+
+// compareAndSetProposal implements both PromiseIfHigher and AcceptIfNotLower, holding s.mu for the whole operation.
+func (s *walStore) compareAndSetProposal(turnID int, p proposal.Proposal, isAcceptRequest bool) (proposal.AcceptorState, bool, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mem.mu.Lock()
+	oldState, ok := s.mem.proposals[turnID]
+	s.mem.mu.Unlock()
+
+	if ok && !proposalWins(p, oldState.Promised, isAcceptRequest) {
+		return oldState, ok, false, nil
+	}
+
+	err := s.write(walRecord{Op: walSetProposal, TurnID: turnID, Proposal: &p, IsAccept: isAcceptRequest})
+	if err != nil {
+		return oldState, ok, false, err
+	}
+	return oldState, ok, true, nil
+}
+
+/*
+# ========================================================= #
+#                   LEARNT VALUE QUERIES                    #
+# ========================================================= #
+*/
+
+// GetLearntValue returns the value learnt for @turnID, or "" when nothing has been learnt.
+func (s *walStore) GetLearntValue(turnID int) string {
+	return s.mem.GetLearntValue(turnID)
+}
+
+// SetLearntValue inserts the value learnt for @turnID, see SetLearntValue in 'queries.go'.
+func (s *walStore) SetLearntValue(turnID int, v string) error {
+	s.mu.Lock()
+
+	s.mem.mu.Lock()
+	currentV, ok := s.mem.learnt[turnID]
+	s.mem.mu.Unlock()
+
+	if ok {
+		s.mu.Unlock()
+		if currentV != v {
+			return &LearntConflictError{TurnID: turnID, Current: currentV, Proposed: v}
+		}
+		return nil
+	}
+
+	err := s.write(walRecord{Op: walSetLearnt, TurnID: turnID, V: v})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	notifyListener(len(*s.mem.GetLearntValuesTurnID()))
+	return nil
+}
+
+// OverrideLearntValue inserts/updates the value learnt for @turnID.
+func (s *walStore) OverrideLearntValue(turnID int, v string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walSetLearnt, TurnID: turnID, V: v})
+}
+
+// ResetLearntValue deletes the value learnt for @turnID.
+func (s *walStore) ResetLearntValue(turnID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walResetLearnt, TurnID: turnID})
+}
+
+// ResetAllLearntValues deletes all the learnt values.
+func (s *walStore) ResetAllLearntValues() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walResetAllLearnt})
+}
+
+// GetAllLearntValues returns all the learnt values ordered by turn id.
+func (s *walStore) GetAllLearntValues() []messages.LearntWithTid {
+	return s.mem.GetAllLearntValues()
+}
+
+// GetLastTurnID returns the highest turn id having a learnt value, 0 if none.
+func (s *walStore) GetLastTurnID() int {
+	return s.mem.GetLastTurnID()
+}
+
+// GetLearntValuesTurnID returns the set of the turn ids having a learnt value.
+func (s *walStore) GetLearntValuesTurnID() *map[int]bool {
+	return s.mem.GetLearntValuesTurnID()
+}
+
+/*
+# ========================================================= #
+#                     SNAPSHOT QUERIES                      #
+# ========================================================= #
+*/
+
+// GetSnapshot returns the stored snapshot.
+func (s *walStore) GetSnapshot() (messages.Snapshot, error) {
+	return s.mem.GetSnapshot()
+}
+
+// Compact replaces the log file with a new one starting with @snap and holding only the state following its checkpoint.
+// The new file is fsync'ed and renamed over the old one, a crash at any point leaves either the old or the new log in place.
+func (s *walStore) Compact(snap messages.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := []walRecord{{Op: walCompact, Snapshot: &snap}}
+
+	s.mem.mu.Lock()
+	if rp := s.mem.rp; rp.Promised.Pid != 0 || rp.Promised.Seq != 0 {
+		records = append(records, walRecord{Op: walSetRangePromise, RangePromise: &rp})
+	}
+	for key, value := range s.mem.meta {
+		records = append(records, walRecord{Op: walSetMeta, Key: key, V: value})
+	}
+	for _, turnID := range sortedKeys(s.mem.proposalsTurnID()) {
+		if turnID <= snap.LastTurnID {
+			continue
+		}
+		// the accepted proposal first, then the promised number which might be higher
+		state := s.mem.proposals[turnID]
+		if state.HasAccepted() {
+			accepted := state.Accepted
+			records = append(records, walRecord{Op: walSetProposal, TurnID: turnID, Proposal: &accepted, IsAccept: true})
+		}
+		promised := state.Promised
+		records = append(records, walRecord{Op: walSetProposal, TurnID: turnID, Proposal: &promised})
+	}
+	for _, turnID := range sortedKeys(s.mem.learntValuesTurnID()) {
+		if turnID > snap.LastTurnID {
+			records = append(records, walRecord{Op: walSetLearnt, TurnID: turnID, V: s.mem.learnt[turnID]})
+		}
+	}
+	s.mem.mu.Unlock()
+
+	err := s.rewrite(records)
+	if err != nil {
+		return err
+	}
+	return s.mem.Compact(snap)
+}
+
+// rewrite atomically replaces the log file with a new one holding @records. The caller must hold s.mu.
+func (s *walStore) rewrite(records []walRecord) error {
+	tmpPath := config.CONF.WAL_PATH + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer) // Encode terminates every record with '\n'
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, config.CONF.WAL_PATH)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	_ = s.file.Close()
+	s.file = tmp
+	_, err = s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(config.CONF.WAL_PATH))
+}
+
+/*
+# ========================================================= #
+#                   RANGE PROMISE QUERIES                   #
+# ========================================================= #
+*/
+
+// GetRangePromise returns the stored range promise.
+func (s *walStore) GetRangePromise() (messages.RangePromise, error) {
+	return s.mem.GetRangePromise()
+}
+
+// SetRangePromise appends @rp to the log, replacing the previous range promise.
+func (s *walStore) SetRangePromise(rp messages.RangePromise) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walSetRangePromise, RangePromise: &rp})
+}
+
+/*
+# ========================================================= #
+#                       META QUERIES                        #
+# ========================================================= #
+*/
+
+// GetMeta returns the value stored under @key, an empty string if none has been stored.
+func (s *walStore) GetMeta(key string) (string, error) {
+	return s.mem.GetMeta(key)
+}
+
+// SetMeta appends (@key, @value) to the log, replacing the previous value of @key.
+func (s *walStore) SetMeta(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walSetMeta, Key: key, V: value})
+}
+
+// DeleteMeta appends the deletion of @key to the log.
+func (s *walStore) DeleteMeta(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(walRecord{Op: walDeleteMeta, Key: key})
+}
+
+// ListMeta returns the values stored under the keys starting with @prefix.
+func (s *walStore) ListMeta(prefix string) (map[string]string, error) {
+	return s.mem.ListMeta(prefix)
+}
@@ -0,0 +1,156 @@
+package queries
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/proposal"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// reopenWal closes the "wal" backend in use and opens it again from its log file, as a restart of the node does.
+func reopenWal(t *testing.T) {
+	t.Helper()
+
+	closeStore()
+	err := PrepareDBConn("wal")
+	if err == nil {
+		err = InitDatabase()
+	}
+	if err != nil {
+		t.Fatalf("could not reopen the log file: %v", err)
+	}
+}
+
+// appendToWal appends @data to the log file, as a crash in the middle of a write leaves it.
+func appendToWal(t *testing.T, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(config.CONF.WAL_PATH, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalReplayAfterTornWrite(t *testing.T) {
+	defer openStore(t, "wal")()
+
+	_, _, promised, err := PromiseIfHigher(1, proposal.Proposal{Pid: 1, Seq: 1})
+	if err != nil || !promised {
+		t.Fatalf("prepare request: promised = %v, err = %v", promised, err)
+	}
+	_, _, accepted, err := AcceptIfNotLower(1, proposal.Proposal{Pid: 1, Seq: 1, V: "a"})
+	if err != nil || !accepted {
+		t.Fatalf("accept request: accepted = %v, err = %v", accepted, err)
+	}
+	err = SetLearntValue(1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetMeta("key", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(config.CONF.WAL_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intact := info.Size()
+
+	// the last record has been torn: no trailing newline
+	appendToWal(t, `{"op":"set_proposal","turn_id":2,"proposal":{"pid":1,"se`)
+	reopenWal(t)
+
+	state, ok := GetProposal(1)
+	if !ok || state.Accepted.V != "a" || !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 1, Seq: 1}) {
+		t.Fatalf("replayed state of turn id 1: %+v (ok = %v), want (1, 1) 'a' accepted", state, ok)
+	}
+	if _, ok := GetProposal(2); ok {
+		t.Fatal("the torn record has been replayed")
+	}
+	if v := GetLearntValue(1); v != "a" {
+		t.Fatalf("replayed learnt value of turn id 1: '%s', want 'a'", v)
+	}
+	if v, _ := GetMeta("key"); v != "value" {
+		t.Fatalf("replayed meta value: '%s', want 'value'", v)
+	}
+
+	info, err = os.Stat(config.CONF.WAL_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != intact {
+		t.Fatalf("log file size after the replay: %d, want the torn record truncated (%d)", info.Size(), intact)
+	}
+
+	// the records written after the truncation are replayed as well
+	_, _, promised, err = PromiseIfHigher(2, proposal.Proposal{Pid: 2, Seq: 1})
+	if err != nil || !promised {
+		t.Fatalf("prepare request after the replay: promised = %v, err = %v", promised, err)
+	}
+	reopenWal(t)
+	state, ok = GetProposal(2)
+	if !ok || !state.Promised.IsEqualTo(&proposal.Proposal{Pid: 2, Seq: 1}) {
+		t.Fatalf("replayed state of turn id 2: %+v (ok = %v), want promised (2, 1)", state, ok)
+	}
+}
+
+func TestWalReplayRejectsCorruptedRecord(t *testing.T) {
+	defer openStore(t, "wal")()
+
+	err := SetMeta("key", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeStore()
+
+	// a complete but undecodable record is not a torn write, it must not be dropped silently
+	appendToWal(t, "not a record\n")
+	data, err := ioutil.ReadFile(config.CONF.WAL_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PrepareDBConn("wal")
+	if err == nil {
+		t.Fatal("a corrupted record has been replayed without an error")
+	}
+
+	after, err := ioutil.ReadFile(config.CONF.WAL_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(data) {
+		t.Fatal("the log file has been changed by a failed replay")
+	}
+}
+
+func TestWalReplaysCheckpoint(t *testing.T) {
+	defer openStore(t, "wal")()
+
+	for turnID := 1; turnID <= 3; turnID++ {
+		err := SetLearntValue(turnID, fmt.Sprintf("v%d", turnID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := Checkpoint(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopenWal(t)
+	if checkpoint := GetCheckpoint(); checkpoint != 3 {
+		t.Fatalf("replayed checkpoint at turn id %d, want 3", checkpoint)
+	}
+	if v := GetLearntValue(2); v != "v2" {
+		t.Fatalf("replayed value of turn id 2: '%s', want 'v2'", v)
+	}
+}
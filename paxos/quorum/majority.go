@@ -0,0 +1,46 @@
+package quorum
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+)
+
+// majority is the "majority" quorum system: any PHASE1_QUORUM acceptors are a phase-1 quorum, any PHASE2_QUORUM acceptors are a phase-2 quorum.
+// With the default sizes both are plain majorities, smaller accept quorums are allowed as long as PHASE1_QUORUM + PHASE2_QUORUM > N (Flexible Paxos).
+type majority struct {
+	nodes  []string
+	phase1 int
+	phase2 int
+}
+
+func init() {
+	Register("majority", newMajority)
+}
+
+// newMajority builds the "majority" quorum system out of the N acceptors, the sizes must be between 1 and N and add up to more than N.
+func newMajority(c *config.Conf) (System, error) {
+	n := len(c.ACCEPTORS)
+
+	if c.PHASE1_QUORUM < 1 || c.PHASE1_QUORUM > n {
+		return nil, fmt.Errorf("phase1_quorum must be between 1 and %d (the number of acceptors), got %d", n, c.PHASE1_QUORUM)
+	}
+	if c.PHASE2_QUORUM < 1 || c.PHASE2_QUORUM > n {
+		return nil, fmt.Errorf("phase2_quorum must be between 1 and %d (the number of acceptors), got %d", n, c.PHASE2_QUORUM)
+	}
+	if c.PHASE1_QUORUM+c.PHASE2_QUORUM <= n {
+		return nil, fmt.Errorf("phase1_quorum + phase2_quorum must exceed the number of acceptors (%d), got %d + %d", n, c.PHASE1_QUORUM, c.PHASE2_QUORUM)
+	}
+	return &majority{nodes: c.ACCEPTORS, phase1: c.PHASE1_QUORUM, phase2: c.PHASE2_QUORUM}, nil
+}
+
+func (m *majority) IsPhase1Quorum(nodes []string) bool {
+	return len(members(m.nodes, nodes)) >= m.phase1
+}
+
+func (m *majority) IsPhase2Quorum(nodes []string) bool {
+	return len(members(m.nodes, nodes)) >= m.phase2
+}
+
+func (m *majority) String() string {
+	return fmt.Sprintf("majority (%d/%d promises, %d/%d accepts)", m.phase1, len(m.nodes), m.phase2, len(m.nodes))
+}
@@ -0,0 +1,225 @@
+// Package quorum decides which sets of nodes are quorums, for the proposer (prepare and accept requests), the leader, the learners and the seeker.
+// The quorum system is chosen at startup through the 'quorum_policy' field of the '.yaml' file.
+package quorum
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// System describes a quorum system: the sets of nodes a prepare request (phase 1) and an accept request (phase 2) need an answer from.
+// Any phase-1 quorum must intersect any phase-2 quorum, and adding nodes to a quorum must keep it a quorum (see Check).
+// Nodes are identified by their url, nodes which are not part of the system (e.g. the nodes which do not play the acceptor role) are ignored.
+type System interface {
+	IsPhase1Quorum(nodes []string) bool // IsPhase1Quorum tells whether @nodes can promise a prepare request.
+	IsPhase2Quorum(nodes []string) bool // IsPhase2Quorum tells whether @nodes can get a value chosen by accepting it.
+	String() string                     // String describes the system, e.g. "majority (3/5 promises, 3/5 accepts)".
+}
+
+// maxCheckedNodes bounds the number of nodes Check enumerates the subsets of, 2^16 sets are checked in well under a second.
+const maxCheckedNodes = 16
+
+// registry maps every known 'quorum_policy' to the function building the respective System out of the nodes of @c.
+var registry = make(map[string]func(c *config.Conf) (System, error))
+
+// Quorums are the quorums of a membership: the quorum system formed by its acceptors, and the majorities of its learners (see IsLearnerQuorum).
+// A Quorums never changes once built, a membership change builds a new one (see Use).
+type Quorums struct {
+	System
+	Acceptors []string // Acceptors lists the urls of the nodes of the membership playing the acceptor role.
+	Learners  []string // Learners lists the urls of the nodes of the membership playing the learner role.
+}
+
+// current holds the *Quorums in use, it is set by Init and Use.
+var current atomic.Value
+
+// Register makes a quorum system available under the name @policy.
+// Systems register themselves in their own init function; registering the same name twice is a programming error.
+func Register(policy string, newSystem func(c *config.Conf) (System, error)) {
+	if _, exists := registry[policy]; exists {
+		panic(fmt.Sprintf("quorum: quorum policy %q registered twice", policy))
+	}
+	registry[policy] = newSystem
+}
+
+// RegisteredPolicies returns the sorted list of the names under which a quorum system has been registered.
+func RegisteredPolicies() []string {
+	var policies []string
+	for policy := range registry {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	return policies
+}
+
+// NewSystem builds the quorum system named by QUORUM_POLICY out of the acceptors of @c and checks that its quorums intersect.
+// The learners are not part of the system, they have quorums of their own (see IsLearnerQuorum).
+func NewSystem(c *config.Conf) (System, error) {
+	newSystem, ok := registry[c.QUORUM_POLICY]
+	if !ok {
+		return nil, fmt.Errorf("unknown quorum_policy %q, valid values are: %s", c.QUORUM_POLICY, strings.Join(RegisteredPolicies(), ", "))
+	}
+
+	s, err := newSystem(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// every system guarantees the intersection by construction, enumerating the subsets only double-checks it on small clusters
+	if len(c.ACCEPTORS) <= maxCheckedNodes {
+		err = Check(s, c.ACCEPTORS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(c.LEARNERS) == 0 {
+		return nil, fmt.Errorf("no node plays the learner role, client submits could never complete")
+	}
+	return s, nil
+}
+
+// New builds the quorums of the nodes of @c, see NewSystem.
+func New(c *config.Conf) (*Quorums, error) {
+	s, err := NewSystem(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Quorums{System: s, Acceptors: c.ACCEPTORS, Learners: c.LEARNERS}, nil
+}
+
+// Init selects the quorum system named by QUORUM_POLICY, an error is returned if its quorums do not intersect.
+func Init(c *config.Conf) error {
+	q, err := New(c)
+	if err != nil {
+		return err
+	}
+	Use(q)
+	return nil
+}
+
+// Use makes @q the quorums in use, e.g. once a membership change takes effect.
+func Use(q *Quorums) {
+	current.Store(q)
+}
+
+// Current returns the quorums in use.
+func Current() *Quorums {
+	return current.Load().(*Quorums)
+}
+
+// Check enumerates the subsets of @nodes and returns an error when a phase-1 quorum does not intersect a phase-2 quorum.
+// As any superset of a quorum is a quorum as well, it's enough to check that the nodes left out by a phase-1 quorum are never a phase-2 quorum.
+func Check(s System, nodes []string) error {
+	n := len(nodes)
+	if n > maxCheckedNodes {
+		return fmt.Errorf("cannot check the quorums of more than %d nodes, got %d", maxCheckedNodes, n)
+	}
+	if !s.IsPhase1Quorum(nodes) || !s.IsPhase2Quorum(nodes) {
+		return fmt.Errorf("%s: the whole set of nodes is not a quorum, no progress would ever be possible", s)
+	}
+
+	for mask := 0; mask < 1<<uint(n); mask++ {
+		var in, out []string
+		for i, node := range nodes {
+			if mask&(1<<uint(i)) != 0 {
+				in = append(in, node)
+			} else {
+				out = append(out, node)
+			}
+		}
+		if s.IsPhase1Quorum(in) && s.IsPhase2Quorum(out) {
+			return fmt.Errorf("%s: phase-1 quorum %v does not intersect phase-2 quorum %v", s, in, out)
+		}
+	}
+	return nil
+}
+
+// IsPhase1Quorum tells whether @nodes are a phase-1 quorum of the current system.
+func IsPhase1Quorum(nodes []string) bool {
+	return Current().IsPhase1Quorum(nodes)
+}
+
+// IsPhase2Quorum tells whether @nodes are a phase-2 quorum of the current system.
+func IsPhase2Quorum(nodes []string) bool {
+	return Current().IsPhase2Quorum(nodes)
+}
+
+// MeetsEveryPhase1Quorum tells whether @nodes intersect every phase-1 quorum of the current system, see Quorums.MeetsEveryPhase1Quorum.
+func MeetsEveryPhase1Quorum(nodes []string) bool {
+	return Current().MeetsEveryPhase1Quorum(nodes)
+}
+
+// IsLearnerQuorum tells whether @nodes are a majority of the current learners, see Quorums.IsLearnerQuorum.
+func IsLearnerQuorum(nodes []string) bool {
+	return Current().IsLearnerQuorum(nodes)
+}
+
+// Describe returns the description of the current system.
+func Describe() string {
+	return Current().String()
+}
+
+// Pick returns random acceptors of the current system, see Quorums.Pick.
+func Pick(isQuorum func(nodes []string) bool) []string {
+	return Current().Pick(isQuorum)
+}
+
+// MeetsEveryPhase1Quorum tells whether @nodes intersect every phase-1 quorum of @q, i.e. whether the other acceptors are not a phase-1 quorum.
+func (q *Quorums) MeetsEveryPhase1Quorum(nodes []string) bool {
+	return !q.IsPhase1Quorum(Without(q.Acceptors, nodes))
+}
+
+// IsLearnerQuorum tells whether @nodes are a majority of the learners, whatever the quorum system (which only counts acceptors).
+// Client submits wait for a learner quorum to learn their value and quorum reads ask a learner quorum (see Read): two majorities always intersect,
+// so a read meets a learner of every submit completed before it.
+func (q *Quorums) IsLearnerQuorum(nodes []string) bool {
+	return len(members(q.Learners, nodes)) > len(q.Learners)/2
+}
+
+// Pick returns random acceptors of @q, as few as needed to form a quorum according to @isQuorum (e.g. IsPhase1Quorum).
+func (q *Quorums) Pick(isQuorum func(nodes []string) bool) []string {
+	var nodes []string
+	for _, i := range rand.Perm(len(q.Acceptors)) {
+		if isQuorum(nodes) {
+			break
+		}
+		nodes = append(nodes, q.Acceptors[i])
+	}
+	return nodes
+}
+
+// Without returns the nodes of @all which are not in @nodes.
+func Without(all []string, nodes []string) []string {
+	var rest []string
+	for _, node := range all {
+		if !contains(nodes, node) {
+			rest = append(rest, node)
+		}
+	}
+	return rest
+}
+
+// contains tells whether @node is in @nodes.
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// members returns the nodes of @nodes which are in @all, each one once.
+func members(all []string, nodes []string) []string {
+	var found []string
+	for _, node := range nodes {
+		if contains(all, node) && !contains(found, node) {
+			found = append(found, node)
+		}
+	}
+	return found
+}
@@ -0,0 +1,178 @@
+package quorum
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+	"testing"
+)
+
+// confOf returns the configuration of @nodes with the quorum policy @policy, filled in the way the '.yaml' file is.
+func confOf(policy string, phase1 int, phase2 int, nodes ...config.Node) *config.Conf {
+	c := &config.Conf{NODE_ENTRIES: nodes, QUORUM_POLICY: policy, PHASE1_QUORUM: phase1, PHASE2_QUORUM: phase2}
+	c.FillEmptyFields()
+	return c
+}
+
+// acceptors returns @n nodes playing every role, the i-th of them in zone @zones[i % len(@zones)] when zones are given.
+func acceptors(n int, zones ...string) []config.Node {
+	var nodes []config.Node
+	for i := 1; i <= n; i++ {
+		node := config.Node{ID: i, URL: fmt.Sprintf("http://localhost:777%d", i)}
+		if len(zones) != 0 {
+			node.ZONE = zones[(i-1)%len(zones)]
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// everyNode is a broken quorum system: any single node is a quorum of both phases.
+type everyNode struct{}
+
+func (everyNode) IsPhase1Quorum(nodes []string) bool { return len(nodes) >= 1 }
+func (everyNode) IsPhase2Quorum(nodes []string) bool { return len(nodes) >= 1 }
+func (everyNode) String() string                     { return "every node" }
+
+func TestCheckIntersection(t *testing.T) {
+	weightedNodes := acceptors(4)
+	weightedNodes[0].WEIGHT = 3
+
+	valid := map[string]*config.Conf{
+		"majority of 3":          confOf("majority", 0, 0, acceptors(3)...),
+		"majority of 4":          confOf("majority", 0, 0, acceptors(4)...),
+		"flexible 4/2 of 5":      confOf("majority", 4, 2, acceptors(5)...),
+		"flexible 2/4 of 5":      confOf("majority", 2, 4, acceptors(5)...),
+		"weighted":               confOf("weighted", 0, 0, weightedNodes...),
+		"zones, 3 zones of 2":    confOf("zones", 0, 0, acceptors(6, "a", "b", "c")...),
+		"zones, 2 uneven zones":  confOf("zones", 0, 0, acceptors(5, "a", "b")...),
+		"zones, a zone per node": confOf("zones", 0, 0, acceptors(3, "a", "b", "c")...),
+	}
+	for name, c := range valid {
+		s, err := NewSystem(c)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if err = Check(s, c.ACCEPTORS); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	nodes := confOf("majority", 0, 0, acceptors(3)...).ACCEPTORS
+	if err := Check(everyNode{}, nodes); err == nil {
+		t.Error("disjoint quorums have not been detected")
+	}
+}
+
+func TestNewSystemRejectsDisjointQuorums(t *testing.T) {
+	negativeWeight := acceptors(3)
+	negativeWeight[2].WEIGHT = -1
+	noLearner := acceptors(3)
+	for i := range noLearner {
+		noLearner[i].ROLES = []string{"proposer", "acceptor"}
+	}
+
+	invalid := map[string]*config.Conf{
+		"flexible 2/2 of 4":     confOf("majority", 2, 2, acceptors(4)...),
+		"flexible 1/3 of 4":     confOf("majority", 1, 3, acceptors(4)...),
+		"phase1 above N":        confOf("majority", 4, 2, acceptors(3)...),
+		"negative weight":       confOf("weighted", 0, 0, negativeWeight...),
+		"weighted, phase sizes": confOf("weighted", 3, 1, acceptors(3)...),
+		"acceptor w/o zone":     confOf("zones", 0, 0, acceptors(3)...),
+		"zones, phase sizes":    confOf("zones", 0, 2, acceptors(3, "a", "b", "c")...),
+		"no learner":            confOf("majority", 0, 0, noLearner...),
+		"unknown policy":        confOf("unknown", 0, 0, acceptors(3)...),
+		"majority of 0 node":    confOf("majority", 0, 0),
+	}
+	for name, c := range invalid {
+		if _, err := NewSystem(c); err == nil {
+			t.Errorf("%s: the configuration has been accepted", name)
+		}
+	}
+}
+
+func TestFlexibleQuorumSizes(t *testing.T) {
+	c := confOf("majority", 4, 2, acceptors(5)...)
+	s, err := NewSystem(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.IsPhase2Quorum(c.ACCEPTORS[:2]) || s.IsPhase2Quorum(c.ACCEPTORS[:1]) {
+		t.Errorf("phase-2 quorums of %s are wrong", s)
+	}
+	if !s.IsPhase1Quorum(c.ACCEPTORS[1:]) || s.IsPhase1Quorum(c.ACCEPTORS[:3]) {
+		t.Errorf("phase-1 quorums of %s are wrong", s)
+	}
+
+	// the phase sizes default to QUORUM, itself a majority by default
+	s, err = NewSystem(confOf("majority", 0, 0, acceptors(4)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != "majority (3/4 promises, 3/4 accepts)" {
+		t.Errorf("default sizes: %s, want 3 of 4 for both phases", s)
+	}
+}
+
+func TestWeightedQuorums(t *testing.T) {
+	nodes := acceptors(4)
+	nodes[0].WEIGHT = 3
+	c := confOf("weighted", 0, 0, nodes...)
+	s, err := NewSystem(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a total weight of 6: node 1 and any other node hold 4, the three other nodes hold 3 only
+	if !s.IsPhase1Quorum(c.ACCEPTORS[:2]) || !s.IsPhase2Quorum(c.ACCEPTORS[:2]) {
+		t.Errorf("nodes 1 and 2 are not a quorum of %s", s)
+	}
+	if s.IsPhase1Quorum(c.ACCEPTORS[1:]) || s.IsPhase2Quorum(c.ACCEPTORS[1:]) {
+		t.Errorf("nodes 2, 3 and 4 are a quorum of %s", s)
+	}
+}
+
+func TestZonesQuorums(t *testing.T) {
+	// nodes 1 and 4 in zone a, 2 and 5 in zone b, 3 and 6 in zone c
+	c := confOf("zones", 0, 0, acceptors(6, "a", "b", "c")...)
+	s, err := NewSystem(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := func(i int) string { return c.ACCEPTORS[i-1] }
+
+	// zone c can be lost as a whole
+	if !s.IsPhase1Quorum([]string{url(1), url(4), url(2), url(5)}) {
+		t.Errorf("zones a and b are not a quorum of %s", s)
+	}
+	// 4 nodes out of 6, but a majority of a single zone only
+	if s.IsPhase2Quorum([]string{url(1), url(4), url(2), url(3)}) {
+		t.Errorf("zone a with a node of zones b and c is a quorum of %s", s)
+	}
+}
+
+func TestQuorumsCountOnlyTheirOwnNodes(t *testing.T) {
+	nodes := acceptors(5)
+	nodes[3].ROLES = []string{"learner"}
+	nodes[4].ROLES = []string{"learner"}
+	nodes[0].ROLES = []string{"proposer", "acceptor"}
+
+	q, err := New(confOf("majority", 0, 0, nodes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := func(i int) string { return nodes[i-1].URL }
+
+	// acceptors 1, 2 and 3: 2 of them are a majority, the learner-only nodes do not count
+	if !q.IsPhase1Quorum([]string{url(1), url(2)}) || q.IsPhase1Quorum([]string{url(1), url(4), url(5)}) {
+		t.Errorf("phase-1 quorums of %v are wrong", q.Acceptors)
+	}
+	// learners 2, 3, 4 and 5: 3 of them are a majority, the acceptor-only node does not count
+	if !q.IsLearnerQuorum([]string{url(2), url(4), url(5)}) || q.IsLearnerQuorum([]string{url(1), url(2), url(3)}) {
+		t.Errorf("learner quorums of %v are wrong", q.Learners)
+	}
+	if !q.MeetsEveryPhase1Quorum([]string{url(1), url(2)}) || q.MeetsEveryPhase1Quorum([]string{url(1)}) {
+		t.Errorf("sets meeting every phase-1 quorum of %v are wrong", q.Acceptors)
+	}
+}
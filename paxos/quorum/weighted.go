@@ -0,0 +1,60 @@
+package quorum
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+)
+
+// weighted is the "weighted" quorum system: a set of nodes is a quorum (of both phases) when it holds more than half of the total weight.
+// Two such sets always intersect, as together they would hold more than the total weight otherwise.
+type weighted struct {
+	nodes   []string
+	weights map[string]int
+	total   int
+}
+
+func init() {
+	Register("weighted", newWeighted)
+}
+
+// newWeighted builds the "weighted" quorum system out of the WEIGHT of each acceptor, weights must be positive and the quorum sizes left empty.
+func newWeighted(c *config.Conf) (System, error) {
+	if c.QuorumSizesGiven() {
+		return nil, fmt.Errorf("quorum, phase1_quorum and phase2_quorum cannot be set with the \"weighted\" quorum policy, its quorums follow the weights of the acceptors")
+	}
+	w := &weighted{weights: make(map[string]int)}
+
+	for _, node := range c.NODE_ENTRIES {
+		if !node.Plays("acceptor") {
+			continue
+		}
+		if node.WEIGHT <= 0 {
+			return nil, fmt.Errorf("the weight of node %s must be positive, got %d", node.URL, node.WEIGHT)
+		}
+		w.nodes = append(w.nodes, node.URL)
+		w.weights[node.URL] = node.WEIGHT
+		w.total += node.WEIGHT
+	}
+	return w, nil
+}
+
+// weightOf returns the weight held by @nodes.
+func (w *weighted) weightOf(nodes []string) int {
+	weight := 0
+	for _, node := range members(w.nodes, nodes) {
+		weight += w.weights[node]
+	}
+	return weight
+}
+
+func (w *weighted) IsPhase1Quorum(nodes []string) bool {
+	return 2*w.weightOf(nodes) > w.total
+}
+
+func (w *weighted) IsPhase2Quorum(nodes []string) bool {
+	return 2*w.weightOf(nodes) > w.total
+}
+
+func (w *weighted) String() string {
+	return fmt.Sprintf("weighted (more than half of a total weight of %d)", w.total)
+}
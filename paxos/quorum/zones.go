@@ -0,0 +1,68 @@
+package quorum
+
+import (
+	"fmt"
+	"go-paxos/paxos/config"
+)
+
+// zones is the "zones" quorum system: a set of nodes is a quorum (of both phases) when it holds a majority of the nodes of a majority of the zones.
+// Two such sets share a zone in which each of them holds a majority, hence they share a node there.
+// A whole zone (e.g. a rack) can be lost as long as a majority of zones is left.
+type zones struct {
+	nodes  []string
+	zoneOf map[string]string // zoneOf maps each node to its zone.
+	sizes  map[string]int    // sizes maps each zone to the number of its nodes.
+}
+
+func init() {
+	Register("zones", newZones)
+}
+
+// newZones builds the "zones" quorum system out of the ZONE of each acceptor, every acceptor must have one and the quorum sizes must be left empty.
+func newZones(c *config.Conf) (System, error) {
+	if c.QuorumSizesGiven() {
+		return nil, fmt.Errorf("quorum, phase1_quorum and phase2_quorum cannot be set with the \"zones\" quorum policy, its quorums follow the zones of the acceptors")
+	}
+	z := &zones{zoneOf: make(map[string]string), sizes: make(map[string]int)}
+
+	for _, node := range c.NODE_ENTRIES {
+		if !node.Plays("acceptor") {
+			continue
+		}
+		if node.ZONE == "" {
+			return nil, fmt.Errorf("acceptor %s has no zone, every acceptor needs one with the \"zones\" quorum policy", node.URL)
+		}
+		z.nodes = append(z.nodes, node.URL)
+		z.zoneOf[node.URL] = node.ZONE
+		z.sizes[node.ZONE] += 1
+	}
+	return z, nil
+}
+
+// isQuorum tells whether @nodes hold a majority of the nodes of a majority of the zones.
+func (z *zones) isQuorum(nodes []string) bool {
+	counts := make(map[string]int)
+	for _, node := range members(z.nodes, nodes) {
+		counts[z.zoneOf[node]] += 1
+	}
+
+	zonesWithMajority := 0
+	for zone, count := range counts {
+		if 2*count > z.sizes[zone] {
+			zonesWithMajority += 1
+		}
+	}
+	return 2*zonesWithMajority > len(z.sizes)
+}
+
+func (z *zones) IsPhase1Quorum(nodes []string) bool {
+	return z.isQuorum(nodes)
+}
+
+func (z *zones) IsPhase2Quorum(nodes []string) bool {
+	return z.isQuorum(nodes)
+}
+
+func (z *zones) String() string {
+	return fmt.Sprintf("zones (a majority of the nodes in a majority of %d zones)", len(z.sizes))
+}
@@ -0,0 +1,168 @@
+/*
+
+# Reads:
+A learnt value never changes, but the local database of a lagging node might miss the latest ones. Reads come in three flavours:
+
+	(a) "local": the local database is read, it's the fastest read but it might be stale.
+	(b) "quorum": a majority of the learners is asked for its last learnt turn id (the read index) and for the requested value.
+		A client submit returns once a majority of the learners has learnt its value (see Submit), so any majority knows of every submit completed before the read.
+		When the learners holding the value that is returned are not a majority, it's learnt by a majority before returning,
+		so that a later read can never return an older state.
+		Only the values of completed client submits are known to a majority though: a value chosen through /proposer/send_accept,
+		/proposer/send_learn or by a leader finishing the proposals of its predecessors might have reached a minority of the learners only,
+		in which case the read misses it until the learn requests sent again (see 'delivery.go') or the seeker spread it.
+		The acceptors are never asked, this is not a read index of the log but of the learners.
+	(c) "lease": the leader holding a lease (see 'election.go') is the only node which can get values chosen, hence it serves the read by itself.
+		Any other node, or a leader whose lease expired, serves the read as a "quorum" read.
+		The lease relies on the clocks of the nodes running at about the same rate, see 'election.go'.
+
+*/
+
+package paxos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/queries"
+	"go-paxos/paxos/quorum"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrUnknownConsistency is returned by Read when the requested consistency is none of "local", "quorum" and "lease".
+var ErrUnknownConsistency = errors.New("unknown consistency, please use one of: local, quorum, lease")
+
+// broadcastRead sends the read request for @turnID to each node in @NODES, the responses are collected in the returned channel.
+func broadcastRead(session *http.Client, NODES []string, turnID int) chan nodeResponse {
+	ch := make(chan nodeResponse, len(NODES))
+
+	for _, node := range NODES {
+		go sendTaggedRequest(session, node, "/learner/receive_read", ch, messages.ReadRequest{TurnID: turnID})
+	}
+	return ch
+}
+
+// readLocal reads @turnID (the last learnt turn id when @turnID is 0) from the local database.
+func readLocal(turnID int) messages.ReadResponse {
+	readResponse := ReceiveRead(messages.ReadRequest{TurnID: turnID})
+	readResponse.Consistency = "local"
+	return readResponse
+}
+
+// readQuorum reads @turnID (the highest last learnt turn id of a quorum when @turnID is 0) from a learner quorum (see quorum.IsLearnerQuorum).
+func readQuorum(turnID int) (messages.ReadResponse, error) {
+	session := &http.Client{Timeout: time.Second * config.CONF.TIMEOUT}
+	ch := broadcastRead(session, config.Members().LEARNERS, turnID)
+
+	var responses []messages.ReadResponse
+	var responders []string
+	for i := 0; i < cap(ch); i++ {
+		response := <-ch
+
+		// @response.data is nil when a node did not respond in time
+		if response.data == nil {
+			continue
+		}
+		responseMessage := messages.ReadResponse{}
+		err := json.Unmarshal(response.data, &responseMessage)
+		if err != nil {
+			log.Print(err.Error())
+			continue
+		}
+		responses = append(responses, responseMessage)
+		responders = append(responders, response.node)
+	}
+
+	if !quorum.IsLearnerQuorum(responders) {
+		return messages.ReadResponse{}, fmt.Errorf("quorum has NOT been reached (%d/%d) for the read request", len(responses), len(config.Members().LEARNERS))
+	}
+
+	// the read index: every submit completed before the read has a turn id lower than or equal to it
+	readResponse := messages.ReadResponse{TurnID: turnID, Consistency: "quorum"}
+	for _, response := range responses {
+		if response.LastTurnID > readResponse.LastTurnID {
+			readResponse.LastTurnID = response.LastTurnID
+		}
+	}
+	if turnID == 0 {
+		readResponse.TurnID = readResponse.LastTurnID
+	}
+
+	// the nodes which answered for another turn id (they did not know the read index yet) do not count as holding the value
+	var holders []string
+	for i, response := range responses {
+		if response.TurnID == readResponse.TurnID && response.V != "" {
+			readResponse.V = response.V
+			holders = append(holders, responders[i])
+		}
+	}
+	if turnID == 0 && readResponse.V == "" && readResponse.TurnID != 0 {
+		// no responder read the read index itself, ask for it
+		return readQuorum(readResponse.TurnID)
+	}
+	if readResponse.V == "" {
+		return readResponse, nil
+	}
+
+	if queries.GetLearntValue(readResponse.TurnID) == "" {
+		_ = learnValue(readResponse.TurnID, readResponse.V, "read")
+	}
+	if !quorum.IsLearnerQuorum(holders) {
+		learners := learnQuorum(session, readResponse.TurnID, readResponse.V)
+		if !quorum.IsLearnerQuorum(learners) {
+			return messages.ReadResponse{}, fmt.Errorf("'%s' could not be learnt by a quorum (%d/%d) for turn id %d", readResponse.V, len(learners), len(config.Members().LEARNERS), readResponse.TurnID)
+		}
+	}
+	return readResponse, nil
+}
+
+// leaseCovers tells whether the leader can serve the read of @turnID (the last learnt turn id when @turnID is 0) by itself:
+// it must hold its lease, lead @turnID and have no accepted proposal of the previous leaders left to finish.
+func leaseCovers(turnID int) bool {
+	if !holdsLease() {
+		return false
+	}
+
+	leadership.Lock()
+	defer leadership.Unlock()
+	if turnID == 0 {
+		return len(leadership.pending) == 0
+	}
+	_, isPending := leadership.pending[turnID]
+	return turnID >= leadership.fromTurnID && !isPending
+}
+
+// Read reads the value learnt for @turnID, or the last learnt value when @turnID is 0, with the given @consistency: "local", "quorum" or "lease".
+// An empty @consistency stands for "quorum". The consistency the read has actually been served with is reported in the response,
+// a "lease" read served by a node which does not hold the lease is reported as "quorum".
+// The submit id the value has been proposed with (see tagSubmit) is not part of the response.
+// A "quorum" read sees every completed client submit, but it might miss a value chosen without a submit until it reaches a majority of the learners.
+func Read(turnID int, consistency string) (messages.ReadResponse, error) {
+	readResponse, err := read(turnID, consistency)
+	_, readResponse.V = untagSubmit(readResponse.V)
+	return readResponse, err
+}
+
+// read serves Read, the value is returned as learnt.
+func read(turnID int, consistency string) (messages.ReadResponse, error) {
+	switch consistency {
+	case "local":
+		return readLocal(turnID), nil
+	case "lease":
+		if leaseCovers(turnID) {
+			readResponse := readLocal(turnID)
+			readResponse.Consistency = "lease"
+			return readResponse, nil
+		}
+		log.Printf("[READER] -> This node does not hold a lease covering turn id %d, reading from a quorum.", turnID)
+		return readQuorum(turnID)
+	case "", "quorum":
+		return readQuorum(turnID)
+	default:
+		return messages.ReadResponse{}, ErrUnknownConsistency
+	}
+}
@@ -0,0 +1,80 @@
+package paxos
+
+import (
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/queries"
+	"testing"
+)
+
+func TestReadLocal(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	submitWithin(t, "a", 5)
+	submitWithin(t, "b", 5)
+
+	readResponse, err := Read(0, "local")
+	if err != nil || readResponse.TurnID != 2 || readResponse.V != "b" || readResponse.Consistency != "local" {
+		t.Fatalf("local read of the last value: %+v, err = %v, want 'b' at turn id 2", readResponse, err)
+	}
+	readResponse, err = Read(1, "local")
+	if err != nil || readResponse.V != "a" {
+		t.Fatalf("local read of turn id 1: %+v, err = %v, want 'a'", readResponse, err)
+	}
+}
+
+func TestReadQuorumLearnsMissingValue(t *testing.T) {
+	// node 2 learnt turn id 3, this node did not
+	peer := startLearnerPeer(map[int]string{3: "x"})
+	defer peer.Close()
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES,
+			config.Node{ID: 2, URL: peer.URL, ROLES: []string{"learner"}},
+			config.Node{ID: 3, URL: deadPeer(), ROLES: []string{"learner"}})
+	}).stop()
+
+	readResponse, err := Read(0, "")
+	if err != nil || readResponse.TurnID != 3 || readResponse.V != "x" || readResponse.Consistency != "quorum" {
+		t.Fatalf("quorum read of the last value: %+v, err = %v, want 'x' at turn id 3", readResponse, err)
+	}
+	// a later read from any learner quorum must not go back in time
+	if v := queries.GetLearntValue(3); v != "x" {
+		t.Fatalf("learnt value of turn id 3 after the read: '%s', want 'x'", v)
+	}
+}
+
+func TestReadQuorumWithoutQuorum(t *testing.T) {
+	defer startNode(t, func(c *config.Conf) {
+		c.NODE_ENTRIES = append(c.NODE_ENTRIES,
+			config.Node{ID: 2, URL: deadPeer(), ROLES: []string{"learner"}},
+			config.Node{ID: 3, URL: deadPeer(), ROLES: []string{"learner"}})
+	}).stop()
+
+	if readResponse, err := Read(0, "quorum"); err == nil {
+		t.Fatalf("quorum read answered by a single learner out of 3: %+v, want an error", readResponse)
+	}
+}
+
+func TestReadLease(t *testing.T) {
+	defer startNode(t, withElection).stop()
+
+	// no lease yet: the read is served by a quorum
+	readResponse, err := Read(0, "lease")
+	if err != nil || readResponse.Consistency != "quorum" {
+		t.Fatalf("lease read without a lease: %+v, err = %v, want it served as a quorum read", readResponse, err)
+	}
+
+	TickElection()
+	waitFor(t, "the lease of the leader", holdsLease)
+	readResponse, err = Read(0, "lease")
+	if err != nil || readResponse.Consistency != "lease" {
+		t.Fatalf("lease read with a lease: %+v, err = %v, want it served by the leader alone", readResponse, err)
+	}
+}
+
+func TestReadUnknownConsistency(t *testing.T) {
+	defer startNode(t, nil).stop()
+
+	if _, err := Read(0, "strong"); err != ErrUnknownConsistency {
+		t.Fatalf("read with an unknown consistency: err = %v, want ErrUnknownConsistency", err)
+	}
+}
@@ -20,12 +20,12 @@ import (
 	"time"
 )
 
-// extractRandomNodes selects (with given probability) a list of nodes.
+// extractRandomNodes selects (with given probability) a list of learners, this node excluded.
 // This is useful when we dont want to flood the network.
 func extractRandomNodes(pr float64) *[]string {
 
 	var nodes []string
-	for _, node := range config.CONF.NODES {
+	for _, node := range config.Members().LearnerPeers() {
 		r := rand.Float64()
 		if r < pr { // extracting node with a given probability
 			//log.Printf("[SEEKER] -> Node %s has been extracted as a target for this seek request.", node)
@@ -38,7 +38,7 @@ func extractRandomNodes(pr float64) *[]string {
 
 // extractRandomProposals selects (with given probability) the dangling proposals for which a new prepare request will be sent.
 // The aim of this function is to reduce the number of the proposals that will be flooding the network.
-func extractRandomProposals(danglingProposals *map[int]proposal.Proposal, pr float64) *map[int]proposal.Proposal {
+func extractRandomProposals(danglingProposals *map[int]proposal.AcceptorState, pr float64) *map[int]proposal.AcceptorState {
 
 	for turnID := range *danglingProposals {
 		r := rand.Float64()
@@ -84,7 +84,8 @@ func askForDanglingProposals() {
 	for turnID, danglingProposal := range *danglingProposals {
 
 		log.Printf("[SEEKER] -> Seeking dangling proprosal with turn id %d.", turnID)
-		go SendPrepare(turnID, danglingProposal.Seq, danglingProposal.V, config.CONF.OPTIMIZATION)
+		// restarting from the promised number, the accepted value (if any) is only a suggestion: the prepare phase will pick the right one
+		go SendPrepare(turnID, danglingProposal.Promised.Seq, danglingProposal.Accepted.V, config.CONF.OPTIMIZATION)
 
 	}
 
@@ -120,14 +121,14 @@ func askForNewValues() {
 func learnFromDict(newValuesResponses *map[int]string) {
 	log.Printf("[SEEKER] -> Learning from merged responses.")
 	for turnID, proposedV := range *newValuesResponses {
-		currentV := queries.GetLearntValue(turnID)
-
-		if currentV != "" && proposedV != currentV && proposedV != "" {
-			log.Printf("[SEEKER] -> !!WARNING!!  im trying to learn a different non empty value")
-			// this should never happen
+		if proposedV == "" {
+			continue
 		}
-		if currentV == "" && proposedV != "" {
-			_ = queries.SetLearntValue(turnID, proposedV)
+
+		// learning a value different from ours should never happen, learnValue records it as a conflict
+		err := learnValue(turnID, proposedV, "seeker")
+		if _, isConflict := err.(*queries.LearntConflictError); !isConflict && err != nil {
+			log.Printf("[SEEKER] -> Could not learn '%s' for turn id %d: %v", proposedV, turnID, err)
 		}
 	}
 }
@@ -158,8 +159,8 @@ func ComputeNewValuesRequest() messages.NewValuesRequest {
 
 	missing := []int{}
 	// computing missing list
-	// starting from 1 since turn ids start from 1
-	for turnID := 1; turnID <= lastID; turnID++ {
+	// starting after the checkpoint since every turn id up to it is in the snapshot (turn ids start from 1)
+	for turnID := queries.GetCheckpoint() + 1; turnID <= lastID; turnID++ {
 		if !learntValuesTurnIDs[turnID] && !proposalsTurnIDs[turnID] {
 			// if id is not learnt and is not in proposals
 			missing = append(missing, turnID)
@@ -190,6 +191,14 @@ func ComputeNewValuesResponse(newValuesRequest messages.NewValuesRequest) messag
 	toLearn := map[int]string{} // map, in this way i dont need to handle whether keys (turn ids) are unique
 	myLast := queries.GetLastTurnID()
 
+	// the requester is behind our checkpoint, the values it needs are in the snapshot and no longer in the 'learnt' table
+	var snap *messages.Snapshot
+	if checkpoint := queries.GetCheckpoint(); checkpoint > newValuesRequest.Last {
+		log.Printf("[SEEKER] -> The requester's last learnt turn id (%d) is behind my checkpoint (%d); sending the snapshot.", newValuesRequest.Last, checkpoint)
+		s := queries.GetSnapshot()
+		snap = &s
+	}
+
 	// check if i have something that goes beyond the last learnt turnID of the requester
 	if myLast > newValuesRequest.Last {
 		log.Printf("[SEEKER] -> I'm ahead of the requester. My last learnt turn id is %d, his is %d.", myLast, newValuesRequest.Last)
@@ -226,9 +235,9 @@ func ComputeNewValuesResponse(newValuesRequest messages.NewValuesRequest) messag
 		}
 	}
 
-	res := messages.NewValuesResponse{ToLearn: toLearn}
+	res := messages.NewValuesResponse{ToLearn: toLearn, Snapshot: snap}
 
-	log.Printf("[SEEKER] -> Sending back %v as values to learn.", res)
+	log.Printf("[SEEKER] -> Sending back %v as values to learn.", res.ToLearn)
 	return res
 }
 
@@ -254,6 +263,13 @@ func checkNewValuesResponses(responseBuffer chan []byte) {
 				mergedToLearn[turnID] = v
 			}
 
+			// values in a snapshot are learnt one by one, our own checkpoints will fold them again
+			if responseMessage.Snapshot != nil {
+				for turnID, v := range responseMessage.Snapshot.Values {
+					mergedToLearn[turnID] = v
+				}
+			}
+
 		}
 
 	}
@@ -40,6 +40,19 @@ func sendPartialRequest(session *http.Client, url string, resBuffer chan []byte,
 
 }
 
+// nodeResponse is a response tagged with the node (its url as listed in NODES) it comes from, so that quorums can be told apart from plain counts.
+type nodeResponse struct {
+	node string
+	data []byte // data is nil when the node did not respond in time.
+}
+
+// sendTaggedRequest sends @message to @node + @path like sendPartialRequest does, the response is tagged with @node.
+func sendTaggedRequest(session *http.Client, node string, path string, resBuffer chan nodeResponse, message interface{}) {
+	ch := make(chan []byte, 1)
+	sendPartialRequest(session, node+path, ch, message)
+	resBuffer <- nodeResponse{node: node, data: <-ch}
+}
+
 // floodLearntValue floods the network with learnt requests for the new learnt value.
 // This is very similar to the SendLearn function of the proposer.
 // Sending learn requests is usually the proposer's job; in this case is the learner that has to do it, but in order to prevent the learner from knowing anything about the proposer
@@ -57,11 +70,14 @@ func floodLearntValue(turnID int, v string) {
 		},
 	}
 
-	ch := make(chan []byte, len(config.CONF.NODES))
-	for _, node := range config.CONF.NODES {
-		url := node + "/learner/receive_learn"
-		go sendPartialRequest(session, url, ch, learnRequest)
+	// this node has learnt @v already, the peers which do not acknowledge the request are sent it again later (see 'delivery.go')
+	peers := config.Members().LearnerPeers()
+	trackLearns(peers, turnID, v)
+	ch := make(chan nodeResponse, len(peers))
+	for _, node := range peers {
+		go sendTaggedRequest(session, node, "/learner/receive_learn", ch, learnRequest)
 	}
+	go collectLearnAcks(ch, turnID)
 
 }
 
@@ -0,0 +1,251 @@
+// Storage migrator copies the whole state of a node (proposals, learnt values, snapshot, range promise and meta values) from one storage backend to another,
+// e.g. from SQLite to Redis or back. It reads and writes through the queries package only, so every registered backend is supported.
+// The node must be stopped while migrating.
+//
+// Usage:
+//
+//	storage_migrator -from sqlite -to redis [-config config.yaml] [-to-config other.yaml] [-dry-run]
+//
+// Both backends read their settings (db_path, redis_*, wal_path) from -config, -to-config can be used when the destination needs different ones.
+// The destination has to be empty, after copying the two sides are compared and any difference is reported.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-paxos/paxos/config"
+	"go-paxos/paxos/messages"
+	"go-paxos/paxos/proposal"
+	"go-paxos/paxos/queries"
+	"log"
+	"os"
+	"reflect"
+)
+
+// state is everything a node stores, as read through a Store.
+type state struct {
+	proposals map[int]messages.ProposalWithTid
+	learnt    map[int]string
+	snapshot  messages.Snapshot
+	rp        messages.RangePromise
+	meta      map[string]string // meta holds every meta value (highest sequence number, pid, pending learns, ...) by key.
+}
+
+// readState reads the whole state from @s. Entries are keyed by turn id so that states can be compared regardless of the order backends return them in.
+func readState(s queries.Store) (state, error) {
+	st := state{
+		proposals: make(map[int]messages.ProposalWithTid),
+		learnt:    make(map[int]string),
+	}
+
+	for _, p := range s.GetAllProposals() {
+		st.proposals[p.TurnID] = p
+	}
+	for _, l := range s.GetAllLearntValues() {
+		st.learnt[l.TurnID] = l.Learnt
+	}
+
+	var err error
+	st.snapshot, err = s.GetSnapshot()
+	if err != nil {
+		return st, err
+	}
+	st.rp, err = s.GetRangePromise()
+	if err != nil {
+		return st, err
+	}
+	st.meta, err = s.ListMeta("")
+	return st, err
+}
+
+// isEmpty tells whether nothing has been stored.
+func (st state) isEmpty() bool {
+	return len(st.proposals) == 0 && len(st.learnt) == 0 && st.snapshot.LastTurnID == 0 && st.rp == messages.RangePromise{} && len(st.meta) == 0
+}
+
+// String returns a short report of the state.
+func (st state) String() string {
+	return fmt.Sprintf("%d proposal(s), %d learnt value(s), checkpoint at turn id %d (%d value(s) in the snapshot), %d meta value(s)",
+		len(st.proposals), len(st.learnt), st.snapshot.LastTurnID, len(st.snapshot.Values), len(st.meta))
+}
+
+// openStore connects to the backend named @dbType using the settings found in @configPath and brings its schema up to date.
+func openStore(dbType string, configPath string) (queries.Store, error) {
+	config.CONF = config.Conf{}
+	config.CONF.LoadConfigFile(configPath)
+	config.CONF.FillEmptyFields()
+	// the migrator is not a node: never notify the listener while copying learnt values
+	config.CONF.NUMBER_OF_TIDS = 0
+
+	s, err := queries.NewStore(dbType)
+	if err != nil {
+		return nil, err
+	}
+	err = s.PrepareDBConn()
+	if err != nil {
+		return nil, err
+	}
+	err = s.InitDatabase()
+	return s, err
+}
+
+// copyState writes @st to @s. The snapshot goes first, so that the turn ids it folds are never written as single entries.
+// Each acceptor state is written as its accepted proposal (if any) followed by its promised number, which might be higher.
+func copyState(st state, s queries.Store) error {
+	if st.snapshot.LastTurnID != 0 {
+		err := s.Compact(st.snapshot)
+		if err != nil {
+			return fmt.Errorf("could not copy the snapshot: %v", err)
+		}
+	}
+
+	for turnID, p := range st.proposals {
+		if p.Proposal.Pid != 0 || p.Proposal.Seq != 0 {
+			err := s.SetProposal(turnID, p.Proposal, true)
+			if err != nil {
+				return fmt.Errorf("could not copy the accepted proposal of turn id %d: %v", turnID, err)
+			}
+		}
+		err := s.SetProposal(turnID, proposal.Proposal{Pid: p.Promised.Pid, Seq: p.Promised.Seq}, false)
+		if err != nil {
+			return fmt.Errorf("could not copy the promised number of turn id %d: %v", turnID, err)
+		}
+	}
+
+	for turnID, v := range st.learnt {
+		err := s.SetLearntValue(turnID, v)
+		if err != nil {
+			return fmt.Errorf("could not copy the learnt value of turn id %d: %v", turnID, err)
+		}
+	}
+
+	if st.rp != (messages.RangePromise{}) {
+		err := s.SetRangePromise(st.rp)
+		if err != nil {
+			return fmt.Errorf("could not copy the range promise: %v", err)
+		}
+	}
+
+	for key, value := range st.meta {
+		err := s.SetMeta(key, value)
+		if err != nil {
+			return fmt.Errorf("could not copy the meta value '%s': %v", key, err)
+		}
+	}
+	return nil
+}
+
+// diffStates returns a description of every difference between @from and @to, an empty list when they hold the same state.
+func diffStates(from state, to state) []string {
+	var diffs []string
+
+	for turnID, p := range from.proposals {
+		if q, ok := to.proposals[turnID]; !ok || p != q {
+			diffs = append(diffs, fmt.Sprintf("proposal of turn id %d: %+v != %+v", turnID, p, q))
+		}
+	}
+	for turnID, q := range to.proposals {
+		if _, ok := from.proposals[turnID]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected proposal of turn id %d: %+v", turnID, q))
+		}
+	}
+
+	for turnID, v := range from.learnt {
+		if w, ok := to.learnt[turnID]; !ok || v != w {
+			diffs = append(diffs, fmt.Sprintf("learnt value of turn id %d: '%s' != '%s'", turnID, v, w))
+		}
+	}
+	for turnID, w := range to.learnt {
+		if _, ok := from.learnt[turnID]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected learnt value of turn id %d: '%s'", turnID, w))
+		}
+	}
+
+	if from.snapshot.LastTurnID != to.snapshot.LastTurnID || !reflect.DeepEqual(from.snapshot.Values, to.snapshot.Values) {
+		diffs = append(diffs, fmt.Sprintf("snapshot: checkpoint %d != %d or different values", from.snapshot.LastTurnID, to.snapshot.LastTurnID))
+	}
+	if from.rp != to.rp {
+		diffs = append(diffs, fmt.Sprintf("range promise: %+v != %+v", from.rp, to.rp))
+	}
+
+	for key, value := range from.meta {
+		if other, ok := to.meta[key]; !ok || value != other {
+			diffs = append(diffs, fmt.Sprintf("meta value '%s': '%s' != '%s'", key, value, other))
+		}
+	}
+	for key, other := range to.meta {
+		if _, ok := from.meta[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected meta value '%s': '%s'", key, other))
+		}
+	}
+	return diffs
+}
+
+func main() {
+	from := flag.String("from", "", "storage backend to read from, e.g. sqlite")
+	to := flag.String("to", "", "storage backend to write to, e.g. redis")
+	configPath := flag.String("config", "./config.yaml", "'.yaml' file holding the settings of the backends")
+	toConfigPath := flag.String("to-config", "", "'.yaml' file holding the settings of the destination backend, -config is used when empty")
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied, no entry is written (the schema of the destination might be created)")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *toConfigPath == "" {
+		*toConfigPath = *configPath
+	}
+	if *from == *to && *toConfigPath == *configPath {
+		log.Fatal("[MIGRATOR] -> Source and destination are the same storage.")
+	}
+
+	source, err := openStore(*from, *configPath)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Could not open the %s storage: %v", *from, err)
+	}
+	sourceState, err := readState(source)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Could not read the %s storage: %v", *from, err)
+	}
+	log.Printf("[MIGRATOR] -> Source (%s): %s.", *from, sourceState)
+
+	destination, err := openStore(*to, *toConfigPath)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Could not open the %s storage: %v", *to, err)
+	}
+	destinationState, err := readState(destination)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Could not read the %s storage: %v", *to, err)
+	}
+	log.Printf("[MIGRATOR] -> Destination (%s): %s.", *to, destinationState)
+
+	if !destinationState.isEmpty() {
+		log.Fatalf("[MIGRATOR] -> The %s storage is not empty, refusing to copy onto it.", *to)
+	}
+
+	if *dryRun {
+		log.Printf("[MIGRATOR] -> Dry run: %s would be copied from %s to %s.", sourceState, *from, *to)
+		return
+	}
+
+	err = copyState(sourceState, destination)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Copy failed, the %s storage has been partially written: %v", *to, err)
+	}
+
+	// verifying the copy
+	destinationState, err = readState(destination)
+	if err != nil {
+		log.Fatalf("[MIGRATOR] -> Could not read the %s storage back: %v", *to, err)
+	}
+	diffs := diffStates(sourceState, destinationState)
+	for _, diff := range diffs {
+		log.Printf("[MIGRATOR] -> Mismatch, %s.", diff)
+	}
+	if len(diffs) != 0 {
+		log.Fatalf("[MIGRATOR] -> Verification failed, %d mismatch(es) between %s and %s.", len(diffs), *from, *to)
+	}
+
+	log.Printf("[MIGRATOR] -> Copied and verified %s from %s to %s.", destinationState, *from, *to)
+}